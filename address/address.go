@@ -0,0 +1,262 @@
+// Package address validates cryptocurrency addresses offline, before coin is sent to them.
+//
+// A typo in a withdrawal address can't be undone, so every address is checked against its checksum: Base58Check for
+// BTC, LTC and DOGE, Bech32 and Bech32m for segwit addresses, EIP-55 for ETH and ERC-20 tokens, and the XRP and XLM
+// encodings. Addresses that are valid but probably not what was meant, ie: a testnet address for a mainnet coin, are
+// returned with warnings rather than errors.
+package address
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by Validate
+var (
+	ErrEmpty           = errors.New("address is empty")
+	ErrUnsupportedCoin = errors.New("coin is not supported by offline address validation")
+	ErrInvalidFormat   = errors.New("address is not in a valid format for the coin")
+	ErrInvalidChecksum = errors.New("address checksum does not match, it probably has a typo")
+	ErrInvalidDestTag  = errors.New("destination tag or memo is not valid for the coin")
+	ErrInvalidMerchant = errors.New("merchant id is not valid")
+	ErrInvalidPBNTag   = errors.New("$PayByName tag is not valid")
+)
+
+// Networks
+const (
+	Mainnet = "mainnet"
+	Testnet = "testnet"
+)
+
+// Address types
+const (
+	TypeP2PKH   = "p2pkh"
+	TypeP2SH    = "p2sh"
+	TypeP2WPKH  = "p2wpkh"
+	TypeP2WSH   = "p2wsh"
+	TypeP2TR    = "p2tr"
+	TypeWitness = "witness" // a segwit version with no type of its own yet
+	TypeAccount = "account" // ETH, XRP and XLM accounts
+)
+
+// Warnings returned with valid addresses
+const (
+	WarnTestnetAddress = "testnet address for a mainnet coin"
+	WarnMainnetAddress = "mainnet address for a testnet coin"
+	WarnNoChecksum     = "address has no EIP-55 checksum, so a typo can't be caught"
+	WarnNoDestTag      = "no destination tag or memo, exchanges and hosted wallets need one to credit the right account"
+	WarnSharedVersion  = "address uses the old P2SH version shared with BTC, make sure it isn't a bitcoin address"
+)
+
+// Result is a valid address
+type Result struct {
+	Coin     string   `json:"coin"`
+	Type     string   `json:"type"`
+	Network  string   `json:"network"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// family is how the addresses of a group of coins are encoded
+type family struct {
+	versions map[byte]version  // base58check version bytes
+	hrps     map[string]string // bech32 human readable parts, mapped to their network
+	validate func(addr, destTag string, res *Result) error
+}
+
+// version is what a base58check version byte stands for
+type version struct {
+	kind    string
+	network string
+	warning string
+}
+
+var (
+	bitcoin = &family{
+		versions: map[byte]version{
+			0x00: {TypeP2PKH, Mainnet, ""},
+			0x05: {TypeP2SH, Mainnet, ""},
+			0x6f: {TypeP2PKH, Testnet, ""},
+			0xc4: {TypeP2SH, Testnet, ""},
+		},
+		hrps: map[string]string{"bc": Mainnet, "tb": Testnet, "bcrt": Testnet},
+	}
+	litecoin = &family{
+		versions: map[byte]version{
+			0x30: {TypeP2PKH, Mainnet, ""},
+			0x32: {TypeP2SH, Mainnet, ""},
+			0x05: {TypeP2SH, Mainnet, WarnSharedVersion},
+			0x6f: {TypeP2PKH, Testnet, ""},
+			0x3a: {TypeP2SH, Testnet, ""},
+			0xc4: {TypeP2SH, Testnet, ""},
+		},
+		hrps: map[string]string{"ltc": Mainnet, "tltc": Testnet, "rltc": Testnet},
+	}
+	dogecoin = &family{
+		versions: map[byte]version{
+			0x1e: {TypeP2PKH, Mainnet, ""},
+			0x16: {TypeP2SH, Mainnet, ""},
+			0x71: {TypeP2PKH, Testnet, ""},
+			0xc4: {TypeP2SH, Testnet, ""},
+		},
+	}
+	ethereum = &family{validate: validateEthereum}
+	ripple   = &family{validate: validateRipple}
+	stellar  = &family{validate: validateStellar}
+)
+
+// coin is a coin we can validate the addresses of
+type coin struct {
+	family  *family
+	network string
+}
+
+// coins are keyed by their CoinPayments code
+var coins = map[string]coin{
+	"BTC":  {bitcoin, Mainnet},
+	"LTC":  {litecoin, Mainnet},
+	"LTCT": {litecoin, Testnet}, // the CoinPayments test coin, on the litecoin testnet
+	"DOGE": {dogecoin, Mainnet},
+	"ETH":  {ethereum, Mainnet},
+	"ETC":  {ethereum, Mainnet},
+	"XRP":  {ripple, Mainnet},
+	"XLM":  {stellar, Mainnet},
+}
+
+// lookup returns the coin, ERC-20 tokens included, ie: USDT.ERC20
+func lookup(code string) (coin, bool) {
+	code = strings.ToUpper(code)
+	if strings.HasSuffix(code, ".ERC20") {
+		return coin{ethereum, Mainnet}, true
+	}
+	c, ok := coins[code]
+	return c, ok
+}
+
+// Supported returns whether addresses of the coin can be validated
+func Supported(coin string) bool {
+	_, ok := lookup(coin)
+	return ok
+}
+
+// Validate checks the address, and destination tag or memo if any, is valid for the coin. It returns
+// ErrUnsupportedCoin for coins it doesn't know.
+func Validate(code, addr, destTag string) (*Result, error) {
+	c, ok := lookup(code)
+	if !ok {
+		return nil, ErrUnsupportedCoin
+	}
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return nil, ErrEmpty
+	}
+
+	res := &Result{Coin: strings.ToUpper(code)}
+	var err error
+	switch {
+	case c.family.validate != nil:
+		err = c.family.validate(addr, destTag, res)
+	case c.family.hrps[hrpOf(addr)] != "":
+		// a base58 address can start the same way by chance
+		if err = validateSegwit(c.family, addr, res); err != nil && validateBase58(c.family, addr, res) == nil {
+			err = nil
+		}
+	default:
+		err = validateBase58(c.family, addr, res)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c.network == Mainnet && res.Network == Testnet:
+		res.Warnings = append(res.Warnings, WarnTestnetAddress)
+	case c.network == Testnet && res.Network == Mainnet:
+		res.Warnings = append(res.Warnings, WarnMainnetAddress)
+	}
+	return res, nil
+}
+
+// hrpOf returns the lower cased human readable part of a bech32 address
+func hrpOf(addr string) string {
+	i := strings.LastIndex(addr, "1")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(addr[:i])
+}
+
+// validateBase58 checks a Base58Check address against the version bytes of the family
+func validateBase58(f *family, addr string, res *Result) error {
+	payload, err := decodeBase58Check(bitcoinAlphabet, addr)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 21 {
+		return ErrInvalidFormat
+	}
+	v, ok := f.versions[payload[0]]
+	if !ok {
+		return ErrInvalidFormat
+	}
+	res.Type, res.Network = v.kind, v.network
+	if v.warning != "" {
+		res.Warnings = append(res.Warnings, v.warning)
+	}
+	return nil
+}
+
+// validateSegwit checks a Bech32 or Bech32m segwit address
+func validateSegwit(f *family, addr string, res *Result) error {
+	witnessVersion, program, err := decodeSegwit(addr)
+	if err != nil {
+		return err
+	}
+	res.Network = f.hrps[hrpOf(addr)]
+	switch {
+	case witnessVersion == 0 && len(program) == 20:
+		res.Type = TypeP2WPKH
+	case witnessVersion == 0 && len(program) == 32:
+		res.Type = TypeP2WSH
+	case witnessVersion == 1 && len(program) == 32:
+		res.Type = TypeP2TR
+	default:
+		res.Type = TypeWitness
+	}
+	return nil
+}
+
+// validateDestTag checks an XRP destination tag, a 32 bit unsigned integer
+func validateDestTag(tag string) error {
+	if _, err := strconv.ParseUint(tag, 10, 32); err != nil {
+		return ErrInvalidDestTag
+	}
+	return nil
+}
+
+// ValidateMerchantID checks a CoinPayments merchant id, which transfers are sent to, is 32 hex characters
+func ValidateMerchantID(id string) error {
+	if len(id) != 32 {
+		return ErrInvalidMerchant
+	}
+	for _, r := range id {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return ErrInvalidMerchant
+		}
+	}
+	return nil
+}
+
+// ValidatePBNTag checks a $PayByName tag, with or without its leading $, ie: $CoinPayments
+func ValidatePBNTag(tag string) error {
+	name := strings.TrimPrefix(tag, "$")
+	if name == "" || len(name) > 64 {
+		return ErrInvalidPBNTag
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return ErrInvalidPBNTag
+		}
+	}
+	return nil
+}
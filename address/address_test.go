@@ -0,0 +1,116 @@
+package address_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments/address"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		coin, addr, tag string
+		kind, network   string
+		warnings        []string
+	}{
+		{"BTC", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", "", address.TypeP2PKH, address.Mainnet, nil},
+		{"btc", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "", address.TypeP2SH, address.Mainnet, nil},
+		{"BTC", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "", address.TypeP2WPKH, address.Mainnet, nil},
+		{"BTC", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "", address.TypeP2TR, address.Mainnet, nil},
+		{"BTC", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "", address.TypeP2WSH, address.Testnet, []string{address.WarnTestnetAddress}},
+		{"BTC", "2MsLZ5FqqYpjM1Q1W4X81zMVZTF9gdbhVwd", "", address.TypeP2SH, address.Testnet, []string{address.WarnTestnetAddress}},
+		{"LTC", "LKKHMBjCU89fyFNgSRprDoD8Jb25N8uWvd", "", address.TypeP2PKH, address.Mainnet, nil},
+		{"LTC", "M7zVKQKmtV5Rc7erVGVVC3khZbXxsS5HEX", "", address.TypeP2SH, address.Mainnet, nil},
+		{"LTC", "31nM1WuowNDzocNxPPW9NQWJEtwWpjfcLj", "", address.TypeP2SH, address.Mainnet, []string{address.WarnSharedVersion}},
+		{"LTC", "ltc1qqypqxpq9qcrsszg2pvxq6rs0zqg3yyc5dyg36p", "", address.TypeP2WPKH, address.Mainnet, nil},
+		{"LTCT", "tltc1qqypqxpq9qcrsszg2pvxq6rs0zqg3yyc56ktcft", "", address.TypeP2WPKH, address.Testnet, nil},
+		{"LTCT", "LKKHMBjCU89fyFNgSRprDoD8Jb25N8uWvd", "", address.TypeP2PKH, address.Mainnet, []string{address.WarnMainnetAddress}},
+		{"DOGE", "D5ERdEN1gsouFSs7zsq7VYJxyWP6dP28H1", "", address.TypeP2PKH, address.Mainnet, nil},
+		{"DOGE", "9rXbkMyi1S6thykRoXAZcY8fwUKYsy6cXE", "", address.TypeP2SH, address.Mainnet, nil},
+		{"DOGE", "nUHVMF6vcrGd8RSK2hUZjwuGDNmPeNoBRb", "", address.TypeP2PKH, address.Testnet, []string{address.WarnTestnetAddress}},
+		{"ETH", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "", address.TypeAccount, address.Mainnet, nil},
+		{"USDT.ERC20", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", "", address.TypeAccount, address.Mainnet, nil},
+		{"ETH", "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB", "", address.TypeAccount, address.Mainnet, nil},
+		{"ETH", "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb", "", address.TypeAccount, address.Mainnet, nil},
+		{"ETH", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "", address.TypeAccount, address.Mainnet, []string{address.WarnNoChecksum}},
+		{"XRP", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", "12345", address.TypeAccount, address.Mainnet, nil},
+		{"XRP", "rpqBmAP7Q5L4nVTAhowxcVd4zTfwDjVAvw", "", address.TypeAccount, address.Mainnet, []string{address.WarnNoDestTag}},
+		{"XRP", "X7ZWqnQwuGw9k5vjMDavafRdDPdECujodEGV7rLmPrBnVLb", "", address.TypeAccount, address.Mainnet, nil},
+		{"XRP", "X7ZWqnQwuGw9k5vjMDavafRdDPdECujodEGV7rLmPrBnVLb", "12345", address.TypeAccount, address.Mainnet, nil},
+		{"XRP", "X7ZWqnQwuGw9k5vjMDavafRdDPdECudtqcMQnDHouLLoLeG", "", address.TypeAccount, address.Mainnet, []string{address.WarnNoDestTag}},
+		{"XRP", "T7VsEBZ9mD5BUN6LdrqgzfBXgqwhBV4teSHeYyk9zKwzE5o", "", address.TypeAccount, address.Testnet, []string{address.WarnTestnetAddress}},
+		{"XLM", "GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZX", "order 42", address.TypeAccount, address.Mainnet, nil},
+		{"XLM", "GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZX", "", address.TypeAccount, address.Mainnet, []string{address.WarnNoDestTag}},
+		{"XLM", "MAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB6AAAAAAAAAAAMOG4G", "", address.TypeAccount, address.Mainnet, nil},
+	}
+	for _, tt := range tests {
+		res, err := address.Validate(tt.coin, tt.addr, tt.tag)
+		if err != nil {
+			t.Errorf("Should have validated %s %s, but it threw error: %s", tt.coin, tt.addr, err.Error())
+			continue
+		}
+		if res.Type != tt.kind || res.Network != tt.network || len(res.Warnings) != len(tt.warnings) {
+			t.Errorf("Should have had the type, network and warnings of %s %s, got %+v", tt.coin, tt.addr, res)
+			continue
+		}
+		for i := range tt.warnings {
+			if res.Warnings[i] != tt.warnings[i] {
+				t.Errorf("Should have warned %q about %s %s, got %q", tt.warnings[i], tt.coin, tt.addr, res.Warnings[i])
+			}
+		}
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	tests := []struct {
+		coin, addr, tag string
+		err             error
+	}{
+		{"BTC", "", "", address.ErrEmpty},
+		{"BCH", "qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", "", address.ErrUnsupportedCoin},
+		{"BTC", "1BoatSLRHtKNngkdXEeobR76b53LETtpyU", "", address.ErrInvalidChecksum},
+		{"BTC", "1BoatSLRHtKNngkdXEeobR76b53LETtpy0", "", address.ErrInvalidFormat},
+		{"BTC", "LKKHMBjCU89fyFNgSRprDoD8Jb25N8uWvd", "", address.ErrInvalidFormat},
+		{"LTC", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", "", address.ErrInvalidFormat},
+		{"BTC", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", "", address.ErrInvalidChecksum},
+		{"BTC", "bc1qqypqxpq9qcrsszg2pvxq6rs0zqg3yyc5uyze8n", "", address.ErrInvalidChecksum}, // v0 with a Bech32m checksum
+		{"BTC", "bc1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "", address.ErrInvalidFormat},
+		{"DOGE", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "", address.ErrInvalidFormat},
+		{"ETH", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", "", address.ErrInvalidChecksum},
+		{"ETH", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", "", address.ErrInvalidFormat},
+		{"ETH", "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed00", "", address.ErrInvalidFormat},
+		{"XRP", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTi", "", address.ErrInvalidChecksum},
+		{"XRP", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", "tag", address.ErrInvalidDestTag},
+		{"XRP", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", "4294967296", address.ErrInvalidDestTag},
+		{"XRP", "X7ZWqnQwuGw9k5vjMDavafRdDPdECujodEGV7rLmPrBnVLb", "54321", address.ErrInvalidDestTag},
+		{"XLM", "GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZY", "", address.ErrInvalidChecksum},
+		{"XLM", "GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZX", "a memo that is far too long to fit", address.ErrInvalidDestTag},
+		{"XLM", "MAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB6AAAAAAAAAAAMOG4G", "99", address.ErrInvalidDestTag},
+	}
+	for _, tt := range tests {
+		if _, err := address.Validate(tt.coin, tt.addr, tt.tag); err != tt.err {
+			t.Errorf("Should have refused %s %s with %v, got %v", tt.coin, tt.addr, tt.err, err)
+		}
+	}
+}
+
+func TestValidateMerchantAndPBNTag(t *testing.T) {
+	if err := address.ValidateMerchantID("0123456789abcdef0123456789ABCDEF"); err != nil {
+		t.Error(err)
+	}
+	for _, id := range []string{"", "merchantid", "0123456789abcdef0123456789abcdeg"} {
+		if err := address.ValidateMerchantID(id); err != address.ErrInvalidMerchant {
+			t.Errorf("Should have refused merchant id %q, got %v", id, err)
+		}
+	}
+
+	for _, tag := range []string{"$CoinPayments", "supplier_1"} {
+		if err := address.ValidatePBNTag(tag); err != nil {
+			t.Errorf("Should have accepted tag %q, but it threw error: %s", tag, err.Error())
+		}
+	}
+	for _, tag := range []string{"", "$", "$two words", "$$double"} {
+		if err := address.ValidatePBNTag(tag); err != address.ErrInvalidPBNTag {
+			t.Errorf("Should have refused tag %q, got %v", tag, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package address
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+	"strings"
+)
+
+// Base58 alphabets. XRP uses its own ordering of the same characters.
+const (
+	bitcoinAlphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	rippleAlphabet  = "rpshnaf39wBUDNEGHJKLM4PQRST7VWXYZ2bcdeCg65jkm8oFqi1tuvAxyz"
+)
+
+// decodeBase58 decodes a base58 string. Each leading zero digit stands for a leading zero byte.
+func decodeBase58(alphabet, s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range s {
+		i := strings.IndexRune(alphabet, r)
+		if i < 0 {
+			return nil, ErrInvalidFormat
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	zeros := 0
+	for zeros < len(s) && s[zeros] == alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// decodeBase58Check decodes a base58 string ending in the first 4 bytes of the double SHA-256 of the rest, and
+// returns the rest
+func decodeBase58Check(alphabet, s string) ([]byte, error) {
+	decoded, err := decodeBase58(alphabet, s)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) < 5 {
+		return nil, ErrInvalidFormat
+	}
+	payload, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return nil, ErrInvalidChecksum
+	}
+	return payload, nil
+}
@@ -0,0 +1,111 @@
+package address
+
+import "strings"
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Checksum constants of BIP 173 and BIP 350
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// bech32Polymod is the BCH checksum of BIP 173
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// decodeBech32 decodes a Bech32 or Bech32m string into its human readable part, its 5 bit data without the checksum,
+// and the checksum constant it used
+func decodeBech32(s string) (string, []byte, uint32, error) {
+	if len(s) > 90 || (strings.ToLower(s) != s && strings.ToUpper(s) != s) {
+		return "", nil, 0, ErrInvalidFormat
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndex(s, "1")
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, 0, ErrInvalidFormat
+	}
+
+	hrp := s[:sep]
+	values := make([]byte, 0, len(hrp)*2+1+len(s)-sep-1)
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, ErrInvalidFormat
+		}
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	for _, r := range s[sep+1:] {
+		i := strings.IndexRune(bech32Charset, r)
+		if i < 0 {
+			return "", nil, 0, ErrInvalidFormat
+		}
+		values = append(values, byte(i))
+	}
+
+	constant := bech32Polymod(values)
+	if constant != bech32Const && constant != bech32mConst {
+		return "", nil, 0, ErrInvalidChecksum
+	}
+	data := values[len(hrp)*2+1 : len(values)-6]
+	return hrp, data, constant, nil
+}
+
+// convertBits regroups 5 bit values into bytes, rejecting padding that isn't zero
+func convertBits(data []byte, from, to uint) ([]byte, bool) {
+	var acc, bits uint
+	var out []byte
+	maxv := uint(1)<<to - 1
+	for _, v := range data {
+		acc = acc<<from | uint(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if bits >= from || (acc<<(to-bits))&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// decodeSegwit decodes a segwit address into its witness version and program. Version 0 must use Bech32 and later
+// versions Bech32m, so a checksum of the wrong kind is a typo too.
+func decodeSegwit(addr string) (int, []byte, error) {
+	_, data, constant, err := decodeBech32(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 1 || data[0] > 16 {
+		return 0, nil, ErrInvalidFormat
+	}
+	witnessVersion := int(data[0])
+	if (witnessVersion == 0) != (constant == bech32Const) {
+		return 0, nil, ErrInvalidChecksum
+	}
+
+	program, ok := convertBits(data[1:], 5, 8)
+	if !ok || len(program) < 2 || len(program) > 40 {
+		return 0, nil, ErrInvalidFormat
+	}
+	if witnessVersion == 0 && len(program) != 20 && len(program) != 32 {
+		return 0, nil, ErrInvalidFormat
+	}
+	return witnessVersion, program, nil
+}
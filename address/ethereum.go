@@ -0,0 +1,128 @@
+package address
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"strings"
+)
+
+// validateEthereum checks an ETH or ERC-20 address. Mixed case addresses carry an EIP-55 checksum, all lower or upper
+// case ones don't and get a warning.
+func validateEthereum(addr, destTag string, res *Result) error {
+	if len(addr) != 42 || !(strings.HasPrefix(addr, "0x") || strings.HasPrefix(addr, "0X")) {
+		return ErrInvalidFormat
+	}
+	hexPart := addr[2:]
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return ErrInvalidFormat
+	}
+	res.Type, res.Network = TypeAccount, Mainnet
+
+	if hexPart == strings.ToLower(hexPart) || hexPart == strings.ToUpper(hexPart) {
+		res.Warnings = append(res.Warnings, WarnNoChecksum)
+		return nil
+	}
+	if checksumEthereum(hexPart) != hexPart {
+		return ErrInvalidChecksum
+	}
+	return nil
+}
+
+// checksumEthereum returns the 40 hex characters of an address with the case EIP-55 gives them: letters are upper
+// case where the matching nibble of the Keccak-256 of the lower case address is 8 or more.
+func checksumEthereum(hexPart string) string {
+	lower := strings.ToLower(hexPart)
+	hash := keccak256([]byte(lower))
+	out := []byte(lower)
+	for i, c := range out {
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0xf
+		}
+		if c >= 'a' && nibble >= 8 {
+			out[i] = c - 32
+		}
+	}
+	return string(out)
+}
+
+// Keccak-f[1600] round constants and rotation offsets, indexed by x+5y
+var (
+	keccakRoundConstants = [24]uint64{
+		0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+		0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+		0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+		0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+		0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+		0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+	}
+	keccakRotations = [25]int{
+		0, 1, 62, 28, 27,
+		36, 44, 6, 55, 20,
+		3, 10, 43, 25, 39,
+		41, 45, 15, 21, 8,
+		18, 2, 61, 56, 14,
+	}
+)
+
+// keccakF1600 is the Keccak permutation
+func keccakF1600(a *[25]uint64) {
+	for round := 0; round < 24; round++ {
+		// theta
+		var c [5]uint64
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[y+x] ^= d
+			}
+		}
+
+		// rho and pi
+		var b [25]uint64
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], keccakRotations[x+5*y])
+			}
+		}
+
+		// chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				a[y+x] = b[y+x] ^ (^b[y+(x+1)%5] & b[y+(x+2)%5])
+			}
+		}
+
+		// iota
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
+
+// keccak256 is the original Keccak-256 Ethereum uses, which pads differently from the standardised SHA3-256
+func keccak256(data []byte) [32]byte {
+	const rate = 136
+	padded := make([]byte, len(data), len(data)+rate)
+	copy(padded, data)
+	padded = append(padded, 0x01)
+	for len(padded)%rate != 0 {
+		padded = append(padded, 0)
+	}
+	padded[len(padded)-1] |= 0x80
+
+	var state [25]uint64
+	for block := 0; block < len(padded); block += rate {
+		for i := 0; i < rate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(padded[block+i*8:])
+		}
+		keccakF1600(&state)
+	}
+
+	var out [32]byte
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(out[i*8:], state[i])
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package address
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// X-address prefixes, which carry the destination tag in the address
+var (
+	xAddressMainnet = []byte{0x05, 0x44}
+	xAddressTestnet = []byte{0x04, 0x93}
+)
+
+// validateRipple checks an XRP classic address, with its destination tag, or an X-address
+func validateRipple(addr, destTag string, res *Result) error {
+	res.Type = TypeAccount
+	if strings.HasPrefix(addr, "X") || strings.HasPrefix(addr, "T") {
+		return validateXAddress(addr, destTag, res)
+	}
+	if !strings.HasPrefix(addr, "r") {
+		return ErrInvalidFormat
+	}
+
+	payload, err := decodeBase58Check(rippleAlphabet, addr)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 21 || payload[0] != 0 {
+		return ErrInvalidFormat
+	}
+	res.Network = Mainnet // classic addresses are the same on every network
+	if destTag == "" {
+		res.Warnings = append(res.Warnings, WarnNoDestTag)
+		return nil
+	}
+	return validateDestTag(destTag)
+}
+
+// validateXAddress checks an XRP X-address. A tag in the address can't be overridden with a different one.
+func validateXAddress(addr, destTag string, res *Result) error {
+	payload, err := decodeBase58Check(rippleAlphabet, addr)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 31 || payload[22] > 1 {
+		return ErrInvalidFormat
+	}
+	switch string(payload[:2]) {
+	case string(xAddressMainnet):
+		res.Network = Mainnet
+	case string(xAddressTestnet):
+		res.Network = Testnet
+	default:
+		return ErrInvalidFormat
+	}
+
+	if payload[22] == 0 {
+		if destTag == "" {
+			res.Warnings = append(res.Warnings, WarnNoDestTag)
+			return nil
+		}
+		return validateDestTag(destTag)
+	}
+	tag := binary.LittleEndian.Uint64(payload[23:])
+	if tag > 0xffffffff {
+		return ErrInvalidFormat
+	}
+	if destTag != "" && destTag != strconv.FormatUint(tag, 10) {
+		return ErrInvalidDestTag
+	}
+	return nil
+}
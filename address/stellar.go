@@ -0,0 +1,64 @@
+package address
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"strings"
+)
+
+// Stellar StrKey version bytes
+const (
+	stellarAccount = 6 << 3  // G..., an account
+	stellarMuxed   = 12 << 3 // M..., an account with a memo id built in
+)
+
+// validateStellar checks an XLM account or muxed account, and its memo. Memos are either an id or up to 28 bytes of
+// text.
+func validateStellar(addr, destTag string, res *Result) error {
+	if strings.ToUpper(addr) != addr {
+		return ErrInvalidFormat
+	}
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(addr)
+	if err != nil || len(decoded) < 3 {
+		return ErrInvalidFormat
+	}
+	payload, checksum := decoded[:len(decoded)-2], decoded[len(decoded)-2:]
+	if crc16XModem(payload) != binary.LittleEndian.Uint16(checksum) {
+		return ErrInvalidChecksum
+	}
+
+	res.Type, res.Network = TypeAccount, Mainnet // like XRP, addresses are the same on every network
+	switch {
+	case payload[0] == stellarAccount && len(payload) == 33:
+		if destTag == "" {
+			res.Warnings = append(res.Warnings, WarnNoDestTag)
+		}
+	case payload[0] == stellarMuxed && len(payload) == 41:
+		if destTag != "" {
+			// the memo id is in the address, a memo on top of it would be ambiguous
+			return ErrInvalidDestTag
+		}
+	default:
+		return ErrInvalidFormat
+	}
+	if len(destTag) > 28 {
+		return ErrInvalidDestTag
+	}
+	return nil
+}
+
+// crc16XModem is the checksum of a Stellar StrKey
+func crc16XModem(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,192 @@
+package coinpayments
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAddressNotFound is returned by an AddressStore when there is no matching address
+var ErrAddressNotFound = errors.New("address not found in address book")
+
+// AddressEntry is a callback address assigned to one of our customers for a single currency.
+type AddressEntry struct {
+	CustomerID string    `json:"customer_id"`
+	Currency   string    `json:"currency"`
+	Address    string    `json:"address"`
+	DestTag    string    `json:"dest_tag,omitempty"` // for coins needing a destination tag, where the address itself is shared
+	PubKey     string    `json:"pubkey,omitempty"`   // NXT only
+	Label      string    `json:"label,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	RetiredAt  time.Time `json:"retired_at"` // set once the address has been rotated out
+}
+
+// Retired returns whether the address has been replaced by a newer one. Deposits to retired addresses still
+// resolve back to the customer.
+func (e *AddressEntry) Retired() bool {
+	return !e.RetiredAt.IsZero()
+}
+
+// AddressStore persists the addresses handed out by an AddressBook.
+// CurrentAddress and LookupAddress return ErrAddressNotFound if nothing matches.
+type AddressStore interface {
+	// SaveAddress inserts the entry, or updates it if the currency, address and dest tag are already stored
+	SaveAddress(e *AddressEntry) error
+	// CurrentAddress returns the newest address of the customer for the currency that hasn't been retired
+	CurrentAddress(customerID, currency string) (*AddressEntry, error)
+	// LookupAddress returns the entry for an address, retired or not
+	LookupAddress(currency, address, destTag string) (*AddressEntry, error)
+}
+
+// MemoryAddressStore is an AddressStore that keeps everything in memory
+type MemoryAddressStore struct {
+	mu      sync.RWMutex
+	entries map[string]AddressEntry
+}
+
+// NewMemoryAddressStore returns an empty MemoryAddressStore
+func NewMemoryAddressStore() *MemoryAddressStore {
+	return &MemoryAddressStore{entries: map[string]AddressEntry{}}
+}
+
+// addressKey builds the key an address is stored under
+func addressKey(currency, address, destTag string) string {
+	return strings.ToUpper(currency) + "|" + address + "|" + destTag
+}
+
+// SaveAddress implements the AddressStore interface
+func (s *MemoryAddressStore) SaveAddress(e *AddressEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[addressKey(e.Currency, e.Address, e.DestTag)] = *e
+	return nil
+}
+
+// CurrentAddress implements the AddressStore interface
+func (s *MemoryAddressStore) CurrentAddress(customerID, currency string) (*AddressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var current *AddressEntry
+	for _, e := range s.entries {
+		if e.CustomerID != customerID || !strings.EqualFold(e.Currency, currency) || e.Retired() {
+			continue
+		}
+		if current == nil || e.CreatedAt.After(current.CreatedAt) {
+			e := e
+			current = &e
+		}
+	}
+
+	if current == nil {
+		return nil, ErrAddressNotFound
+	}
+	return current, nil
+}
+
+// LookupAddress implements the AddressStore interface
+func (s *MemoryAddressStore) LookupAddress(currency, address, destTag string) (*AddressEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.entries[addressKey(currency, address, destTag)]
+	if !ok {
+		return nil, ErrAddressNotFound
+	}
+	return &e, nil
+}
+
+// AddressBook hands out a callback address per customer and currency using get_callback_address, and resolves
+// deposits made to those addresses back to the customer.
+type AddressBook struct {
+	client *Client
+	store  AddressStore
+	mu     sync.Mutex // serializes address creation so a customer never gets two addresses at once
+	now    func() time.Time
+
+	// IPNURL is sent with every new address, falling back to the IPNURL of the client
+	IPNURL string
+	// Label returns the label for a new address. Defaults to the customer id.
+	Label func(customerID, currency string) string
+}
+
+// NewAddressBook returns an AddressBook that creates addresses with the client and keeps them in the store
+func NewAddressBook(client *Client, store AddressStore) *AddressBook {
+	return &AddressBook{client: client, store: store, now: time.Now}
+}
+
+// Address returns the current address of the customer for the currency, creating one if they don't have one yet.
+func (b *AddressBook) Address(customerID, currency string) (*AddressEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, err := b.store.CurrentAddress(customerID, currency)
+	if err != ErrAddressNotFound {
+		return e, err
+	}
+	return b.create(customerID, currency)
+}
+
+// Rotate retires the current address of the customer for the currency, if any, and assigns a new one.
+func (b *AddressBook) Rotate(customerID, currency string) (*AddressEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, err := b.store.CurrentAddress(customerID, currency)
+	if err != nil && err != ErrAddressNotFound {
+		return nil, err
+	}
+
+	e, err := b.create(customerID, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if old != nil {
+		old.RetiredAt = b.now()
+		if err := b.store.SaveAddress(old); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Resolve returns the address entry a deposit IPN was sent for, and so the customer it belongs to.
+func (b *AddressBook) Resolve(resp *IPNDepositResponse) (*AddressEntry, error) {
+	return b.store.LookupAddress(resp.Currency, resp.Address, resp.DestTag)
+}
+
+// create asks the API for a new callback address and stores it for the customer
+func (b *AddressBook) create(customerID, currency string) (*AddressEntry, error) {
+	label := customerID
+	if b.Label != nil {
+		label = b.Label(customerID, currency)
+	}
+	ipnURL := b.IPNURL
+	if ipnURL == "" {
+		ipnURL = b.client.IPNURL
+	}
+
+	resp, err := b.client.CallGetCallbackAddress(&CallbackAddressRequest{Currency: currency, IPNURL: ipnURL, Label: label})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Result == nil || resp.Result.Address == "" {
+		return nil, errors.New("get_callback_address returned no address")
+	}
+
+	e := &AddressEntry{
+		CustomerID: customerID,
+		Currency:   strings.ToUpper(currency),
+		Address:    resp.Result.Address,
+		DestTag:    resp.Result.DestTag,
+		PubKey:     resp.Result.PubKey,
+		Label:      label,
+		CreatedAt:  b.now(),
+	}
+	if err := b.store.SaveAddress(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,55 @@
+package coinpayments_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestAddressBook(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetCallbackAddress: {
+			`{"error":"ok","result":{"address":"addr-1"}}`,
+			`{"error":"ok","result":{"address":"addr-2"}}`,
+		},
+	}}
+	book := coinpayments.NewAddressBook(fakeClient(t, api), coinpayments.NewMemoryAddressStore())
+
+	first, err := book.Address("customer-1", "BTC")
+	if err != nil {
+		t.Fatalf("Should have assigned an address, but it threw error: %s", err.Error())
+	}
+	again, err := book.Address("customer-1", "BTC")
+	if err != nil {
+		t.Fatalf("Should have returned the assigned address, but it threw error: %s", err.Error())
+	}
+	if first.Address != "addr-1" || again.Address != "addr-1" {
+		t.Fatalf("Should have kept handing out the same address, got %s and %s", first.Address, again.Address)
+	}
+
+	calls := api.callsFor(coinpayments.CmdGetCallbackAddress)
+	if len(calls) != 1 || calls[0].Get("label") != "customer-1" {
+		t.Fatalf("Should have made a single labelled call, got %v", calls)
+	}
+
+	rotated, err := book.Rotate("customer-1", "BTC")
+	if err != nil {
+		t.Fatalf("Should have rotated the address, but it threw error: %s", err.Error())
+	}
+	if rotated.Address != "addr-2" {
+		t.Fatalf("Should have assigned a new address, got %s", rotated.Address)
+	}
+
+	// deposits to the retired address still belong to the customer
+	old, err := book.Resolve(&coinpayments.IPNDepositResponse{Address: "addr-1", Currency: "btc"})
+	if err != nil {
+		t.Fatalf("Should have resolved the deposit, but it threw error: %s", err.Error())
+	}
+	if old.CustomerID != "customer-1" || !old.Retired() {
+		t.Fatalf("Resolved the wrong entry: %+v", old)
+	}
+
+	if _, err := book.Resolve(&coinpayments.IPNDepositResponse{Address: "unknown", Currency: "BTC"}); err != coinpayments.ErrAddressNotFound {
+		t.Fatalf("Should have failed on an unknown address, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package coinpayments
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// satoshiDecimals is the number of decimals CoinPayments uses for every coin amount
+const satoshiDecimals = 8
+
+// ErrInvalidAmount is returned when an amount can't be parsed
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ParseSatoshis parses a decimal amount as sent by the API, ie: "0.00150000", into an integer number of
+// satoshis (1e-8 of a coin). Amounts with more than 8 decimals are rejected rather than rounded.
+func ParseSatoshis(amount string) (int64, error) {
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return 0, ErrInvalidAmount
+	}
+
+	negative := false
+	if amount[0] == '-' || amount[0] == '+' {
+		negative = amount[0] == '-'
+		amount = amount[1:]
+	}
+
+	whole, frac := amount, ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		whole, frac = amount[:i], amount[i+1:]
+	}
+	frac = strings.TrimRight(frac, "0")
+	if whole == "" && frac == "" || len(frac) > satoshiDecimals {
+		return 0, ErrInvalidAmount
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	frac += strings.Repeat("0", satoshiDecimals-len(frac))
+
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return 0, ErrInvalidAmount
+		}
+	}
+
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+// FormatSatoshis formats an integer number of satoshis as a decimal amount with 8 decimals, the way the API does.
+func FormatSatoshis(n int64) string {
+	sign := ""
+	u := uint64(n)
+	if n < 0 {
+		sign = "-"
+		u = uint64(-n)
+	}
+
+	s := strconv.FormatUint(u, 10)
+	if len(s) <= satoshiDecimals {
+		s = strings.Repeat("0", satoshiDecimals-len(s)+1) + s
+	}
+	return sign + s[:len(s)-satoshiDecimals] + "." + s[len(s)-satoshiDecimals:]
+}
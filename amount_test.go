@@ -0,0 +1,46 @@
+package coinpayments_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestParseSatoshis(t *testing.T) {
+	cases := map[string]int64{
+		"1":           100000000,
+		"0.00150000":  150000,
+		".5":          50000000,
+		"-0.00000001": -1,
+		"12.3":        1230000000,
+	}
+	for in, expected := range cases {
+		n, err := coinpayments.ParseSatoshis(in)
+		if err != nil {
+			t.Fatalf("Should have parsed %s, but it threw error: %s", in, err.Error())
+		}
+		if n != expected {
+			t.Fatalf("Expected %s to be %d satoshis, got %d", in, expected, n)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "0.000000001", "1.2.3", "."} {
+		if _, err := coinpayments.ParseSatoshis(in); err != coinpayments.ErrInvalidAmount {
+			t.Fatalf("Should have failed to parse %q, got %v", in, err)
+		}
+	}
+}
+
+func TestFormatSatoshis(t *testing.T) {
+	cases := map[int64]string{
+		100000000: "1.00000000",
+		150000:    "0.00150000",
+		-1:        "-0.00000001",
+		0:         "0.00000000",
+	}
+	for in, expected := range cases {
+		if s := coinpayments.FormatSatoshis(in); s != expected {
+			t.Fatalf("Expected %d to format as %s, got %s", in, expected, s)
+		}
+	}
+}
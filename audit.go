@@ -0,0 +1,227 @@
+package coinpayments
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditedCommands are the commands that change something on our account, and so get recorded in the AuditLog
+var auditedCommands = []string{CmdCreateTransaction, CmdCreateTransfer, CmdCreateWithdrawal, CmdConvertCoins}
+
+// redactedParams are never written to the audit log
+var redactedParams = []string{"key"}
+
+// Audit entry phases. Every audited call writes a request entry before it's sent, and a response entry once it's
+// done, so a call that never got a response still shows up.
+const (
+	AuditRequest  = "request"
+	AuditResponse = "response"
+)
+
+// AuditEntry is a single entry of the audit log
+type AuditEntry struct {
+	Seq        uint64            `json:"seq"`
+	Time       time.Time         `json:"time"`
+	CallID     string            `json:"call_id"` // shared by the request and response entries of a call
+	Phase      string            `json:"phase"`
+	Initiator  string            `json:"initiator"`
+	Cmd        string            `json:"cmd"`
+	Params     map[string]string `json:"params,omitempty"`
+	Response   json.RawMessage   `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	DurationMS int64             `json:"duration_ms,omitempty"`
+	PrevHash   string            `json:"prev_hash"`
+	Hash       string            `json:"hash"`
+}
+
+// computeHash returns the hash of the entry, which covers every field but the hash itself
+func (e *AuditEntry) computeHash() string {
+	cp := *e
+	cp.Hash = ""
+	data, _ := json.Marshal(cp)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog records the mutating calls made through a client. Implementations fill in Seq, PrevHash and Hash.
+type AuditLog interface {
+	Record(e *AuditEntry) error
+}
+
+// FileAuditLog is an AuditLog that appends hash chained entries to a JSON Lines file. Each entry carries the hash of
+// the one before it, so editing or deleting an entry breaks the chain, which VerifyAuditLog detects.
+type FileAuditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	lastHash string
+}
+
+// OpenFileAuditLog opens, or creates, the audit log at path, continuing the chain of the entries already in it
+func OpenFileAuditLog(path string) (*FileAuditLog, error) {
+	result, err := VerifyAuditLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &FileAuditLog{file: f}
+	if result != nil {
+		l.seq, l.lastHash = uint64(result.Entries), result.LastHash
+	}
+	return l, nil
+}
+
+// Record implements the AuditLog interface
+func (l *FileAuditLog) Record(e *AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.PrevHash = l.lastHash
+	e.Hash = e.computeHash()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.seq, l.lastHash = e.Seq, e.Hash
+	return nil
+}
+
+// Close closes the underlying file
+func (l *FileAuditLog) Close() error {
+	return l.file.Close()
+}
+
+// AuditVerification is the result of verifying an audit log. Keep LastHash somewhere else, ie: in your monitoring,
+// to also detect entries being cut off the end of the log.
+type AuditVerification struct {
+	Entries  int
+	LastHash string
+
+	hashes map[string]bool
+}
+
+// Contains returns whether an entry with the hash is still in the log, ie: a LastHash kept from an earlier check
+func (v *AuditVerification) Contains(hash string) bool {
+	return v.hashes[hash]
+}
+
+// AuditChainError is returned when an audit log has been tampered with
+type AuditChainError struct {
+	Line   int
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("audit log broken at line %d: %s", e.Line, e.Reason)
+}
+
+// VerifyAuditLog walks the audit log at path and returns an *AuditChainError at the first entry that was edited,
+// removed or inserted.
+func VerifyAuditLog(path string) (*AuditVerification, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := &AuditVerification{hashes: map[string]bool{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, &AuditChainError{Line: line, Reason: "entry is not valid json"}
+		}
+		if e.Seq != uint64(result.Entries+1) {
+			return nil, &AuditChainError{Line: line, Reason: fmt.Sprintf("expected seq %d, got %d", result.Entries+1, e.Seq)}
+		}
+		if e.PrevHash != result.LastHash {
+			return nil, &AuditChainError{Line: line, Reason: "prev_hash does not match the entry before it"}
+		}
+		if e.computeHash() != e.Hash {
+			return nil, &AuditChainError{Line: line, Reason: "entry does not match its hash"}
+		}
+		result.Entries++
+		result.LastHash = e.Hash
+		result.hashes[e.Hash] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// auditParams copies the params of a call, leaving out the redacted ones
+func auditParams(data url.Values) map[string]string {
+	params := make(map[string]string, len(data))
+	for k := range data {
+		if stringExistsInSlice(redactedParams, k) {
+			continue
+		}
+		params[k] = data.Get(k)
+	}
+	return params
+}
+
+// auditRequest records a mutating call before it's sent. If it can't be recorded, the call must not be made.
+func (c *Client) auditRequest(cmd string, data url.Values) (*AuditEntry, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	e := &AuditEntry{
+		Time:      time.Now(),
+		CallID:    hex.EncodeToString(id),
+		Phase:     AuditRequest,
+		Initiator: c.Initiator,
+		Cmd:       cmd,
+		Params:    auditParams(data),
+	}
+	if err := c.AuditLog.Record(e); err != nil {
+		return nil, fmt.Errorf("failed to record %s in the audit log, call not sent: %v", cmd, err)
+	}
+	return e, nil
+}
+
+// auditResponse records the outcome of a mutating call. The call has already been made at this point, so failing
+// to record it doesn't fail the call, the request entry without a response is left in the log instead.
+func (c *Client) auditResponse(req *AuditEntry, body []byte, callErr error) {
+	e := &AuditEntry{
+		Time:       time.Now(),
+		CallID:     req.CallID,
+		Phase:      AuditResponse,
+		Initiator:  req.Initiator,
+		Cmd:        req.Cmd,
+		DurationMS: time.Since(req.Time).Nanoseconds() / int64(time.Millisecond),
+	}
+	if json.Valid(body) {
+		e.Response = json.RawMessage(body)
+	}
+	if callErr != nil {
+		e.Error = callErr.Error()
+	}
+	c.AuditLog.Record(e)
+}
@@ -0,0 +1,194 @@
+package coinpayments_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+// auditedClient returns a fake client recording to a fresh audit log, and the path of the log
+func auditedClient(t *testing.T, api *fakeAPI) (*coinpayments.Client, *coinpayments.FileAuditLog, string) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "audit.log")
+	log, err := coinpayments.OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := fakeClient(t, api)
+	client.AuditLog = log
+	client.Initiator = "payouts-worker"
+	return client, log, path
+}
+
+func readAuditEntries(t *testing.T, path string) []coinpayments.AuditEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []coinpayments.AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e coinpayments.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLogRecordsMutatingCalls(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CWABC","status":1,"amount":"0.5"}}`},
+		coinpayments.CmdConvertCoins:     {`{"error":"Insufficient funds"}`},
+		coinpayments.CmdRates:            {`{"error":"ok","result":{}}`},
+	}}
+	client, log, path := auditedClient(t, api)
+	defer log.Close()
+
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "0.5", Currency: "BTC", Address: "1BitcoinAddress"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CallConvertCoins(&coinpayments.ConvertRequest{Amount: "1", From: "BTC", To: "ETH"}); err == nil {
+		t.Fatal("Should have failed the conversion, but it didn't")
+	}
+	if _, err := client.CallRates(&coinpayments.RatesRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAuditEntries(t, path)
+	if len(entries) != 4 {
+		t.Fatalf("Should have logged a request and a response entry for each mutating call, got %d entries", len(entries))
+	}
+
+	req, res := entries[0], entries[1]
+	if req.Phase != coinpayments.AuditRequest || res.Phase != coinpayments.AuditResponse || req.CallID != res.CallID {
+		t.Fatalf("Should have logged a request and response entry for the same call, got %+v and %+v", req, res)
+	}
+	if req.Cmd != coinpayments.CmdCreateWithdrawal || req.Initiator != "payouts-worker" {
+		t.Errorf("Should have logged the request with its command and initiator, got %+v", req)
+	}
+	if req.Params["address"] != "1BitcoinAddress" || req.Params["amount"] != "0.5" {
+		t.Errorf("Should have recorded the params of the call, got %v", req.Params)
+	}
+	if _, ok := req.Params["key"]; ok {
+		t.Error("Should have redacted the api key, but it didn't")
+	}
+	if !strings.Contains(string(res.Response), "CWABC") || res.Error != "" {
+		t.Errorf("Should have recorded the response, got %s %q", res.Response, res.Error)
+	}
+
+	if entries[3].Cmd != coinpayments.CmdConvertCoins || entries[3].Error != "Insufficient funds" {
+		t.Errorf("Should have recorded the failed conversion with its error, got %+v", entries[3])
+	}
+
+	result, err := coinpayments.VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != 4 || result.LastHash != entries[3].Hash {
+		t.Errorf("Should have verified every entry of the log, got %+v", result)
+	}
+}
+
+func TestAuditLogFailureBlocksCall(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateTransfer: {`{"error":"ok","result":{"id":"CTABC","status":1}}`},
+	}}
+	client, log, _ := auditedClient(t, api)
+	log.Close()
+
+	if _, err := client.CallCreateTransfer(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", MerchantID: "other"}); err == nil {
+		t.Fatal("Should have failed the transfer when it can't be audited, but it didn't")
+	}
+	if calls := api.callsFor(coinpayments.CmdCreateTransfer); len(calls) != 0 {
+		t.Errorf("Should not have sent the transfer, got %d calls", len(calls))
+	}
+}
+
+func TestVerifyAuditLogDetectsTampering(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CWABC","status":1,"amount":"0.5"}}`},
+	}}
+	client, log, path := auditedClient(t, api)
+	for i := 0; i < 3; i++ {
+		if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "0.5", Currency: "BTC", Address: "1BitcoinAddress"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchor, err := coinpayments.VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(original), "\n")
+
+	tests := []struct {
+		name string
+		log  string
+		line int
+	}{
+		{"edited", strings.Replace(string(original), "1BitcoinAddress", "1AttackerAddress", 1), 1},
+		{"removed", strings.Join(append(append([]string{}, lines[:2]...), lines[3:]...), ""), 3},
+		{"reordered", lines[1] + lines[0] + strings.Join(lines[2:], ""), 1},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(path, []byte(tt.log), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := coinpayments.VerifyAuditLog(path)
+		chainErr, ok := err.(*coinpayments.AuditChainError)
+		if !ok {
+			t.Errorf("Should have returned an AuditChainError for %s, got %v", tt.name, err)
+			continue
+		}
+		if chainErr.Line != tt.line {
+			t.Errorf("Should have broken the chain of %s at line %d, got %d", tt.name, tt.line, chainErr.Line)
+		}
+	}
+
+	// cutting entries off the end keeps the chain intact, but loses the anchored hash
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines[:4], "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := coinpayments.VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Contains(anchor.LastHash) {
+		t.Error("Should not have found the anchored hash in the truncated log, but it did")
+	}
+
+	// reopening the log continues the chain
+	reopened, err := coinpayments.OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.AuditLog = reopened
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "0.5", Currency: "BTC", Address: "1BitcoinAddress"}); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+	if result, err := coinpayments.VerifyAuditLog(path); err != nil || result.Entries != 6 {
+		t.Errorf("Should have verified the reopened log with 6 entries, got %+v, %v", result, err)
+	}
+}
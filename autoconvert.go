@@ -0,0 +1,369 @@
+package coinpayments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AutoConvertRule converts a coin we receive into the To currency, ie: BTC or a stablecoin
+type AutoConvertRule struct {
+	To string
+	// MinBatch, if set, holds conversions back until at least this much has been received, on top of the minimum
+	// of convert_limits. A decimal in the source coin.
+	MinBatch string
+}
+
+// Conversion statuses
+const (
+	ConversionSubmitting = "submitting" // saved before the call. It stays so if the outcome is unknown, see ResolveSubmitting.
+	ConversionSubmitted  = "submitted"  // accepted by the API
+	ConversionFailed     = "failed"     // refused by the API, its amount is pending again
+)
+
+// Errors returned by the AutoConverter
+var (
+	ErrConversionNotFound      = errors.New("conversion not found")
+	ErrConversionNotSubmitting = errors.New("conversion is not waiting on the outcome of its call")
+)
+
+// ConversionRecord is a conversion made by the AutoConverter. Ref is our id for it, set before it's sent, and ID the
+// id CoinPayments gave it.
+type ConversionRecord struct {
+	Ref       string    `json:"ref,omitempty"`
+	ID        string    `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    string    `json:"amount"`
+	Status    string    `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversionStore keeps the received amounts waiting to be converted, and the conversions made. Amounts are in
+// satoshis.
+type ConversionStore interface {
+	// AddReceived adds a payment to the amount pending for the coin, and returns false without adding it if the
+	// payment was already added
+	AddReceived(coin, txnID string, amount int64) (bool, error)
+	// Pending returns the amount of the coin waiting to be converted
+	Pending(coin string) (int64, error)
+	// SaveConversion records the conversion and takes its amount off the pending amount of its coin
+	SaveConversion(rec *ConversionRecord, amount int64) error
+	// UpdateConversion replaces the conversion with the same Ref, and puts release back on the pending amount of its
+	// coin, ie: the amount of a conversion the API refused
+	UpdateConversion(rec *ConversionRecord, release int64) error
+	// Conversions returns the conversions, oldest first, including the failed ones
+	Conversions() ([]ConversionRecord, error)
+}
+
+// MemoryConversionStore is a ConversionStore that keeps everything in memory
+type MemoryConversionStore struct {
+	mu          sync.Mutex
+	received    map[string]bool
+	pending     map[string]int64
+	conversions []ConversionRecord
+}
+
+// NewMemoryConversionStore returns an empty MemoryConversionStore
+func NewMemoryConversionStore() *MemoryConversionStore {
+	return &MemoryConversionStore{received: map[string]bool{}, pending: map[string]int64{}}
+}
+
+// AddReceived implements the ConversionStore interface
+func (s *MemoryConversionStore) AddReceived(coin, txnID string, amount int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := coin + "/" + txnID
+	if s.received[key] {
+		return false, nil
+	}
+	s.received[key] = true
+	s.pending[coin] += amount
+	return true, nil
+}
+
+// Pending implements the ConversionStore interface
+func (s *MemoryConversionStore) Pending(coin string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending[coin], nil
+}
+
+// SaveConversion implements the ConversionStore interface
+func (s *MemoryConversionStore) SaveConversion(rec *ConversionRecord, amount int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[rec.From] -= amount
+	s.conversions = append(s.conversions, *rec)
+	return nil
+}
+
+// UpdateConversion implements the ConversionStore interface
+func (s *MemoryConversionStore) UpdateConversion(rec *ConversionRecord, release int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.conversions {
+		if s.conversions[i].Ref == rec.Ref {
+			s.conversions[i] = *rec
+			s.pending[rec.From] += release
+			return nil
+		}
+	}
+	return ErrConversionNotFound
+}
+
+// Conversions implements the ConversionStore interface
+func (s *MemoryConversionStore) Conversions() ([]ConversionRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ConversionRecord(nil), s.conversions...), nil
+}
+
+// AutoConverter converts the coins we receive into a treasury currency. Completed payments are added to a batch per
+// coin, which is converted once it's over the minimum of convert_limits and our balance covers it. Batches over the
+// maximum are converted a maximum at a time. Every conversion is saved as submitting, with its amount taken off the
+// batch, before it's sent. Only a conversion the API refused goes back into the batch: after other errors, ie: a
+// timeout, the coins may have been converted, so it stays submitting until ResolveSubmitting.
+type AutoConverter struct {
+	mu     sync.Mutex
+	client *Client
+	store  ConversionStore
+	now    func() time.Time
+
+	// Rules, keyed by the coin received, are the coins to convert. Coins missing from it are left alone.
+	Rules map[string]AutoConvertRule
+}
+
+// NewAutoConverter returns an AutoConverter with no rules
+func NewAutoConverter(client *Client, store ConversionStore) *AutoConverter {
+	return &AutoConverter{client: client, store: store, now: time.Now, Rules: map[string]AutoConvertRule{}}
+}
+
+// rule returns the rule for the coin, whatever its case in Rules
+func (a *AutoConverter) rule(coin string) (AutoConvertRule, bool) {
+	for k, rule := range a.Rules {
+		if strings.EqualFold(k, coin) {
+			return rule, true
+		}
+	}
+	return AutoConvertRule{}, false
+}
+
+// Received adds a payment of the coin to its batch, and converts the batch if it's ready. Payments are added once per
+// txn id, so it's safe to call again for a retried IPN.
+func (a *AutoConverter) Received(coin, txnID, amount string) ([]ConversionRecord, error) {
+	coin = strings.ToUpper(coin)
+	if _, ok := a.rule(coin); !ok {
+		return nil, nil
+	}
+
+	satoshis, err := ParseSatoshis(amount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.store.AddReceived(coin, txnID, satoshis); err != nil {
+		return nil, err
+	}
+	return a.convert(coin)
+}
+
+// HandleAPI adds the payment of a completed API IPN, net of the fee, to the batch of its coin. Use it from
+// IPNHandler.OnAPI.
+func (a *AutoConverter) HandleAPI(ipn *IPNAPIResponse) error {
+	if !ipnComplete(ipn.Status) {
+		return nil
+	}
+	amount, err := netAmount(ipn.Amount2, ipn.Fee)
+	if err != nil {
+		return err
+	}
+	_, err = a.Received(ipn.Currency2, ipn.TxnID, amount)
+	return err
+}
+
+// HandleDeposit adds a completed deposit, net of the fee, to the batch of its coin. Use it from
+// IPNHandler.OnDeposit.
+func (a *AutoConverter) HandleDeposit(ipn *IPNDepositResponse) error {
+	if !ipnComplete(ipn.Status) {
+		return nil
+	}
+	amount, err := netAmount(ipn.Amount, ipn.Fee)
+	if err != nil {
+		return err
+	}
+	_, err = a.Received(ipn.Currency, ipn.TxnID, amount)
+	return err
+}
+
+// Convert converts every batch that's ready, ie: on a timer to pick up batches that were waiting on the balance.
+func (a *AutoConverter) Convert() ([]ConversionRecord, error) {
+	coins := make([]string, 0, len(a.Rules))
+	for coin := range a.Rules {
+		coins = append(coins, strings.ToUpper(coin))
+	}
+	sort.Strings(coins)
+
+	var records []ConversionRecord
+	for _, coin := range coins {
+		converted, err := a.convert(coin)
+		records = append(records, converted...)
+		if err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+// convert converts the batch of a coin, as many maximums at a time as it takes, if it's ready
+func (a *AutoConverter) convert(coin string) ([]ConversionRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rule, ok := a.rule(coin)
+	if !ok {
+		return nil, nil
+	}
+	to := strings.ToUpper(rule.To)
+
+	pending, err := a.store.Pending(coin)
+	if err != nil || pending <= 0 {
+		return nil, err
+	}
+
+	limits, err := a.client.CallGetConversionLimits(&ConvertLimitRequest{From: coin, To: to})
+	if err != nil {
+		return nil, err
+	}
+	min, err := ParseSatoshis(limits.Result.Min)
+	if err != nil {
+		return nil, fmt.Errorf("invalid conversion minimum %q for %s to %s", limits.Result.Min, coin, to)
+	}
+	max, err := ParseSatoshis(limits.Result.Max)
+	if err != nil {
+		return nil, fmt.Errorf("invalid conversion maximum %q for %s to %s", limits.Result.Max, coin, to)
+	}
+	if rule.MinBatch != "" {
+		batch, err := ParseSatoshis(rule.MinBatch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum batch %q for %s", rule.MinBatch, coin)
+		}
+		if batch > min {
+			min = batch
+		}
+	}
+	if pending < min {
+		return nil, nil
+	}
+
+	balances, err := a.client.CallBalances(&BalancesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	balance, err := ParseSatoshis(balances[coin].Balancef)
+	if err != nil {
+		balance = 0
+	}
+
+	var records []ConversionRecord
+	for pending >= min && balance >= min {
+		amount := pending
+		if balance < amount {
+			amount = balance
+		}
+		if amount <= 0 {
+			break
+		}
+		if max > 0 && amount > max {
+			amount = max
+		}
+
+		ref := make([]byte, 8)
+		if _, err := rand.Read(ref); err != nil {
+			return records, err
+		}
+		rec := ConversionRecord{Ref: hex.EncodeToString(ref), From: coin, To: to, Amount: FormatSatoshis(amount), Status: ConversionSubmitting, CreatedAt: a.now()}
+		if err := a.store.SaveConversion(&rec, amount); err != nil {
+			return records, err
+		}
+
+		res, err := a.client.CallConvertCoins(&ConvertRequest{Amount: rec.Amount, From: coin, To: to})
+		if err != nil {
+			var release int64
+			if callRefused(err) {
+				rec.Status, release = ConversionFailed, amount
+			}
+			rec.Error = err.Error()
+			if saveErr := a.store.UpdateConversion(&rec, release); saveErr != nil {
+				return records, saveErr
+			}
+			return records, err
+		}
+
+		rec.ID, rec.Status = res.ID, ConversionSubmitted
+		if err := a.store.UpdateConversion(&rec, 0); err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+		pending -= amount
+		balance -= amount
+	}
+	return records, nil
+}
+
+// ResolveSubmitting records the outcome of a conversion that stayed submitting, once you've checked with CoinPayments
+// whether it was made. Pass the id of the conversion if it was, or "" if it wasn't, which puts its amount back into
+// the batch.
+func (a *AutoConverter) ResolveSubmitting(ref, id string) (*ConversionRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	conversions, err := a.store.Conversions()
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range conversions {
+		if rec.Ref != ref {
+			continue
+		}
+		if rec.Status != ConversionSubmitting {
+			return nil, ErrConversionNotSubmitting
+		}
+
+		var release int64
+		if id == "" {
+			if release, err = ParseSatoshis(rec.Amount); err != nil {
+				return nil, err
+			}
+			rec.Status = ConversionFailed
+		} else {
+			rec.ID, rec.Status, rec.Error = id, ConversionSubmitted, ""
+		}
+		if err := a.store.UpdateConversion(&rec, release); err != nil {
+			return nil, err
+		}
+		return &rec, nil
+	}
+	return nil, ErrConversionNotFound
+}
+
+// netAmount returns the amount minus the fee, as a decimal
+func netAmount(amount, fee string) (string, error) {
+	a, err := ParseSatoshis(amount)
+	if err != nil {
+		return "", err
+	}
+	if fee == "" {
+		return FormatSatoshis(a), nil
+	}
+	f, err := ParseSatoshis(fee)
+	if err != nil {
+		return "", err
+	}
+	return FormatSatoshis(a - f), nil
+}
@@ -0,0 +1,167 @@
+package coinpayments_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestAutoConverterBatchesSmallPayments(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetConversionLimits: {`{"error":"ok","result":{"min":"1","max":"100"}}`},
+		coinpayments.CmdBalances:            {`{"error":"ok","result":{"LTC":{"balance":500000000,"balancef":"5.00000000"}}}`},
+		coinpayments.CmdConvertCoins:        {`{"error":"ok","result":{"id":"CV1"}}`},
+	}}
+	client := fakeClient(t, api)
+
+	store := coinpayments.NewMemoryConversionStore()
+	converter := coinpayments.NewAutoConverter(client, store)
+	converter.Rules["LTC"] = coinpayments.AutoConvertRule{To: "BTC"}
+
+	ipn := &coinpayments.IPNAPIResponse{Status: "100", TxnID: "TX1", Currency2: "LTC", Amount2: "0.6", Fee: "0.01"}
+	if err := converter.HandleAPI(ipn); err != nil {
+		t.Fatal(err)
+	}
+	// the retried IPN doesn't count twice
+	if err := converter.HandleAPI(ipn); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.callsFor(coinpayments.CmdConvertCoins); len(calls) != 0 {
+		t.Fatalf("Should have held the batch under the minimum, got %d conversions", len(calls))
+	}
+
+	// pending payments aren't counted
+	if err := converter.HandleAPI(&coinpayments.IPNAPIResponse{Status: "1", TxnID: "TX2", Currency2: "LTC", Amount2: "10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := converter.Received("ltc", "TX3", "0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "CV1" || records[0].Amount != "1.09000000" || records[0].From != "LTC" || records[0].To != "BTC" {
+		t.Fatalf("Should have converted the LTC batch to BTC, got %+v", records)
+	}
+
+	calls := api.callsFor(coinpayments.CmdConvertCoins)
+	if len(calls) != 1 || calls[0].Get("amount") != "1.09000000" || calls[0].Get("from") != "LTC" || calls[0].Get("to") != "BTC" {
+		t.Fatalf("Should have called convert once for the LTC batch, got %v", calls)
+	}
+	if pending, _ := store.Pending("LTC"); pending != 0 {
+		t.Errorf("Should have left nothing pending, got %d", pending)
+	}
+	if saved, _ := store.Conversions(); len(saved) != 1 || saved[0].ID != "CV1" {
+		t.Errorf("Should have recorded the conversion, got %+v", saved)
+	}
+
+	// coins without a rule are left alone
+	if records, err := converter.Received("BTC", "TX4", "3"); err != nil || len(records) != 0 {
+		t.Errorf("Should not have converted BTC, got %+v, %v", records, err)
+	}
+}
+
+func TestAutoConverterRespectsMaximumAndBalance(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetConversionLimits: {`{"error":"ok","result":{"min":"0.1","max":"2"}}`},
+		coinpayments.CmdBalances: {
+			`{"error":"ok","result":{"DOGE":{"balance":350000000,"balancef":"3.50000000"}}}`,
+			`{"error":"ok","result":{"DOGE":{"balance":500000000,"balancef":"5.00000000"}}}`,
+		},
+		coinpayments.CmdConvertCoins: {`{"error":"ok","result":{"id":"CV1"}}`, `{"error":"ok","result":{"id":"CV2"}}`, `{"error":"ok","result":{"id":"CV3"}}`},
+	}}
+	client := fakeClient(t, api)
+
+	store := coinpayments.NewMemoryConversionStore()
+	converter := coinpayments.NewAutoConverter(client, store)
+	converter.Rules["DOGE"] = coinpayments.AutoConvertRule{To: "USDT.ERC20"}
+
+	if err := converter.HandleDeposit(&coinpayments.IPNDepositResponse{Status: "100", TxnID: "D1", Currency: "DOGE", Amount: "4", Fee: "0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := api.callsFor(coinpayments.CmdConvertCoins)
+	if len(calls) != 2 || calls[0].Get("amount") != "2.00000000" || calls[1].Get("amount") != "1.50000000" {
+		t.Fatalf("Should have converted the maximum and then the rest of the balance, got %v", calls)
+	}
+	if pending, _ := store.Pending("DOGE"); pending != 50000000 {
+		t.Errorf("Should have left 0.5 DOGE waiting for the balance, got %d", pending)
+	}
+
+	converted, err := converter.Convert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != 1 || converted[0].ID != "CV3" || converted[0].Amount != "0.50000000" {
+		t.Errorf("Should have converted the rest once the balance allows, got %+v", converted)
+	}
+}
+
+func TestAutoConverterRefusedConversion(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetConversionLimits: {`{"error":"ok","result":{"min":"1","max":"100"}}`},
+		coinpayments.CmdBalances:            {`{"error":"ok","result":{"LTC":{"balance":500000000,"balancef":"5.00000000"}}}`},
+		coinpayments.CmdConvertCoins:        {`{"error":"Conversions are disabled"}`, `{"error":"ok","result":{"id":"CV1"}}`},
+	}}
+	store := coinpayments.NewMemoryConversionStore()
+	converter := coinpayments.NewAutoConverter(fakeClient(t, api), store)
+	converter.Rules["LTC"] = coinpayments.AutoConvertRule{To: "BTC"}
+
+	if _, err := converter.Received("LTC", "TX1", "2"); err == nil {
+		t.Fatal("Should have returned the refusal of the API, but it didn't")
+	}
+	if pending, _ := store.Pending("LTC"); pending != 200000000 {
+		t.Fatalf("Should have put the refused conversion back into the batch, got %d", pending)
+	}
+	if saved, _ := store.Conversions(); len(saved) != 1 || saved[0].Status != coinpayments.ConversionFailed || saved[0].Error == "" {
+		t.Fatalf("Should have recorded the failed conversion, got %+v", saved)
+	}
+
+	records, err := converter.Convert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "CV1" || records[0].Status != coinpayments.ConversionSubmitted {
+		t.Errorf("Should have converted the batch on the next run, got %+v", records)
+	}
+}
+
+func TestAutoConverterAmbiguousConversion(t *testing.T) {
+	api := cmdTimeoutAPI{&fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetConversionLimits: {`{"error":"ok","result":{"min":"1","max":"100"}}`},
+		coinpayments.CmdBalances:            {`{"error":"ok","result":{"LTC":{"balance":500000000,"balancef":"5.00000000"}}}`},
+	}}, coinpayments.CmdConvertCoins}
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey"}, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := coinpayments.NewMemoryConversionStore()
+	converter := coinpayments.NewAutoConverter(client, store)
+	converter.Rules["LTC"] = coinpayments.AutoConvertRule{To: "BTC"}
+
+	if _, err := converter.Received("LTC", "TX1", "2"); err == nil {
+		t.Fatal("Should have returned the timeout, but it didn't")
+	}
+	saved, _ := store.Conversions()
+	if len(saved) != 1 || saved[0].Status != coinpayments.ConversionSubmitting || saved[0].Ref == "" {
+		t.Fatalf("Should have kept the conversion that timed out submitting, got %+v", saved)
+	}
+
+	// it may have been made, so the next run doesn't convert the coins again
+	if records, err := converter.Convert(); err != nil || len(records) != 0 {
+		t.Fatalf("Should not have converted the batch again, got %+v, %v", records, err)
+	}
+	if pending, _ := store.Pending("LTC"); pending != 0 {
+		t.Fatalf("Should have kept the amount out of the batch, got %d", pending)
+	}
+
+	rec, err := converter.ResolveSubmitting(saved[0].Ref, "")
+	if err != nil || rec.Status != coinpayments.ConversionFailed {
+		t.Fatalf("Should have failed the conversion that wasn't made, got %+v, %v", rec, err)
+	}
+	if pending, _ := store.Pending("LTC"); pending != 200000000 {
+		t.Errorf("Should have put the conversion that wasn't made back into the batch, got %d", pending)
+	}
+	if _, err := converter.ResolveSubmitting(saved[0].Ref, "CV1"); err != coinpayments.ErrConversionNotSubmitting {
+		t.Errorf("Should only resolve submitting conversions, got %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package coinpayments
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSnapshots is returned by a SnapshotStore that holds no snapshots yet
+var ErrNoSnapshots = errors.New("no balance snapshots")
+
+// Balance alert kinds
+const (
+	AlertLowBalance  = "low_balance"
+	AlertBalanceDrop = "balance_drop"
+	AlertLargeInflow = "large_inflow"
+)
+
+// CoinBalance is the balance of a single coin in a snapshot
+type CoinBalance struct {
+	Balance  int64  `json:"balance"` // in satoshis
+	Balancef string `json:"balancef"`
+}
+
+// BalanceSnapshot is the balances of every coin at a point in time
+type BalanceSnapshot struct {
+	Time     time.Time              `json:"time"`
+	Balances map[string]CoinBalance `json:"balances"`
+}
+
+// BalanceDelta is the change of a coin's balance between two snapshots
+type BalanceDelta struct {
+	Coin   string        `json:"coin"`
+	From   int64         `json:"from"` // in satoshis
+	To     int64         `json:"to"`
+	Change int64         `json:"change"`
+	Period time.Duration `json:"period"` // time between the snapshots
+}
+
+// SnapshotStore is a time series of balance snapshots
+type SnapshotStore interface {
+	SaveSnapshot(s *BalanceSnapshot) error
+	// LatestSnapshot returns ErrNoSnapshots if nothing was saved yet
+	LatestSnapshot() (*BalanceSnapshot, error)
+	// Snapshots returns the snapshots taken at or after since and before until, oldest first. A zero time leaves
+	// that end open.
+	Snapshots(since, until time.Time) ([]BalanceSnapshot, error)
+}
+
+// MemorySnapshotStore is a SnapshotStore that keeps everything in memory
+type MemorySnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots []BalanceSnapshot
+}
+
+// NewMemorySnapshotStore returns an empty MemorySnapshotStore
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{}
+}
+
+// SaveSnapshot implements the SnapshotStore interface
+func (s *MemorySnapshotStore) SaveSnapshot(snapshot *BalanceSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = append(s.snapshots, *snapshot)
+	return nil
+}
+
+// LatestSnapshot implements the SnapshotStore interface
+func (s *MemorySnapshotStore) LatestSnapshot() (*BalanceSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.snapshots) == 0 {
+		return nil, ErrNoSnapshots
+	}
+	latest := s.snapshots[len(s.snapshots)-1]
+	return &latest, nil
+}
+
+// Snapshots implements the SnapshotStore interface
+func (s *MemorySnapshotStore) Snapshots(since, until time.Time) ([]BalanceSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var snapshots []BalanceSnapshot
+	for _, snapshot := range s.snapshots {
+		if !since.IsZero() && snapshot.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !snapshot.Time.Before(until) {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// BalanceAlert is raised by the BalanceMonitor when a balance needs someone to look at it
+type BalanceAlert struct {
+	Kind    string    `json:"kind"`
+	Coin    string    `json:"coin"`
+	Balance string    `json:"balance"`
+	Change  string    `json:"change,omitempty"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Notifier sends balance alerts somewhere people will see them, ie: a chat channel or a pager
+type Notifier interface {
+	Notify(alert *BalanceAlert) error
+}
+
+// NotifierFunc lets a plain function be used as a Notifier
+type NotifierFunc func(alert *BalanceAlert) error
+
+// Notify implements the Notifier interface
+func (f NotifierFunc) Notify(alert *BalanceAlert) error {
+	return f(alert)
+}
+
+// BalanceAlertRule sets when the monitor raises alerts for a coin. Amounts are decimals in the coin, an empty amount
+// never raises its alert.
+type BalanceAlertRule struct {
+	// Low alerts when the balance falls under it
+	Low string
+	// MaxDrop alerts when the balance drops by more than this between two snapshots, less any expected outflows
+	MaxDrop string
+	// LargeInflow alerts when the balance grows by more than this between two snapshots
+	LargeInflow string
+}
+
+// BalanceMonitor snapshots the balances of the account and raises alerts on low balances, unexpected drops and large
+// inflows.
+type BalanceMonitor struct {
+	mu       sync.Mutex
+	client   *Client
+	store    SnapshotStore
+	notifier Notifier
+	now      func() time.Time
+	expected map[string]int64
+
+	// Rules, keyed by coin, are the alerts to raise. Coins missing from it are only snapshotted.
+	Rules map[string]BalanceAlertRule
+	// OnError, if set, is called by Run with the errors of failed snapshots and notifications
+	OnError func(err error)
+}
+
+// NewBalanceMonitor returns a BalanceMonitor with no alert rules
+func NewBalanceMonitor(client *Client, store SnapshotStore, notifier Notifier) *BalanceMonitor {
+	return &BalanceMonitor{client: client, store: store, notifier: notifier, now: time.Now, expected: map[string]int64{}, Rules: map[string]BalanceAlertRule{}}
+}
+
+// ExpectOutflow tells the monitor about a withdrawal we made ourselves, so the drop it causes in the next snapshot
+// isn't alerted on.
+func (m *BalanceMonitor) ExpectOutflow(coin, amount string) error {
+	satoshis, err := ParseSatoshis(amount)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expected[strings.ToUpper(coin)] += satoshis
+	return nil
+}
+
+// Snapshot takes a snapshot of the balances, saves it, and returns it along with its deltas to the previous
+// snapshot. Alerts are sent before it returns, failing to send one is returned after the rest were tried.
+func (m *BalanceMonitor) Snapshot() (*BalanceSnapshot, []BalanceDelta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balances, err := m.client.CallBalances(&BalancesRequest{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshot := &BalanceSnapshot{Time: m.now(), Balances: make(map[string]CoinBalance, len(balances))}
+	for coin, b := range balances {
+		snapshot.Balances[strings.ToUpper(coin)] = CoinBalance{Balance: int64(b.Balance), Balancef: b.Balancef}
+	}
+
+	previous, err := m.store.LatestSnapshot()
+	if err == ErrNoSnapshots {
+		previous, err = nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.store.SaveSnapshot(snapshot); err != nil {
+		return nil, nil, err
+	}
+
+	var deltas []BalanceDelta
+	if previous != nil {
+		deltas = BalanceDeltas(previous, snapshot)
+	}
+
+	var notifyErr error
+	for _, alert := range m.alerts(previous, snapshot, deltas) {
+		if err := m.notifier.Notify(alert); err != nil && notifyErr == nil {
+			notifyErr = err
+		}
+	}
+	m.expected = map[string]int64{}
+	return snapshot, deltas, notifyErr
+}
+
+// alerts works out the alerts raised by a new snapshot
+func (m *BalanceMonitor) alerts(previous, snapshot *BalanceSnapshot, deltas []BalanceDelta) []*BalanceAlert {
+	var alerts []*BalanceAlert
+	alert := func(kind, coin string, balance, change int64, format string, args ...interface{}) {
+		a := &BalanceAlert{Kind: kind, Coin: coin, Balance: FormatSatoshis(balance), Time: snapshot.Time, Message: fmt.Sprintf(format, args...)}
+		if change != 0 {
+			a.Change = FormatSatoshis(change)
+		}
+		alerts = append(alerts, a)
+	}
+
+	coins := make([]string, 0, len(m.Rules))
+	for coin := range m.Rules {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+
+	for _, coin := range coins {
+		rule := m.Rules[coin]
+		coin = strings.ToUpper(coin)
+		balance := snapshot.Balances[coin].Balance
+
+		// low balances only alert when they cross the limit, not on every snapshot after
+		if low, err := ParseSatoshis(rule.Low); err == nil && balance < low {
+			if previous == nil || previous.Balances[coin].Balance >= low {
+				alert(AlertLowBalance, coin, balance, 0, "%s balance of %s is under %s", coin, FormatSatoshis(balance), rule.Low)
+			}
+		}
+
+		for _, d := range deltas {
+			if d.Coin != coin {
+				continue
+			}
+			if maxDrop, err := ParseSatoshis(rule.MaxDrop); err == nil && d.Change < 0 {
+				unexpected := -d.Change - m.expected[coin]
+				if unexpected > maxDrop {
+					alert(AlertBalanceDrop, coin, balance, d.Change, "%s balance dropped by %s in %s, %s more than expected", coin, FormatSatoshis(-d.Change), d.Period, FormatSatoshis(unexpected))
+				}
+			}
+			if inflow, err := ParseSatoshis(rule.LargeInflow); err == nil && d.Change > inflow {
+				alert(AlertLargeInflow, coin, balance, d.Change, "%s balance grew by %s in %s", coin, FormatSatoshis(d.Change), d.Period)
+			}
+		}
+	}
+	return alerts
+}
+
+// BalanceDeltas returns the change of every coin that changed between two snapshots, sorted by coin
+func BalanceDeltas(from, to *BalanceSnapshot) []BalanceDelta {
+	coins := map[string]bool{}
+	for coin := range from.Balances {
+		coins[coin] = true
+	}
+	for coin := range to.Balances {
+		coins[coin] = true
+	}
+
+	var deltas []BalanceDelta
+	for coin := range coins {
+		a, b := from.Balances[coin].Balance, to.Balances[coin].Balance
+		if a != b {
+			deltas = append(deltas, BalanceDelta{Coin: coin, From: a, To: b, Change: b - a, Period: to.Time.Sub(from.Time)})
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].Coin < deltas[j].Coin })
+	return deltas
+}
+
+// Run snapshots the balances every interval until stop is closed
+func (m *BalanceMonitor) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, _, err := m.Snapshot(); err != nil && m.OnError != nil {
+			m.OnError(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WriteBalanceHistoryCSV writes the snapshots as CSV, a row per coin per snapshot, with the change since the
+// snapshot before it.
+func WriteBalanceHistoryCSV(w io.Writer, snapshots []BalanceSnapshot) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"time", "coin", "balance", "balancef", "change"}); err != nil {
+		return err
+	}
+
+	var previous *BalanceSnapshot
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		coins := make([]string, 0, len(snapshot.Balances))
+		for coin := range snapshot.Balances {
+			coins = append(coins, coin)
+		}
+		sort.Strings(coins)
+
+		for _, coin := range coins {
+			b := snapshot.Balances[coin]
+			change := ""
+			if previous != nil {
+				change = FormatSatoshis(b.Balance - previous.Balances[coin].Balance)
+			}
+			row := []string{snapshot.Time.UTC().Format(time.RFC3339), coin, strconv.FormatInt(b.Balance, 10), b.Balancef, change}
+			if err := out.Write(row); err != nil {
+				return err
+			}
+		}
+		previous = snapshot
+	}
+
+	out.Flush()
+	return out.Error()
+}
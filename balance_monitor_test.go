@@ -0,0 +1,113 @@
+package coinpayments_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestBalanceMonitor(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdBalances: {
+			`{"error":"ok","result":{"BTC":{"balance":200000000,"balancef":"2.00000000"},"LTC":{"balance":100000000,"balancef":"1.00000000"}}}`,
+			`{"error":"ok","result":{"BTC":{"balance":50000000,"balancef":"0.50000000"},"LTC":{"balance":2100000000,"balancef":"21.00000000"}}}`,
+			`{"error":"ok","result":{"BTC":{"balance":40000000,"balancef":"0.40000000"},"LTC":{"balance":2100000000,"balancef":"21.00000000"}}}`,
+		},
+	}}
+	client := fakeClient(t, api)
+
+	var alerts []coinpayments.BalanceAlert
+	notifier := coinpayments.NotifierFunc(func(a *coinpayments.BalanceAlert) error {
+		alerts = append(alerts, *a)
+		return nil
+	})
+
+	store := coinpayments.NewMemorySnapshotStore()
+	monitor := coinpayments.NewBalanceMonitor(client, store, notifier)
+	monitor.Rules["BTC"] = coinpayments.BalanceAlertRule{Low: "1", MaxDrop: "0.5"}
+	monitor.Rules["LTC"] = coinpayments.BalanceAlertRule{LargeInflow: "10"}
+
+	if _, deltas, err := monitor.Snapshot(); err != nil || len(deltas) != 0 {
+		t.Fatalf("Should have had no deltas on the first snapshot, got %v, %v", deltas, err)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("Should not have alerted on the first snapshot, got %+v", alerts)
+	}
+
+	_, deltas, err := monitor.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 2 || deltas[0].Coin != "BTC" || deltas[0].Change != -150000000 || deltas[1].Coin != "LTC" || deltas[1].Change != 2000000000 {
+		t.Fatalf("Should have had the BTC and LTC deltas, got %+v", deltas)
+	}
+
+	kinds := map[string]bool{}
+	for _, a := range alerts {
+		kinds[a.Coin+" "+a.Kind] = true
+	}
+	for _, want := range []string{"BTC " + coinpayments.AlertLowBalance, "BTC " + coinpayments.AlertBalanceDrop, "LTC " + coinpayments.AlertLargeInflow} {
+		if !kinds[want] {
+			t.Errorf("Should have raised a %s alert, got %+v", want, alerts)
+		}
+	}
+	if len(alerts) != 3 {
+		t.Errorf("Should have raised 3 alerts, got %+v", alerts)
+	}
+
+	// still low, but the low balance alert isn't repeated, and the expected drop isn't alerted on
+	alerts = nil
+	if err := monitor.ExpectOutflow("btc", "0.1"); err != nil {
+		t.Fatal(err)
+	}
+	monitor.Rules["BTC"] = coinpayments.BalanceAlertRule{Low: "1", MaxDrop: "0.05"}
+	if _, _, err := monitor.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("Should not have alerted on a repeated low balance or an expected drop, got %+v", alerts)
+	}
+
+	snapshots, err := store.Snapshots(time.Time{}, time.Time{})
+	if err != nil || len(snapshots) != 3 {
+		t.Fatalf("Should have stored 3 snapshots, got %d, %v", len(snapshots), err)
+	}
+
+	var out bytes.Buffer
+	if err := coinpayments.WriteBalanceHistoryCSV(&out, snapshots); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 7 || lines[0] != "time,coin,balance,balancef,change" {
+		t.Fatalf("Should have written a header and a row per coin and snapshot, got %q", out.String())
+	}
+	if !strings.HasSuffix(lines[3], ",BTC,50000000,0.50000000,-1.50000000") {
+		t.Errorf("Should have written the BTC balance and change, got %q", lines[3])
+	}
+}
+
+func TestMemorySnapshotStoreRange(t *testing.T) {
+	store := coinpayments.NewMemorySnapshotStore()
+	if _, err := store.LatestSnapshot(); err != coinpayments.ErrNoSnapshots {
+		t.Fatalf("Should have returned ErrNoSnapshots, got %v", err)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		store.SaveSnapshot(&coinpayments.BalanceSnapshot{Time: start.Add(time.Duration(i) * time.Hour)})
+	}
+
+	snapshots, err := store.Snapshots(start.Add(time.Hour), start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || !snapshots[0].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("Should have returned the snapshots within the range, got %+v", snapshots)
+	}
+	if latest, _ := store.LatestSnapshot(); !latest.Time.Equal(start.Add(4 * time.Hour)) {
+		t.Errorf("Should have returned the latest snapshot, got %+v", latest)
+	}
+}
@@ -0,0 +1,209 @@
+package coinpayments
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/url"
+	"strconv"
+)
+
+// checkoutURL is the hosted checkout that merchant buttons post to
+var checkoutURL = "https://www.coinpayments.net/index.php"
+
+// Button commands supported by the hosted checkout
+const (
+	ButtonPay       = "_pay"
+	ButtonPaySimple = "_pay_simple"
+	ButtonDonate    = "_donate"
+	ButtonCartAdd   = "_cart_add"
+)
+
+// Errors returned while building a button
+var (
+	ErrMissingMerchantID   = errors.New("merchant id missing from config struct")
+	ErrUnknownButtonCmd    = errors.New("button command is not supported by the checkout")
+	ErrButtonMissingAmount = errors.New("button is missing an amount")
+	ErrButtonMissingItem   = errors.New("button is missing an item name")
+)
+
+// ButtonItem is the item being sold through a button.
+type ButtonItem struct {
+	Name          string // required, except for donations
+	Number        string
+	Description   string
+	Amount        string // price of a single item in the button currency. Optional for donations with AllowAmount set.
+	Quantity      int    // defaults to 1 on the checkout when left empty
+	AllowQuantity bool   // lets the buyer change the quantity on the checkout page
+	Option1Name   string
+	Option1Value  string
+	Option2Name   string
+	Option2Value  string
+}
+
+// Button holds everything needed to render a merchant checkout button for the hosted checkout.
+type Button struct {
+	Cmd      string // one of ButtonPay, ButtonPaySimple, ButtonDonate or ButtonCartAdd
+	Currency string // the currency the item amounts are specified in
+	Item     ButtonItem
+
+	// optional
+	Tax          string // tax for the entire order
+	Shipping     string // shipping for the first item
+	Shipping2    string // shipping for each additional item
+	WantShipping bool   // asks the buyer for a shipping address
+	AllowAmount  bool   // donations only, lets the buyer pick the amount
+	AllowExtra   bool   // lets the buyer add a note to the order
+	Invoice      string
+	Custom       string
+	IPNURL       string
+	SuccessURL   string
+	CancelURL    string
+	BuyerEmail   string
+	FirstName    string
+	LastName     string
+
+	// rendering only
+	SubmitLabel string // label of the submit button, defaults to "Pay with CoinPayments"
+	ImageURL    string // renders an image button instead of a plain submit button when set
+}
+
+// buttonField is a single hidden input of a button form. We keep them in a slice instead of a url.Values so the
+// rendered markup keeps a stable order.
+type buttonField struct {
+	Name  string
+	Value string
+}
+
+// buttonFields validates the button and returns the fields the checkout expects, in order.
+func (c *Client) buttonFields(b *Button) ([]buttonField, error) {
+	if c.MerchantID == "" {
+		return nil, ErrMissingMerchantID
+	}
+
+	switch b.Cmd {
+	case ButtonPay, ButtonPaySimple, ButtonCartAdd:
+		if b.Item.Name == "" {
+			return nil, ErrButtonMissingItem
+		}
+		if b.Item.Amount == "" {
+			return nil, ErrButtonMissingAmount
+		}
+	case ButtonDonate:
+		if b.Item.Amount == "" && !b.AllowAmount {
+			return nil, ErrButtonMissingAmount
+		}
+	default:
+		return nil, ErrUnknownButtonCmd
+	}
+
+	fields := []buttonField{
+		{"cmd", b.Cmd},
+		{"reset", "1"},
+		{"merchant", c.MerchantID},
+		{"currency", b.Currency},
+	}
+
+	// add adds the field only if there's a value for it, so the checkout applies its own defaults otherwise
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, buttonField{name, value})
+		}
+	}
+	flag := func(name string, value bool) {
+		if value {
+			fields = append(fields, buttonField{name, "1"})
+		}
+	}
+
+	add("amountf", b.Item.Amount)
+	add("item_name", b.Item.Name)
+	add("item_number", b.Item.Number)
+	add("item_desc", b.Item.Description)
+	if b.Item.Quantity > 0 {
+		add("quantity", strconv.Itoa(b.Item.Quantity))
+	}
+	flag("allow_quantity", b.Item.AllowQuantity)
+	add("on1", b.Item.Option1Name)
+	add("ov1", b.Item.Option1Value)
+	add("on2", b.Item.Option2Name)
+	add("ov2", b.Item.Option2Value)
+	add("taxf", b.Tax)
+	add("shippingf", b.Shipping)
+	add("shipping2f", b.Shipping2)
+	flag("want_shipping", b.WantShipping)
+	flag("allow_amount", b.AllowAmount)
+	flag("allow_extra", b.AllowExtra)
+	add("invoice", b.Invoice)
+	add("custom", b.Custom)
+	if b.IPNURL != "" {
+		add("ipn_url", b.IPNURL)
+	} else {
+		add("ipn_url", c.IPNURL)
+	}
+	add("success_url", b.SuccessURL)
+	add("cancel_url", b.CancelURL)
+	add("email", b.BuyerEmail)
+	add("first_name", b.FirstName)
+	add("last_name", b.LastName)
+
+	return fields, nil
+}
+
+// ButtonValues returns the values the hosted checkout expects for the given button.
+func (c *Client) ButtonValues(b *Button) (url.Values, error) {
+	fields, err := c.buttonFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	for _, f := range fields {
+		values.Add(f.Name, f.Value)
+	}
+	return values, nil
+}
+
+// ButtonURL returns a link to the hosted checkout for the given button, which can be used as a redirect instead of
+// rendering a form. Buttons are not signed by CoinPayments, so amounts must still be checked when the IPN comes in.
+func (c *Client) ButtonURL(b *Button) (string, error) {
+	values, err := c.ButtonValues(b)
+	if err != nil {
+		return "", err
+	}
+	return checkoutURL + "?" + values.Encode(), nil
+}
+
+var buttonTemplate = template.Must(template.New("button").Parse(
+	`<form action="{{.Action}}" method="post">` +
+		`{{range .Fields}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">{{end}}` +
+		`{{if .ImageURL}}<input type="image" src="{{.ImageURL}}" alt="{{.Label}}">` +
+		`{{else}}<input type="submit" value="{{.Label}}">{{end}}` +
+		`</form>`))
+
+// ButtonForm renders the given button as a form posting to the hosted checkout. Every value is escaped by
+// html/template, so the result can be dropped straight into another template.
+func (c *Client) ButtonForm(b *Button) (template.HTML, error) {
+	fields, err := c.buttonFields(b)
+	if err != nil {
+		return "", err
+	}
+
+	label := b.SubmitLabel
+	if label == "" {
+		label = "Pay with CoinPayments"
+	}
+
+	var buf bytes.Buffer
+	err = buttonTemplate.Execute(&buf, struct {
+		Action   string
+		Fields   []buttonField
+		ImageURL string
+		Label    string
+	}{checkoutURL, fields, b.ImageURL, label})
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(buf.String()), nil
+}
@@ -0,0 +1,66 @@
+package coinpayments_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestButtonValues(t *testing.T) {
+	client := offlineClient(t)
+
+	values, err := client.ButtonValues(&coinpayments.Button{
+		Cmd:          coinpayments.ButtonPay,
+		Currency:     "USD",
+		Item:         coinpayments.ButtonItem{Name: "T-Shirt", Amount: "20.00", Quantity: 2},
+		Tax:          "1.50",
+		WantShipping: true,
+		Invoice:      "inv-1",
+	})
+	if err != nil {
+		t.Fatalf("Should have built button values, but it threw error: %s", err.Error())
+	}
+
+	expected := map[string]string{"cmd": "_pay", "merchant": "merchantid", "amountf": "20.00", "quantity": "2", "taxf": "1.50", "want_shipping": "1", "invoice": "inv-1"}
+	for k, v := range expected {
+		if values.Get(k) != v {
+			t.Fatalf("Expected %s to be %s, got %s", k, v, values.Get(k))
+		}
+	}
+	if _, ok := values["shippingf"]; ok {
+		t.Fatalf("Should not have sent an empty shippingf field")
+	}
+
+	if _, err := client.ButtonValues(&coinpayments.Button{Cmd: coinpayments.ButtonPay, Currency: "USD", Item: coinpayments.ButtonItem{Name: "T-Shirt"}}); err != coinpayments.ErrButtonMissingAmount {
+		t.Fatalf("Should have failed on a button without an amount, got %v", err)
+	}
+
+	if _, err := client.ButtonValues(&coinpayments.Button{Cmd: coinpayments.ButtonDonate, Currency: "USD", AllowAmount: true}); err != nil {
+		t.Fatalf("Should have allowed a donation without an amount, but it threw error: %s", err.Error())
+	}
+
+	if _, err := client.ButtonValues(&coinpayments.Button{Cmd: "_nope"}); err != coinpayments.ErrUnknownButtonCmd {
+		t.Fatalf("Should have failed on an unknown command, got %v", err)
+	}
+}
+
+func TestButtonForm(t *testing.T) {
+	client := offlineClient(t)
+
+	form, err := client.ButtonForm(&coinpayments.Button{
+		Cmd:      coinpayments.ButtonPaySimple,
+		Currency: "USD",
+		Item:     coinpayments.ButtonItem{Name: `"><script>alert(1)</script>`, Amount: "5"},
+	})
+	if err != nil {
+		t.Fatalf("Should have rendered the button form, but it threw error: %s", err.Error())
+	}
+
+	if strings.Contains(string(form), "<script>") {
+		t.Fatalf("Should have escaped the item name, got %s", form)
+	}
+	if !strings.Contains(string(form), `name="cmd" value="_pay_simple"`) {
+		t.Fatalf("Should have rendered the cmd field, got %s", form)
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/jeffwalsh/go-coinpayments/address"
 )
 
 // These variables come from the Coinpayments API itself.
@@ -41,6 +44,9 @@ var (
 	CmdGetTxList           = "get_tx_ids"
 	CmdGetConversionLimits = "convert_limits"
 	CmdCreateTransfer      = "create_transfer"
+	CmdCreateWithdrawal    = "create_withdrawal"
+	CmdConvertCoins        = "convert"
+	CmdGetWithdrawalInfo   = "get_withdrawal_info"
 )
 
 // Reader is our example implementation of a Reader.
@@ -68,6 +74,31 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// APIError is an error the API answered a call with, in the error field of its response. The API refused the call,
+// so nothing it asked for happened, unlike transport errors after which the call may have gone through.
+type APIError struct {
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// callRefused returns whether the error of a call means nothing was sent: the API refused it, or the policy or the
+// address checks stopped it first. After other errors, ie: a timeout, the call may have gone through.
+func callRefused(err error) bool {
+	switch err.(type) {
+	case *APIError, *PolicyError, *AddressWarningError:
+		return true
+	}
+	switch err {
+	case address.ErrEmpty, address.ErrInvalidFormat, address.ErrInvalidChecksum, address.ErrInvalidDestTag,
+		address.ErrInvalidMerchant, address.ErrInvalidPBNTag:
+		return true
+	}
+	return false
+}
+
 // HTTPClient is an interface we rely on to send create requests
 type HTTPClient interface {
 	Do(*http.Request) (*http.Response, error)
@@ -80,10 +111,35 @@ type Client struct {
 	httpClient           HTTPClient
 	privateKey           string
 	publicKey            string
+	MerchantID           string
 	IPNSecret            string
 	IPNURL               string
+	IPNMode              string
+	IPNSecrets           []IPNSecret
 	BTCForwardingAddress string
 	ETHForwardingAddress string
+	ForwardingAddresses  map[string]string
+
+	// Policy, if set, has to allow every transfer, withdrawal and conversion before it's sent to the API
+	Policy WithdrawalPolicy
+
+	// AuditLog, if set, records every transaction, transfer, withdrawal and conversion made through the client,
+	// along with Initiator, ie: the name of the service or user the client acts for
+	AuditLog  AuditLog
+	Initiator string
+
+	// ValidateAddresses turns on the offline check of withdrawal addresses, transfer merchant ids and $PayByName tags
+	// before they're sent. OnAddressWarning, if set, decides what happens to addresses that are valid but look wrong,
+	// ie: a testnet address for BTC. Returning nil lets the withdrawal through. Without it they're refused.
+	ValidateAddresses bool
+	OnAddressWarning  func(coin, address string, warnings []string) error
+
+	// OnRetiredIPNSecret, if set, is called every time an IPN is sent with a retired or expired IPN secret, with the
+	// number of IPNs sent with it so far
+	OnRetiredIPNSecret func(name string, count int)
+
+	ipnSecretMu   sync.Mutex
+	ipnSecretUses map[string]int
 }
 
 // SupportedCommands returns a slice of strings with all the available commands
@@ -98,7 +154,10 @@ func SupportedCommands() []string {
 		CmdGetTxInfoMulti, 
 		CmdGetTxList, 
 		CmdCreateTransfer, 
+		CmdCreateWithdrawal,
+		CmdConvertCoins,
 		CmdGetConversionLimits,
+		CmdGetWithdrawalInfo,
 	}
 }
 
@@ -114,8 +173,9 @@ func NewClient(cfg *Config, httpClient HTTPClient) (*Client, error) {
 	// build out our list of necessary commands the API has been instructed to use so far
 	commands := make([]string, 3)
 	commands = append(commands, SupportedCommands()...)
-	cp := &Client{commands: commands, baseURL: baseURL, httpClient: httpClient, privateKey: cfg.PrivateKey, publicKey: cfg.PublicKey, IPNSecret: cfg.IPNSecret, IPNURL: cfg.IPNURL,
-		BTCForwardingAddress: cfg.BTCForwardingAddress, ETHForwardingAddress: cfg.ETHForwardingAddress}
+	cp := &Client{commands: commands, baseURL: baseURL, httpClient: httpClient, privateKey: cfg.PrivateKey, publicKey: cfg.PublicKey, MerchantID: cfg.MerchantID, IPNSecret: cfg.IPNSecret, IPNURL: cfg.IPNURL, IPNMode: cfg.IPNMode, IPNSecrets: cfg.IPNSecrets,
+		BTCForwardingAddress: cfg.BTCForwardingAddress, ETHForwardingAddress: cfg.ETHForwardingAddress, ForwardingAddresses: cfg.ForwardingAddresses,
+		ValidateAddresses: cfg.ValidateAddresses}
 	return cp, nil
 }
 
@@ -148,26 +208,65 @@ func (c *Client) Call(cmd string, data url.Values, responseStruct interface{}) e
 	if !stringExistsInSlice(c.commands, cmd) {
 		return ErrCommandDoesntExist
 	}
+
+	if p := outgoingPayment(cmd, data); p != nil && c.Policy != nil {
+		if err := c.Policy.Check(p); err != nil {
+			return err
+		}
+		if err := c.call(cmd, data, responseStruct); err != nil {
+			// after a timeout or an unreadable response the payment may have gone out, so it keeps counting
+			if _, refused := err.(*APIError); refused {
+				c.Policy.Release(p)
+			}
+			return err
+		}
+		return nil
+	}
+
 	return c.call(cmd, data, responseStruct)
 }
 
 // call sends a request with the given cmd and data, and then unmarshals the response into the given responseStruct.
+// Mutating commands are recorded in the AuditLog, if there is one.
 func (c *Client) call(cmd string, data url.Values, responseStruct interface{}) error {
 	data.Add("key", c.publicKey)
 	data.Add("version", version)
 	data.Add("cmd", cmd)
 	data.Add("format", formatJSON)
 
+	var entry *AuditEntry
+	if c.AuditLog != nil && stringExistsInSlice(auditedCommands, cmd) {
+		var err error
+		if entry, err = c.auditRequest(cmd, data); err != nil {
+			return err
+		}
+	}
+
+	body, err := c.post(data)
+	if entry != nil {
+		c.auditResponse(entry, body, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// return the unmarshalled response and an error if it occurred
+	return json.Unmarshal(body, responseStruct)
+}
+
+// post sends the encoded data to the API and returns the body of the response, which is returned along with the
+// error when the API reports one.
+func (c *Client) post(data url.Values) ([]byte, error) {
 	dataString := data.Encode()
 	// generate hmac hash of data and private key
 	hash, err := c.computeHMAC(dataString)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// create the request using the url and url values
 	req, err := http.NewRequest("POST", c.baseURL, strings.NewReader(dataString))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// add necessary headers for API request
@@ -178,33 +277,32 @@ func (c *Client) call(cmd string, data url.Values, responseStruct interface{}) e
 	// do the actual request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.Status != successStatusCode {
 		ret := fmt.Sprintf("failed to make api call: expected status %v, got %s", successStatusCode, resp.Status)
-		return errors.New(ret)
+		return nil, errors.New(ret)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// unmarshal only the error first, as the server returns invalid json if there is an error. it comes in the form of
 	// {"error":"error", "result":[]}, which cannot unmarshal the invalid array to a struct.
 	cpError := ErrorResponse{}
 	if err := json.Unmarshal(body, &cpError); err != nil {
-		return err
+		return body, err
 	}
 
 	// check the error to see if it was OK
 	if cpError.Error != successResponse {
-		return errors.New(cpError.Error)
+		return body, &APIError{Message: cpError.Error}
 	}
 
-	// return the unmarshalled response and an error if it occurred
-	return json.Unmarshal(body, responseStruct)
+	return body, nil
 }
@@ -2,9 +2,12 @@ package coinpayments_test
 
 import (
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/jeffwalsh/go-coinpayments"
@@ -24,6 +27,71 @@ func testClient() (*coinpayments.Client, error) {
 	return coinpayments.NewClient(&coinpayments.Config{PublicKey: pubKey, PrivateKey: privateKey}, &http.Client{})
 
 }
+
+// offlineClient returns a client with fake credentials for tests that never reach the API
+func offlineClient(t *testing.T) *coinpayments.Client {
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey", MerchantID: "merchantid", IPNSecret: "ipnsecret"}, &http.Client{})
+	if err != nil {
+		t.Fatalf("Should have instantiated a new client with fake credentials, but it threw error: %s", err.Error())
+	}
+	return client
+}
+
+// fakeAPI is an HTTPClient that answers API calls with canned responses keyed by command, and records every call
+type fakeAPI struct {
+	mu        sync.Mutex
+	responses map[string][]string // responses are handed out in order, the last one repeats
+	calls     []url.Values
+}
+
+func (f *fakeAPI) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, values)
+
+	cmd := values.Get("cmd")
+	response := `{"error":"no canned response for ` + cmd + `"}`
+	if canned := f.responses[cmd]; len(canned) > 0 {
+		response = canned[0]
+		if len(canned) > 1 {
+			f.responses[cmd] = canned[1:]
+		}
+	}
+
+	return &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(response))}, nil
+}
+
+// callsFor returns the values of every call made for the command
+func (f *fakeAPI) callsFor(cmd string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []url.Values
+	for _, c := range f.calls {
+		if c.Get("cmd") == cmd {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// fakeClient returns a client with fake credentials that talks to the given fakeAPI
+func fakeClient(t *testing.T, api *fakeAPI) *coinpayments.Client {
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey", MerchantID: "merchantid", IPNSecret: "ipnsecret"}, api)
+	if err != nil {
+		t.Fatalf("Should have instantiated a new client with fake credentials, but it threw error: %s", err.Error())
+	}
+	return client
+}
+
 func TestNewClient(t *testing.T) {
 	if _, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "", PrivateKey: ""}, &http.Client{}); err == nil {
 		t.Fatalf("Should have thrown an error with emptu public and private key, but it didn't")
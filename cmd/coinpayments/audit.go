@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+// auditVerify checks the hash chain of an audit log written by coinpayments.FileAuditLog
+func auditVerify(args []string) error {
+	flags := flag.NewFlagSet("audit-verify", flag.ExitOnError)
+	log := flags.String("log", "", "path of the audit log")
+	anchor := flags.String("anchor", "", "last hash printed by an earlier run, which must still be in the log")
+	flags.Parse(args)
+
+	if *log == "" {
+		flags.Usage()
+		return errors.New("-log is required")
+	}
+
+	result, err := coinpayments.VerifyAuditLog(*log)
+	if err != nil {
+		return err
+	}
+	if *anchor != "" && !result.Contains(*anchor) {
+		return fmt.Errorf("audit log no longer contains the entry with hash %s, entries were removed", *anchor)
+	}
+
+	fmt.Printf("audit log ok: %d entries, last hash %s\n", result.Entries, result.LastHash)
+	return nil
+}
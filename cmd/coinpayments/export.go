@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+// export writes the payments, deposits and withdrawals in an IPN archive, and the conversions in an audit log, as CSV,
+// JSON Lines or OFX. Archived IPNs failing verification are reported and left out.
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	archive := flags.String("archive", "", "path of the IPN archive, rotated files next to it are read too")
+	config := flags.String("config", "", "json config file with the merchant id and ipn secrets the archived IPNs are verified with")
+	auditLog := flags.String("audit-log", "", "audit log written by coinpayments.FileAuditLog, to export the conversions in it")
+	format := flags.String("format", coinpayments.ExportCSV, "csv, jsonl or ofx")
+	columns := flags.String("columns", "", "comma separated csv columns, defaults to all of them")
+	since := flags.String("since", "", "only export events at or after this RFC3339 time")
+	until := flags.String("until", "", "only export events before this RFC3339 time")
+	currencies := flags.String("currency", "", "comma separated coins to export, defaults to all of them")
+	fiat := flags.String("fiat", "", "fiat currency to value every event in, ie: USD")
+	rates := flags.String("rates", "", "rate history file of JSON lines, written with coinpayments.AppendRateSnapshot")
+	account := flags.String("account", "", "account id for ofx statements")
+	out := flags.String("out", "", "file to write to, defaults to stdout")
+	flags.Parse(args)
+
+	if *archive == "" || *config == "" {
+		flags.Usage()
+		return errors.New("-archive and -config are required")
+	}
+	if (*fiat == "") != (*rates == "") {
+		return errors.New("-fiat and -rates go together")
+	}
+
+	opts := &coinpayments.ExportOptions{
+		Format:     *format,
+		Columns:    splitList(*columns),
+		Currencies: splitList(*currencies),
+		Fiat:       *fiat,
+		Account:    *account,
+	}
+	var err error
+	if opts.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if opts.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+	if *rates != "" {
+		if opts.Rates, err = coinpayments.LoadRateHistory(*rates); err != nil {
+			return err
+		}
+	}
+
+	client, err := loadClient(*config)
+	if err != nil {
+		return err
+	}
+	ipns, err := coinpayments.ReadIPNArchive(*archive)
+	if err != nil {
+		return err
+	}
+	events, rejected := coinpayments.AccountingEventsFromArchive(client, ipns)
+	for _, ipn := range rejected {
+		fmt.Fprintf(os.Stderr, "skipped unverified ipn %s\t%s\n", ipn.ReceivedAt.Format(time.RFC3339), summarizeIPN(&ipn))
+	}
+	if *auditLog != "" {
+		conversions, err := coinpayments.ConversionsFromAuditLog(*auditLog)
+		if err != nil {
+			return err
+		}
+		for i := range conversions {
+			events = append(events, coinpayments.ConversionEvents(&conversions[i], "")...)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	return coinpayments.Export(w, events, opts)
+}
+
+// loadClient returns a client for the json config file, ie: {"public_key": ..., "merchant_id": ..., "ipn_secret": ...}
+func loadClient(path string) (*coinpayments.Client, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg coinpayments.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", path, err)
+	}
+	return coinpayments.NewClient(&cfg, &http.Client{Timeout: 30 * time.Second})
+}
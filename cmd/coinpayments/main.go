@@ -0,0 +1,55 @@
+// Command coinpayments holds tooling built on top of the coinpayments package.
+//
+// Usage:
+//
+//	coinpayments <command> [flags]
+//
+// Run a command with -h to see its flags.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// commands maps each subcommand to the function running it with the remaining arguments
+var commands = map[string]func(args []string) error{
+	"audit-verify": auditVerify,
+	"export":       export,
+	"pos":          posDemo,
+	"replay":       replay,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: coinpayments <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+name)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+	"github.com/jeffwalsh/go-coinpayments/pos"
+)
+
+// posDemo runs a point of sale in the terminal: it shows the QR code of the sale, follows the payment and prints the
+// receipt. Press enter to cancel the sale.
+func posDemo(args []string) error {
+	flags := flag.NewFlagSet("pos", flag.ExitOnError)
+	amount := flags.String("amount", "", "price of the sale in the fiat currency, ie: 4.50")
+	fiat := flags.String("fiat", "USD", "fiat currency of the price")
+	coin := flags.String("coin", "BTC", "coin the buyer pays with")
+	item := flags.String("item", "", "item name, shown on the receipt")
+	email := flags.String("email", "", "buyer email for create_transaction, usually the shop's own")
+	merchant := flags.String("merchant", "", "name printed on the receipt")
+	interval := flags.Duration("poll", 10*time.Second, "how often to poll get_tx_info")
+	plain := flags.Bool("plain", false, "print updates line by line instead of redrawing the screen")
+	simulate := flags.String("simulate", "", "run against a simulated API instead of CoinPayments: paid, underpaid or timeout")
+	flags.Parse(args)
+
+	if *amount == "" {
+		flags.Usage()
+		return errors.New("-amount is required")
+	}
+
+	var httpClient coinpayments.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	cfg := &coinpayments.Config{PublicKey: os.Getenv("COINPAYMENTS_PUBLIC_KEY"), PrivateKey: os.Getenv("COINPAYMENTS_PRIVATE_KEY")}
+	if *simulate != "" {
+		sim, err := newSimulatedAPI(*simulate)
+		if err != nil {
+			return err
+		}
+		httpClient = sim
+		cfg.PublicKey, cfg.PrivateKey = "simulated", "simulated"
+		if *email == "" {
+			*email = "shop@example.com"
+		}
+		*interval = time.Second
+	}
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	client, err := coinpayments.NewClient(cfg, httpClient)
+	if err != nil {
+		return err
+	}
+
+	display := pos.NewTerminalDisplay(os.Stdout)
+	display.Plain = *plain
+	terminal := pos.NewTerminal(client, *fiat, *email)
+	terminal.Merchant = *merchant
+	terminal.PollInterval = *interval
+	terminal.Display = display
+	terminal.OnError = func(err error) {
+		fmt.Fprintln(os.Stderr, "polling the payment:", err)
+	}
+
+	payment, err := terminal.Start(&pos.Sale{Amount: *amount, Coin: *coin, ItemName: *item})
+	if err != nil {
+		return err
+	}
+
+	// enter or ctrl-c cancels the sale
+	cancel := make(chan struct{})
+	var once sync.Once
+	stop := func() { once.Do(func() { close(cancel) }) }
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		<-interrupt
+		stop()
+	}()
+	go func() {
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		stop()
+	}()
+
+	receipt, err := terminal.Wait(payment, cancel)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	_, err = receipt.WriteTo(os.Stdout)
+	return err
+}
+
+// simulatedRates are the prices in USD the simulated API converts with
+var simulatedRates = map[string]string{
+	"BTC":  "60000",
+	"LTC":  "80",
+	"ETH":  "3000",
+	"DOGE": "0.15",
+}
+
+// simulatedAPI plays a buyer paying a transaction: nothing for a few seconds, then a payment which confirms, or half a
+// payment or none at all until the transaction times out
+type simulatedAPI struct {
+	scenario string
+	mu       sync.Mutex
+	created  time.Time
+	timeout  time.Duration
+	amount   *big.Rat
+}
+
+func newSimulatedAPI(scenario string) (*simulatedAPI, error) {
+	switch scenario {
+	case "paid", "underpaid", "timeout":
+		return &simulatedAPI{scenario: scenario}, nil
+	}
+	return nil, fmt.Errorf("unknown scenario %q, expected paid, underpaid or timeout", scenario)
+}
+
+func (s *simulatedAPI) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var response string
+	switch values.Get("cmd") {
+	case coinpayments.CmdCreateTransaction:
+		response, err = s.create(values)
+	case coinpayments.CmdGetTxInfo:
+		response = s.info()
+	default:
+		response = `{"error":"not simulated"}`
+	}
+	if err != nil {
+		response = `{"error":` + strconv.Quote(err.Error()) + `}`
+	}
+	return &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(response))}, nil
+}
+
+// create answers create_transaction, pricing the sale with simulatedRates as if the fiat was USD
+func (s *simulatedAPI) create(values url.Values) (string, error) {
+	rate, ok := new(big.Rat).SetString(simulatedRates[strings.ToUpper(values.Get("currency2"))])
+	if !ok {
+		return "", errors.New("the simulation only takes BTC, LTC, ETH and DOGE")
+	}
+	amount, ok := new(big.Rat).SetString(values.Get("amount"))
+	if !ok {
+		return "", errors.New("invalid amount")
+	}
+	s.amount = amount.Quo(amount, rate)
+	s.created = time.Now()
+
+	s.timeout = 900 * time.Second
+	if s.scenario != "paid" {
+		s.timeout = 20 * time.Second
+	}
+	return fmt.Sprintf(`{"error":"ok","result":{"amount":"%s","address":"1BoatSLRHtKNngkdXEeobR76b53LETtpyT","txn_id":"SIMULATED","confirms_needed":"2","timeout":%d}}`,
+		s.amount.FloatString(8), int(s.timeout/time.Second)), nil
+}
+
+// info answers get_tx_info with how far the simulated buyer got
+func (s *simulatedAPI) info() string {
+	elapsed := time.Since(s.created)
+	status, text, received, confirms := 0, "Waiting for buyer funds...", new(big.Rat), 0
+	switch {
+	case s.scenario == "paid" && elapsed > 12*time.Second:
+		status, text, received, confirms = 100, "Complete", s.amount, 2
+	case s.scenario == "paid" && elapsed > 8*time.Second:
+		status, text, received, confirms = 1, "Funds received and confirmed, sending to you shortly...", s.amount, 1
+	case s.scenario == "paid" && elapsed > 4*time.Second:
+		status, text, received = 0, "Waiting for confirmations...", s.amount
+	case s.scenario == "underpaid" && elapsed > 22*time.Second:
+		status, text, received = -1, "Cancelled / Timed Out", new(big.Rat).Quo(s.amount, big.NewRat(2, 1))
+	case s.scenario == "underpaid" && elapsed > 4*time.Second:
+		status, text, received = 0, "Waiting for buyer funds...", new(big.Rat).Quo(s.amount, big.NewRat(2, 1))
+	}
+	return fmt.Sprintf(`{"error":"ok","result":{"status":%d,"status_text":%q,"receivedf":"%s","recv_confirms":%d,"time_expires":%d}}`,
+		status, text, received.FloatString(8), confirms, s.created.Add(s.timeout).Unix())
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+// replay feeds archived IPNs back into an IPN URL, or into an IPN handler that verifies them
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	archive := flags.String("archive", "", "path of the IPN archive, rotated files next to it are read too")
+	target := flags.String("url", "", "IPN URL to post the archived IPNs to")
+	config := flags.String("config", "", "JSON config of the client, to replay into an IPN handler instead of -url. It has no callbacks, so it only verifies the IPNs against the secrets active when they were received.")
+	txnIDs := flags.String("txn", "", "comma separated txn ids (or withdrawal ids) to replay")
+	types := flags.String("type", "", "comma separated ipn types to replay, ie: api,deposit")
+	since := flags.String("since", "", "only replay IPNs received at or after this RFC3339 time")
+	until := flags.String("until", "", "only replay IPNs received before this RFC3339 time")
+	dryRun := flags.Bool("dry-run", false, "list the IPNs that would be replayed without sending them")
+	flags.Parse(args)
+
+	if *archive == "" || (*target == "" && *config == "" && !*dryRun) {
+		flags.Usage()
+		return errors.New("-archive and -url or -config are required")
+	}
+	if *target != "" && *config != "" {
+		return errors.New("-url and -config can't be used together")
+	}
+
+	filter := coinpayments.IPNFilter{TxnIDs: splitList(*txnIDs), Types: splitList(*types)}
+	var err error
+	if filter.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+
+	ipns, err := coinpayments.ReadIPNArchive(*archive)
+	if err != nil {
+		return err
+	}
+
+	var dest coinpayments.IPNReplayTarget = &coinpayments.IPNURLTarget{URL: *target, HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+	if *config != "" {
+		client, err := loadClient(*config)
+		if err != nil {
+			return err
+		}
+		dest = &coinpayments.IPNHandlerTarget{Handler: &coinpayments.IPNHandler{Client: client}}
+	}
+	if *dryRun {
+		dest = dryRunTarget{}
+	}
+
+	failed := 0
+	results := coinpayments.ReplayIPNs(ipns, filter, dest)
+	for _, result := range results {
+		status := "ok"
+		if result.Error != nil {
+			status = result.Error.Error()
+			failed++
+		}
+		fmt.Printf("%s\t%s\t%s\n", result.IPN.ReceivedAt.Format(time.RFC3339), summarizeIPN(&result.IPN), status)
+	}
+
+	fmt.Printf("replayed %d of %d archived ipns, %d failed\n", len(results), len(ipns), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d ipns failed to replay", failed)
+	}
+	return nil
+}
+
+// dryRunTarget accepts every IPN without sending it anywhere
+type dryRunTarget struct{}
+
+func (dryRunTarget) Replay(*coinpayments.ArchivedIPN) error { return nil }
+
+// summarizeIPN returns the type, id and status of an archived IPN for display
+func summarizeIPN(ipn *coinpayments.ArchivedIPN) string {
+	values, _ := url.ParseQuery(ipn.Body)
+	id := values.Get("txn_id")
+	if id == "" {
+		id = values.Get("id")
+	}
+	return fmt.Sprintf("%s\t%s\tstatus=%s", values.Get("ipn_type"), id, values.Get("status"))
+}
+
+// splitList splits a comma separated flag, ignoring empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseTime parses an optional RFC3339 flag
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -2,10 +2,17 @@ package coinpayments
 
 // Config is used by Viper to parse our provided credentials
 type Config struct {
-	PrivateKey           string `mapstructure:"private_key" json:"private_key"`
-	PublicKey            string `mapstructure:"public_key" json:"public_key"`
-	IPNSecret            string `mapstructure:"ipn_secret" json:"ipn_secret"`
-	IPNURL               string `mapstructure:"ipn_url" json:"ipn_url"`
-	BTCForwardingAddress string `mapstructure:"btc_forwarding_address" json:"btc_forwarding_address"`
-	ETHForwardingAddress string `mapstructure:"eth_forwarding_address" json:"eth_forwarding_address"`
+	PrivateKey           string      `mapstructure:"private_key" json:"private_key"`
+	PublicKey            string      `mapstructure:"public_key" json:"public_key"`
+	MerchantID           string      `mapstructure:"merchant_id" json:"merchant_id"`
+	IPNSecret            string      `mapstructure:"ipn_secret" json:"ipn_secret"`
+	IPNSecrets           []IPNSecret `mapstructure:"ipn_secrets" json:"ipn_secrets"` // accepted alongside IPNSecret, to rotate it
+	IPNURL               string      `mapstructure:"ipn_url" json:"ipn_url"`
+	IPNMode              string      `mapstructure:"ipn_mode" json:"ipn_mode"` // IPNModeHMAC (default), IPNModeHTTPAuth or IPNModeAny
+	BTCForwardingAddress string      `mapstructure:"btc_forwarding_address" json:"btc_forwarding_address"`
+	ETHForwardingAddress string      `mapstructure:"eth_forwarding_address" json:"eth_forwarding_address"`
+	// ForwardingAddresses maps coins to the cold wallet addresses they are swept to, ie: {"LTC": "ltc1q..."}
+	ForwardingAddresses map[string]string `mapstructure:"forwarding_addresses" json:"forwarding_addresses"`
+	// ValidateAddresses turns on the offline check of withdrawal and transfer destinations
+	ValidateAddresses bool `mapstructure:"validate_addresses" json:"validate_addresses"`
 }
@@ -31,3 +31,47 @@ func (c *Client) CallGetConversionLimits(req *ConvertLimitRequest) (*ConvertLimi
 
 	return &response, nil
 }
+
+// ConvertRequest is used to hold our request parameters for converting coins
+type ConvertRequest struct {
+	Amount string `json:"amount"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+
+	// optional
+	Address string `json:"address,omitempty"`  // sends the converted coins here instead of to our balance
+	DestTag string `json:"dest_tag,omitempty"` // for coins needing a destination tag or memo
+}
+
+// ConvertResult is the result we get back from the convert command
+type ConvertResult struct {
+	ID string `json:"id"`
+}
+
+// ConvertResponse holds a response to the convert API call
+type ConvertResponse struct {
+	ErrorResponse
+	Result *ConvertResult `json:"result"`
+}
+
+// CallConvertCoins calls the convert command on the API
+func (c *Client) CallConvertCoins(req *ConvertRequest) (*ConvertResult, error) {
+
+	data := url.Values{}
+	data.Add("amount", req.Amount)
+	data.Add("from", req.From)
+	data.Add("to", req.To)
+	if req.Address != "" {
+		data.Add("address", req.Address)
+	}
+	if req.DestTag != "" {
+		data.Add("dest_tag", req.DestTag)
+	}
+
+	var response ConvertResponse
+	if err := c.Call(CmdConvertCoins, data, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Result, nil
+}
@@ -0,0 +1,377 @@
+package coinpayments
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lot selection methods for disposals
+const (
+	LotFIFO     = "fifo"
+	LotLIFO     = "lifo"
+	LotSpecific = "specific" // the lots are named on each Disposal
+)
+
+// Errors returned by the CostBasisEngine
+var (
+	ErrUnknownLotMethod  = errors.New("unknown lot selection method")
+	ErrInsufficientLots  = errors.New("not enough acquired to cover the disposal")
+	ErrLotNotFound       = errors.New("tax lot not found or already used up")
+	ErrFiatMismatch      = errors.New("acquisition is not valued in the fiat currency of the engine")
+	ErrMissingProceeds   = errors.New("disposal has no proceeds and the engine has no rates to value it")
+	ErrDuplicateTaxEvent = errors.New("acquisition or disposal was already recorded")
+)
+
+// Acquisition is coins coming in at a known fiat cost, ie: a completed payment
+type Acquisition struct {
+	ID       string
+	Time     time.Time
+	Currency string
+	Amount   string // in the coin
+	Cost     string // in Fiat
+	Fiat     string
+}
+
+// AcquisitionFromIPN turns a completed API IPN into an acquisition of the coins received, net of the fee, at the
+// price of the transaction, amount1 in currency1.
+func AcquisitionFromIPN(ipn *IPNAPIResponse, at time.Time) (*Acquisition, error) {
+	amount, err := netAmount(ipn.Amount2, ipn.Fee)
+	if err != nil {
+		return nil, err
+	}
+	return &Acquisition{ID: ipn.TxnID, Time: at, Currency: strings.ToUpper(ipn.Currency2), Amount: amount, Cost: ipn.Amount1, Fiat: strings.ToUpper(ipn.Currency1)}, nil
+}
+
+// Disposal is coins going out, ie: a withdrawal or the from side of a conversion
+type Disposal struct {
+	ID       string
+	Time     time.Time
+	Currency string
+	Amount   string
+	// Proceeds is the fiat value of the disposal. Left empty, it's valued with the Rates of the engine.
+	Proceeds string
+	// Lots are the acquisition ids to take the coins from, in order, for LotSpecific
+	Lots []string
+}
+
+// DisposalFromWithdrawal turns a completed withdrawal into a disposal, to be valued with the rates of the engine
+func DisposalFromWithdrawal(rec *WithdrawalRecord) *Disposal {
+	return &Disposal{ID: rec.ID, Time: rec.UpdatedAt, Currency: strings.ToUpper(rec.Request.Currency), Amount: rec.Request.Amount}
+}
+
+// DisposalFromConversion turns the from side of a conversion into a disposal, to be valued with the rates of the
+// engine. Record what the conversion received as an Acquisition of its own.
+func DisposalFromConversion(rec *ConversionRecord) *Disposal {
+	return &Disposal{ID: rec.ID, Time: rec.CreatedAt, Currency: strings.ToUpper(rec.From), Amount: rec.Amount}
+}
+
+// TaxLot is what's left of an acquisition
+type TaxLot struct {
+	ID        string    `json:"id"`
+	Currency  string    `json:"currency"`
+	Acquired  time.Time `json:"acquired"`
+	Amount    int64     `json:"amount"`    // satoshis acquired
+	Remaining int64     `json:"remaining"` // satoshis not disposed of yet
+	Cost      string    `json:"cost"`      // fiat cost of the whole lot
+
+	cost *big.Rat
+}
+
+// RealizedGain is the part of a disposal taken from a single lot
+type RealizedGain struct {
+	DisposalID string    `json:"disposal_id"`
+	LotID      string    `json:"lot_id"`
+	Currency   string    `json:"currency"`
+	Amount     string    `json:"amount"`
+	Acquired   time.Time `json:"acquired"`
+	Disposed   time.Time `json:"disposed"`
+	CostBasis  string    `json:"cost_basis"`
+	Proceeds   string    `json:"proceeds"`
+	Gain       string    `json:"gain"`
+	LongTerm   bool      `json:"long_term"` // held for more than a year
+
+	cost, proceeds *big.Rat
+}
+
+// CostBasisEngine keeps tax lots of the coins we receive and works out the gains realized when they go out again.
+// Record events in the order they happened, disposals only take from lots acquired before them.
+type CostBasisEngine struct {
+	mu       sync.Mutex
+	lots     map[string][]*TaxLot
+	gains    []RealizedGain
+	recorded map[string]bool
+
+	// Method is LotFIFO, LotLIFO or LotSpecific
+	Method string
+	// Fiat is the currency gains are reported in
+	Fiat string
+	// Rates, if set, values disposals without proceeds
+	Rates RateSource
+}
+
+// NewCostBasisEngine returns an engine with no lots
+func NewCostBasisEngine(method, fiat string) *CostBasisEngine {
+	return &CostBasisEngine{lots: map[string][]*TaxLot{}, recorded: map[string]bool{}, Method: method, Fiat: strings.ToUpper(fiat)}
+}
+
+// Acquire opens a tax lot for the acquisition
+func (e *CostBasisEngine) Acquire(a *Acquisition) error {
+	if !strings.EqualFold(a.Fiat, e.Fiat) {
+		return ErrFiatMismatch
+	}
+	amount, err := ParseSatoshis(a.Amount)
+	if err != nil || amount <= 0 {
+		return fmt.Errorf("invalid amount %q for acquisition %s", a.Amount, a.ID)
+	}
+	cost, ok := new(big.Rat).SetString(a.Cost)
+	if !ok {
+		return fmt.Errorf("invalid cost %q for acquisition %s", a.Cost, a.ID)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.recorded["a/"+a.ID] {
+		return ErrDuplicateTaxEvent
+	}
+	e.recorded["a/"+a.ID] = true
+
+	currency := strings.ToUpper(a.Currency)
+	lot := &TaxLot{ID: a.ID, Currency: currency, Acquired: a.Time, Amount: amount, Remaining: amount, Cost: cost.FloatString(2), cost: cost}
+	lots := append(e.lots[currency], lot)
+	sort.SliceStable(lots, func(i, j int) bool { return lots[i].Acquired.Before(lots[j].Acquired) })
+	e.lots[currency] = lots
+	return nil
+}
+
+// Dispose takes the disposal out of the open lots by the method of the engine, and returns the gains it realized.
+// Nothing is taken if the lots can't cover the whole disposal.
+func (e *CostBasisEngine) Dispose(d *Disposal) ([]RealizedGain, error) {
+	amount, err := ParseSatoshis(d.Amount)
+	if err != nil || amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %q for disposal %s", d.Amount, d.ID)
+	}
+	currency := strings.ToUpper(d.Currency)
+
+	proceeds, err := e.proceeds(d, currency, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.recorded["d/"+d.ID] {
+		return nil, ErrDuplicateTaxEvent
+	}
+
+	lots, err := e.lotsFor(d, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// work out every part first, so a disposal the lots can't cover leaves them untouched
+	type part struct {
+		lot    *TaxLot
+		amount int64
+	}
+	var parts []part
+	taken := map[*TaxLot]int64{}
+	left := amount
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+		take := lot.Remaining - taken[lot]
+		if take > left {
+			take = left
+		}
+		if take > 0 {
+			parts = append(parts, part{lot, take})
+			taken[lot] += take
+			left -= take
+		}
+	}
+	if left > 0 {
+		return nil, fmt.Errorf("%v: %s %s of %s", ErrInsufficientLots, FormatSatoshis(left), currency, d.ID)
+	}
+
+	e.recorded["d/"+d.ID] = true
+	var gains []RealizedGain
+	for _, p := range parts {
+		cost := new(big.Rat).Mul(p.lot.cost, big.NewRat(p.amount, p.lot.Amount))
+		share := new(big.Rat).Mul(proceeds, big.NewRat(p.amount, amount))
+		gain := new(big.Rat).Sub(share, cost)
+		p.lot.Remaining -= p.amount
+
+		gains = append(gains, RealizedGain{
+			DisposalID: d.ID,
+			LotID:      p.lot.ID,
+			Currency:   currency,
+			Amount:     FormatSatoshis(p.amount),
+			Acquired:   p.lot.Acquired,
+			Disposed:   d.Time,
+			CostBasis:  cost.FloatString(2),
+			Proceeds:   share.FloatString(2),
+			Gain:       gain.FloatString(2),
+			LongTerm:   d.Time.After(p.lot.Acquired.AddDate(1, 0, 0)),
+			cost:       cost,
+			proceeds:   share,
+		})
+	}
+	e.gains = append(e.gains, gains...)
+	return gains, nil
+}
+
+// proceeds returns the fiat value of the disposal
+func (e *CostBasisEngine) proceeds(d *Disposal, currency string, amount int64) (*big.Rat, error) {
+	if d.Proceeds != "" {
+		proceeds, ok := new(big.Rat).SetString(d.Proceeds)
+		if !ok {
+			return nil, fmt.Errorf("invalid proceeds %q for disposal %s", d.Proceeds, d.ID)
+		}
+		return proceeds, nil
+	}
+	if e.Rates == nil {
+		return nil, ErrMissingProceeds
+	}
+	rate, err := e.Rates.FiatRate(currency, e.Fiat, d.Time)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).Mul(rate, big.NewRat(amount, 1e8)), nil
+}
+
+// lotsFor returns the lots of the currency acquired before the disposal, in the order they are taken from
+func (e *CostBasisEngine) lotsFor(d *Disposal, currency string) ([]*TaxLot, error) {
+	var open []*TaxLot
+	for _, lot := range e.lots[currency] {
+		if lot.Remaining > 0 && !lot.Acquired.After(d.Time) {
+			open = append(open, lot)
+		}
+	}
+
+	switch e.Method {
+	case LotFIFO:
+		return open, nil
+	case LotLIFO:
+		for i, j := 0, len(open)-1; i < j; i, j = i+1, j-1 {
+			open[i], open[j] = open[j], open[i]
+		}
+		return open, nil
+	case LotSpecific:
+		byID := make(map[string]*TaxLot, len(open))
+		for _, lot := range open {
+			byID[lot.ID] = lot
+		}
+		lots := make([]*TaxLot, 0, len(d.Lots))
+		for _, id := range d.Lots {
+			lot, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("%v: %s", ErrLotNotFound, id)
+			}
+			lots = append(lots, lot)
+		}
+		return lots, nil
+	}
+	return nil, ErrUnknownLotMethod
+}
+
+// OpenLots returns the lots of the currency that aren't used up, oldest first
+func (e *CostBasisEngine) OpenLots(currency string) []TaxLot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var lots []TaxLot
+	for _, lot := range e.lots[strings.ToUpper(currency)] {
+		if lot.Remaining > 0 {
+			lots = append(lots, *lot)
+		}
+	}
+	return lots
+}
+
+// CostBasisTotals are the totals of a currency in a CostBasisReport
+type CostBasisTotals struct {
+	Currency      string `json:"currency"`
+	Proceeds      string `json:"proceeds"`
+	CostBasis     string `json:"cost_basis"`
+	ShortTermGain string `json:"short_term_gain"`
+	LongTermGain  string `json:"long_term_gain"`
+}
+
+// CostBasisReport holds the gains realized in a year
+type CostBasisReport struct {
+	Year   int               `json:"year"`
+	Method string            `json:"method"`
+	Fiat   string            `json:"fiat"`
+	Gains  []RealizedGain    `json:"gains"`
+	Totals []CostBasisTotals `json:"totals"`
+}
+
+// Report returns the gains realized in the year, in the location of the disposal times
+func (e *CostBasisEngine) Report(year int) *CostBasisReport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	report := &CostBasisReport{Year: year, Method: e.Method, Fiat: e.Fiat}
+	type totals struct{ proceeds, cost, short, long *big.Rat }
+	byCurrency := map[string]*totals{}
+	for _, g := range e.gains {
+		if g.Disposed.Year() != year {
+			continue
+		}
+		report.Gains = append(report.Gains, g)
+
+		t, ok := byCurrency[g.Currency]
+		if !ok {
+			t = &totals{new(big.Rat), new(big.Rat), new(big.Rat), new(big.Rat)}
+			byCurrency[g.Currency] = t
+		}
+		t.proceeds.Add(t.proceeds, g.proceeds)
+		t.cost.Add(t.cost, g.cost)
+		gain := new(big.Rat).Sub(g.proceeds, g.cost)
+		if g.LongTerm {
+			t.long.Add(t.long, gain)
+		} else {
+			t.short.Add(t.short, gain)
+		}
+	}
+
+	for currency, t := range byCurrency {
+		report.Totals = append(report.Totals, CostBasisTotals{
+			Currency:      currency,
+			Proceeds:      t.proceeds.FloatString(2),
+			CostBasis:     t.cost.FloatString(2),
+			ShortTermGain: t.short.FloatString(2),
+			LongTermGain:  t.long.FloatString(2),
+		})
+	}
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Currency < report.Totals[j].Currency })
+	return report
+}
+
+// WriteCSV writes the gains of the report as CSV, a row per lot of each disposal
+func (r *CostBasisReport) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	header := []string{"disposal_id", "lot_id", "currency", "amount", "acquired", "disposed", "cost_basis", "proceeds", "gain", "term"}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, g := range r.Gains {
+		term := "short"
+		if g.LongTerm {
+			term = "long"
+		}
+		row := []string{g.DisposalID, g.LotID, g.Currency, g.Amount, g.Acquired.UTC().Format(time.RFC3339), g.Disposed.UTC().Format(time.RFC3339), g.CostBasis, g.Proceeds, g.Gain, term}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
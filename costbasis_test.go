@@ -0,0 +1,127 @@
+package coinpayments_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+var lotDay = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// lotEngine returns an engine holding two BTC lots: 1 BTC at 30000 and 1 BTC at 40000 a month later
+func lotEngine(t *testing.T, method string) *coinpayments.CostBasisEngine {
+	engine := coinpayments.NewCostBasisEngine(method, "usd")
+
+	first, err := coinpayments.AcquisitionFromIPN(&coinpayments.IPNAPIResponse{TxnID: "TX1", Currency1: "USD", Amount1: "30000", Currency2: "BTC", Amount2: "1.005", Fee: "0.005"}, lotDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Acquire(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Acquire(&coinpayments.Acquisition{ID: "TX2", Time: lotDay.AddDate(0, 1, 0), Currency: "BTC", Amount: "1", Cost: "40000", Fiat: "USD"}); err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestCostBasisMethods(t *testing.T) {
+	disposal := &coinpayments.Disposal{ID: "CW1", Time: lotDay.AddDate(0, 2, 0), Currency: "BTC", Amount: "1.5", Proceeds: "75000", Lots: []string{"TX2", "TX1"}}
+
+	tests := []struct {
+		method string
+		lots   []string
+		gains  []string
+	}{
+		{coinpayments.LotFIFO, []string{"TX1", "TX2"}, []string{"20000.00", "5000.00"}},
+		{coinpayments.LotLIFO, []string{"TX2", "TX1"}, []string{"10000.00", "10000.00"}},
+		{coinpayments.LotSpecific, []string{"TX2", "TX1"}, []string{"10000.00", "10000.00"}},
+	}
+	for _, tt := range tests {
+		engine := lotEngine(t, tt.method)
+		gains, err := engine.Dispose(disposal)
+		if err != nil {
+			t.Fatalf("Should have disposed of the lots with %s, but it threw error: %s", tt.method, err.Error())
+		}
+		if len(gains) != 2 {
+			t.Fatalf("Should have had 2 gains with %s, got %+v", tt.method, gains)
+		}
+		for i, g := range gains {
+			if g.LotID != tt.lots[i] || g.Gain != tt.gains[i] {
+				t.Errorf("Should have had lot %s gain %s with %s, got %+v", tt.lots[i], tt.gains[i], tt.method, g)
+			}
+		}
+		if open := engine.OpenLots("btc"); len(open) != 1 || open[0].Remaining != 50000000 {
+			t.Errorf("Should have left half a lot with %s, got %+v", tt.method, open)
+		}
+	}
+}
+
+func TestCostBasisErrors(t *testing.T) {
+	engine := lotEngine(t, coinpayments.LotFIFO)
+
+	if err := engine.Acquire(&coinpayments.Acquisition{ID: "TX3", Currency: "BTC", Amount: "1", Cost: "1", Fiat: "EUR"}); err != coinpayments.ErrFiatMismatch {
+		t.Errorf("Should have returned ErrFiatMismatch, got %v", err)
+	}
+	if _, err := engine.Dispose(&coinpayments.Disposal{ID: "CW1", Time: lotDay.AddDate(1, 0, 0), Currency: "BTC", Amount: "3", Proceeds: "1"}); err == nil || !strings.Contains(err.Error(), coinpayments.ErrInsufficientLots.Error()) {
+		t.Errorf("Should have returned ErrInsufficientLots, got %v", err)
+	}
+	if open := engine.OpenLots("BTC"); len(open) != 2 || open[0].Remaining != open[0].Amount {
+		t.Errorf("Should have left the lots alone after a failed disposal, got %+v", open)
+	}
+	// the second lot wasn't acquired yet
+	if _, err := engine.Dispose(&coinpayments.Disposal{ID: "CW2", Time: lotDay.AddDate(0, 0, 1), Currency: "BTC", Amount: "1.5", Proceeds: "1"}); err == nil {
+		t.Error("Should have only taken from earlier lots, but it didn't")
+	}
+	if _, err := engine.Dispose(&coinpayments.Disposal{ID: "CW3", Time: lotDay.AddDate(1, 0, 0), Currency: "BTC", Amount: "0.1"}); err != coinpayments.ErrMissingProceeds {
+		t.Errorf("Should have returned ErrMissingProceeds, got %v", err)
+	}
+
+	specific := lotEngine(t, coinpayments.LotSpecific)
+	if _, err := specific.Dispose(&coinpayments.Disposal{ID: "CW4", Time: lotDay.AddDate(1, 0, 0), Currency: "BTC", Amount: "1.5", Proceeds: "1", Lots: []string{"TX1", "TX1"}}); err == nil {
+		t.Error("Should not have taken a lot named twice twice, but it did")
+	}
+}
+
+func TestCostBasisReport(t *testing.T) {
+	engine := lotEngine(t, coinpayments.LotFIFO)
+	engine.Rates = coinpayments.NewRateHistory(coinpayments.RateSnapshot{Time: lotDay.AddDate(0, 3, 0), RateBTC: map[string]string{"BTC": "1", "USD": "0.00002"}})
+
+	// short term, valued with the rates at 50000
+	if _, err := engine.Dispose(coinpayments.DisposalFromWithdrawal(&coinpayments.WithdrawalRecord{ID: "CW1", UpdatedAt: lotDay.AddDate(0, 3, 0), Request: coinpayments.WithdrawalRequest{Amount: "0.5", Currency: "BTC"}})); err != nil {
+		t.Fatal(err)
+	}
+	// long term, the next year
+	if _, err := engine.Dispose(&coinpayments.Disposal{ID: "CV1", Time: lotDay.AddDate(1, 1, 0), Currency: "BTC", Amount: "1", Proceeds: "60000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report := engine.Report(2025)
+	if len(report.Gains) != 1 || report.Gains[0].Proceeds != "25000.00" || report.Gains[0].Gain != "10000.00" {
+		t.Fatalf("Should have had the short term gain in 2025, got %+v", report.Gains)
+	}
+
+	report = engine.Report(2026)
+	if len(report.Gains) != 2 {
+		t.Fatalf("Should have taken the 2026 disposal from both lots, got %+v", report.Gains)
+	}
+	if len(report.Totals) != 1 {
+		t.Fatalf("Should have had totals for a single fiat, got %+v", report.Totals)
+	}
+	totals := report.Totals[0]
+	if totals.Proceeds != "60000.00" || totals.CostBasis != "35000.00" || totals.LongTermGain != "15000.00" || totals.ShortTermGain != "10000.00" {
+		t.Errorf("Should have totalled the long and short term gains, got %+v", totals)
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[1], ",long") || !strings.HasSuffix(lines[2], ",short") {
+		t.Errorf("Should have written a row per gain with its term, got %q", out.String())
+	}
+}
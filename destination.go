@@ -0,0 +1,73 @@
+package coinpayments
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jeffwalsh/go-coinpayments/address"
+)
+
+// AddressWarningError is returned for a withdrawal to an address that's valid but looks wrong, ie: a testnet
+// address for BTC, when the client has no OnAddressWarning to let it through
+type AddressWarningError struct {
+	Coin     string
+	Address  string
+	Warnings []string
+}
+
+func (e *AddressWarningError) Error() string {
+	return fmt.Sprintf("%s address %s: %s", e.Coin, e.Address, strings.Join(e.Warnings, "; "))
+}
+
+// ValidateAddress checks an address, and its destination tag or memo if any, is valid for the coin, offline.
+// Addresses of coins the address package doesn't know are let through. Warnings aren't errors, see address.Validate
+// for those, except a missing destination tag or memo: coin sent without it to an exchange can't be credited, so it's
+// returned as an *AddressWarningError. It's the default RefundManager.ValidateAddress.
+func ValidateAddress(coin, addr, destTag string) error {
+	res, err := address.Validate(coin, addr, destTag)
+	if err == address.ErrUnsupportedCoin {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, warning := range res.Warnings {
+		if warning == address.WarnNoDestTag {
+			return &AddressWarningError{Coin: res.Coin, Address: addr, Warnings: []string{warning}}
+		}
+	}
+	return nil
+}
+
+// checkDestination validates where a withdrawal or transfer is going when ValidateAddresses is set
+func (c *Client) checkDestination(req *WithdrawalRequest) error {
+	if !c.ValidateAddresses {
+		return nil
+	}
+	if req.MerchantID != "" {
+		if err := address.ValidateMerchantID(req.MerchantID); err != nil {
+			return err
+		}
+	}
+	if req.PBNTag != "" {
+		if err := address.ValidatePBNTag(req.PBNTag); err != nil {
+			return err
+		}
+	}
+	if req.Address == "" {
+		return nil
+	}
+
+	res, err := address.Validate(req.Currency, req.Address, req.DestTag)
+	if err == address.ErrUnsupportedCoin {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(res.Warnings) == 0 {
+		return nil
+	}
+	if c.OnAddressWarning != nil {
+		return c.OnAddressWarning(req.Currency, req.Address, res.Warnings)
+	}
+	return &AddressWarningError{Coin: res.Coin, Address: req.Address, Warnings: res.Warnings}
+}
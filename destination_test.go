@@ -0,0 +1,72 @@
+package coinpayments_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+	"github.com/jeffwalsh/go-coinpayments/address"
+)
+
+func TestValidateAddresses(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1}}`},
+		coinpayments.CmdCreateTransfer:   {`{"error":"ok","result":{"id":"CT1","status":1}}`},
+	}}
+	client := fakeClient(t, api)
+	client.ValidateAddresses = true
+
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", Address: "1BoatSLRHtKNngkdXEeobR76b53LETtpyU"}); err != address.ErrInvalidChecksum {
+		t.Fatalf("Should have caught a typo, got %v", err)
+	}
+	_, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", Address: "2MsLZ5FqqYpjM1Q1W4X81zMVZTF9gdbhVwd"})
+	if warning, ok := err.(*coinpayments.AddressWarningError); !ok || warning.Warnings[0] != address.WarnTestnetAddress {
+		t.Fatalf("Should have refused a testnet address, got %v", err)
+	}
+	if _, err := client.CallCreateTransfer(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", MerchantID: "not-a-merchant"}); err != address.ErrInvalidMerchant {
+		t.Fatalf("Should have refused an invalid merchant id, got %v", err)
+	}
+	if calls := len(api.calls); calls != 0 {
+		t.Fatalf("Should not have sent anything, got %d calls", calls)
+	}
+
+	// valid addresses, and addresses of coins that can't be checked offline, go through
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BCH", Address: "qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CallCreateTransfer(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", PBNTag: "$supplier"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var warned []string
+	client.OnAddressWarning = func(coin, addr string, warnings []string) error {
+		warned = warnings
+		return nil
+	}
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "10", Currency: "XRP", Address: "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(warned) != 1 || warned[0] != address.WarnNoDestTag {
+		t.Errorf("Should have warned about the missing destination tag, got %v", warned)
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	if err := coinpayments.ValidateAddress("ETH", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", ""); err != address.ErrInvalidChecksum {
+		t.Errorf("Should have failed the checksum, got %v", err)
+	}
+	if err := coinpayments.ValidateAddress("BCH", "anything", ""); err != nil {
+		t.Errorf("Should have let an unsupported coin through, got %v", err)
+	}
+	if err := coinpayments.ValidateAddress("XRP", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", "12345"); err != nil {
+		t.Errorf("Should have accepted the address with its destination tag, but it threw error: %s", err)
+	}
+	if err, ok := coinpayments.ValidateAddress("XRP", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", "").(*coinpayments.AddressWarningError); !ok || err.Warnings[0] != address.WarnNoDestTag {
+		t.Errorf("Should have refused the address without a destination tag, got %v", err)
+	}
+	if err := coinpayments.ValidateAddress("XRP", "X7ZWqnQwuGw9k5vjMDavafRdDPdECujodEGV7rLmPrBnVLb", "54321"); err != address.ErrInvalidDestTag {
+		t.Errorf("Should have refused a tag conflicting with the X-address, got %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+package coinpayments
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+	ExportOFX   = "ofx"
+)
+
+// Errors returned by Export
+var (
+	ErrUnknownExportFormat = errors.New("unknown export format")
+	ErrUnknownExportColumn = errors.New("unknown export column")
+	ErrExportNeedsFiat     = errors.New("ofx exports need a fiat currency and rates")
+)
+
+// DefaultExportColumns are the CSV columns written when ExportOptions.Columns is empty
+var DefaultExportColumns = []string{"time", "kind", "id", "currency", "amount", "fee", "net", "fiat_currency", "fiat_value", "fiat_fee", "description"}
+
+// AccountingEvent is a single movement of funds to export. Kind is one of the Recon kinds, ie: ReconTransaction.
+type AccountingEvent struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	ID          string    `json:"id"`
+	Currency    string    `json:"currency"`
+	Amount      string    `json:"amount"` // signed, negative for funds going out
+	Fee         string    `json:"fee,omitempty"`
+	Description string    `json:"description,omitempty"`
+
+	// filled in by Export when it has rates
+	FiatCurrency string `json:"fiat_currency,omitempty"`
+	FiatValue    string `json:"fiat_value,omitempty"`
+	FiatFee      string `json:"fiat_fee,omitempty"`
+}
+
+// Net returns the amount less the fee
+func (e *AccountingEvent) Net() string {
+	if e.Fee == "" {
+		return e.Amount
+	}
+	net, err := netAmount(e.Amount, e.Fee)
+	if err != nil {
+		return e.Amount
+	}
+	return net
+}
+
+// AccountingEventsFromArchive turns the completed payments, deposits and withdrawals in archived IPNs into events.
+// Each transaction only becomes one event however many times its IPNs were sent. Every IPN is verified with the
+// client first, see VerifyArchivedIPN, and the ones failing are returned as rejected instead of being booked.
+func AccountingEventsFromArchive(client *Client, ipns []ArchivedIPN) (events []AccountingEvent, rejected []ArchivedIPN) {
+	seen := map[string]bool{}
+	for i := range ipns {
+		if err := client.VerifyArchivedIPN(&ipns[i]); err != nil {
+			rejected = append(rejected, ipns[i])
+			continue
+		}
+		values := ipns[i].values()
+		e := AccountingEvent{Time: ipns[i].ReceivedAt}
+
+		switch values.Get("ipn_type") {
+		case IPNTypeDeposit:
+			if !ipnComplete(values.Get("status")) {
+				continue
+			}
+			e.Kind, e.ID = ReconDeposit, values.Get("txn_id")
+			e.Currency, e.Amount, e.Fee = values.Get("currency"), values.Get("amount"), values.Get("fee")
+			e.Description = "deposit to " + values.Get("address")
+		case IPNTypeWithdrawal:
+			if values.Get("status") != WithdrawalStatusComplete {
+				continue
+			}
+			e.Kind, e.ID = ReconWithdrawal, values.Get("id")
+			e.Currency, e.Amount = values.Get("currency"), "-"+values.Get("amount")
+			e.Description = "withdrawal to " + values.Get("address")
+		case IPNTypeAPI, IPNTypeSimple, IPNTypeButton, IPNTypeCart, IPNTypeDonation:
+			if !ipnComplete(values.Get("status")) {
+				continue
+			}
+			e.Kind, e.ID = ReconTransaction, values.Get("txn_id")
+			e.Currency, e.Amount, e.Fee = values.Get("currency2"), values.Get("amount2"), values.Get("fee")
+			e.Description = strings.TrimSpace(values.Get("item_name") + " " + values.Get("invoice"))
+		default:
+			continue
+		}
+
+		key := e.Kind + "/" + e.ID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		e.Currency = strings.ToUpper(e.Currency)
+		events = append(events, e)
+	}
+	return events, rejected
+}
+
+// WithdrawalEvent turns a completed WithdrawalRecord into an event. Withdrawals paid for in another coin with
+// Currency2 are still exported in the currency of their amount.
+func WithdrawalEvent(rec *WithdrawalRecord) AccountingEvent {
+	description := strings.TrimSpace("withdrawal " + rec.Reference)
+	if rec.Request.Currency2 != "" {
+		description += " paid for in " + strings.ToUpper(rec.Request.Currency2)
+	}
+	return AccountingEvent{
+		Time:        rec.UpdatedAt,
+		Kind:        ReconWithdrawal,
+		ID:          rec.ID,
+		Currency:    strings.ToUpper(rec.Request.Currency),
+		Amount:      "-" + rec.Request.Amount,
+		Description: description,
+	}
+}
+
+// ConversionEvents turns a ConversionRecord into the events for the coins leaving and, if it's known, the coins
+// received.
+func ConversionEvents(rec *ConversionRecord, received string) []AccountingEvent {
+	events := []AccountingEvent{{
+		Time:        rec.CreatedAt,
+		Kind:        ReconConversion,
+		ID:          rec.ID,
+		Currency:    strings.ToUpper(rec.From),
+		Amount:      "-" + rec.Amount,
+		Description: "conversion to " + strings.ToUpper(rec.To),
+	}}
+	if received != "" {
+		events = append(events, AccountingEvent{
+			Time:        rec.CreatedAt,
+			Kind:        ReconConversion,
+			ID:          rec.ID,
+			Currency:    strings.ToUpper(rec.To),
+			Amount:      received,
+			Description: "conversion from " + strings.ToUpper(rec.From),
+		})
+	}
+	return events
+}
+
+// ConversionsFromAuditLog returns the conversions the API accepted, out of an audit log written by FileAuditLog, for
+// ConversionEvents. Conversions don't send IPNs, so they're missing from the IPN archive. The hash chain of the log
+// is verified first.
+func ConversionsFromAuditLog(path string) ([]ConversionRecord, error) {
+	if _, err := VerifyAuditLog(path); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	requests := map[string]map[string]string{}
+	var records []ConversionRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		if e.Cmd != CmdConvertCoins {
+			continue
+		}
+		if e.Phase == AuditRequest {
+			requests[e.CallID] = e.Params
+			continue
+		}
+
+		params, ok := requests[e.CallID]
+		if !ok || e.Error != "" {
+			continue
+		}
+		var resp ConvertResponse
+		if err := json.Unmarshal(e.Response, &resp); err != nil || resp.Result == nil || resp.Result.ID == "" {
+			continue
+		}
+		records = append(records, ConversionRecord{ID: resp.Result.ID, From: params["from"], To: params["to"], Amount: params["amount"], Status: ConversionSubmitted, CreatedAt: e.Time})
+	}
+	return records, scanner.Err()
+}
+
+// ExportOptions configures an export
+type ExportOptions struct {
+	Format string
+	// Columns are the CSV columns, out of DefaultExportColumns. Defaults to all of them.
+	Columns []string
+	// Since and Until limit the events to those at or after Since and before Until. Zero times leave that end open.
+	Since, Until time.Time
+	// Currencies, if set, limits the events to these coins
+	Currencies []string
+	// Fiat and Rates value every event in fiat at the time it happened
+	Fiat  string
+	Rates RateSource
+	// Account identifies the account in OFX statements. Defaults to "coinpayments".
+	Account string
+}
+
+// match returns whether the event passes the filters of the options
+func (o *ExportOptions) match(e *AccountingEvent) bool {
+	if !o.Since.IsZero() && e.Time.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() && !e.Time.Before(o.Until) {
+		return false
+	}
+	if len(o.Currencies) == 0 {
+		return true
+	}
+	for _, c := range o.Currencies {
+		if strings.EqualFold(c, e.Currency) {
+			return true
+		}
+	}
+	return false
+}
+
+// Export writes the events that pass the filters in the format of the options, oldest first
+func Export(w io.Writer, events []AccountingEvent, opts *ExportOptions) error {
+	var selected []AccountingEvent
+	for _, e := range events {
+		if opts.match(&e) {
+			selected = append(selected, e)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Time.Before(selected[j].Time) })
+
+	if opts.Fiat != "" && opts.Rates != nil {
+		for i := range selected {
+			if err := valueInFiat(&selected[i], opts.Fiat, opts.Rates); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch opts.Format {
+	case ExportCSV, "":
+		return exportCSV(w, selected, opts.Columns)
+	case ExportJSONL:
+		enc := json.NewEncoder(w)
+		for i := range selected {
+			if err := enc.Encode(&selected[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ExportOFX:
+		if opts.Fiat == "" || opts.Rates == nil {
+			return ErrExportNeedsFiat
+		}
+		return exportOFX(w, selected, opts)
+	}
+	return ErrUnknownExportFormat
+}
+
+// valueInFiat fills in the fiat value of the event
+func valueInFiat(e *AccountingEvent, fiat string, rates RateSource) error {
+	rate, err := rates.FiatRate(e.Currency, fiat, e.Time)
+	if err != nil {
+		return fmt.Errorf("can't value %s %s of %s at %s: %v", e.Kind, e.ID, e.Currency, e.Time.Format(time.RFC3339), err)
+	}
+
+	value := func(amount string) (string, error) {
+		r, ok := new(big.Rat).SetString(amount)
+		if !ok {
+			return "", ErrInvalidAmount
+		}
+		return r.Mul(r, rate).FloatString(2), nil
+	}
+
+	e.FiatCurrency = strings.ToUpper(fiat)
+	if e.FiatValue, err = value(e.Amount); err != nil {
+		return err
+	}
+	if e.Fee != "" {
+		if e.FiatFee, err = value(e.Fee); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportCSV writes the events as CSV with the columns
+func exportCSV(w io.Writer, events []AccountingEvent, columns []string) error {
+	if len(columns) == 0 {
+		columns = DefaultExportColumns
+	}
+	for _, c := range columns {
+		if !stringExistsInSlice(DefaultExportColumns, c) {
+			return fmt.Errorf("%v: %s", ErrUnknownExportColumn, c)
+		}
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(columns); err != nil {
+		return err
+	}
+	for i := range events {
+		e := &events[i]
+		fields := map[string]string{
+			"time":          e.Time.UTC().Format(time.RFC3339),
+			"kind":          e.Kind,
+			"id":            e.ID,
+			"currency":      e.Currency,
+			"amount":        e.Amount,
+			"fee":           e.Fee,
+			"net":           e.Net(),
+			"fiat_currency": e.FiatCurrency,
+			"fiat_value":    e.FiatValue,
+			"fiat_fee":      e.FiatFee,
+			"description":   e.Description,
+		}
+		row := make([]string, len(columns))
+		for j, c := range columns {
+			row[j] = fields[c]
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// exportOFX writes the events as an OFX 1.02 bank statement in fiat, which most bookkeeping software imports. Fees
+// are their own transactions, and the coin amounts go in the memo.
+func exportOFX(w io.Writer, events []AccountingEvent, opts *ExportOptions) error {
+	account := opts.Account
+	if account == "" {
+		account = "coinpayments"
+	}
+	ofxTime := func(t time.Time) string { return t.UTC().Format("20060102150405") }
+
+	start, end := opts.Since, opts.Until
+	if len(events) > 0 {
+		if start.IsZero() {
+			start = events[0].Time
+		}
+		if end.IsZero() {
+			end = events[len(events)-1].Time
+		}
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if start.IsZero() {
+		start = end
+	}
+
+	var b strings.Builder
+	b.WriteString("OFXHEADER:100\r\nDATA:OFXSGML\r\nVERSION:102\r\nSECURITY:NONE\r\nENCODING:USASCII\r\nCHARSET:1252\r\nCOMPRESSION:NONE\r\nOLDFILEUID:NONE\r\nNEWFILEUID:NONE\r\n\r\n")
+	b.WriteString("<OFX>\r\n<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS>")
+	fmt.Fprintf(&b, "<DTSERVER>%s<LANGUAGE>ENG</SONRS></SIGNONMSGSRSV1>\r\n", ofxTime(time.Now()))
+	b.WriteString("<BANKMSGSRSV1><STMTTRNRS><TRNUID>1<STATUS><CODE>0<SEVERITY>INFO</STATUS>\r\n")
+	fmt.Fprintf(&b, "<STMTRS><CURDEF>%s<BANKACCTFROM><BANKID>COINPAYMENTS<ACCTID>%s<ACCTTYPE>CHECKING</BANKACCTFROM>\r\n", ofxText(strings.ToUpper(opts.Fiat)), ofxText(account))
+	fmt.Fprintf(&b, "<BANKTRANLIST><DTSTART>%s<DTEND>%s\r\n", ofxTime(start), ofxTime(end))
+
+	total := new(big.Rat)
+	transaction := func(trnType string, e *AccountingEvent, fitID, amount, memo string) {
+		if r, ok := new(big.Rat).SetString(amount); ok {
+			total.Add(total, r)
+		}
+		fmt.Fprintf(&b, "<STMTTRN><TRNTYPE>%s<DTPOSTED>%s<TRNAMT>%s<FITID>%s<NAME>%s<MEMO>%s</STMTTRN>\r\n",
+			trnType, ofxTime(e.Time), amount, ofxText(fitID), ofxText(e.Kind+" "+e.ID), ofxText(memo))
+	}
+	for i := range events {
+		e := &events[i]
+		trnType := "CREDIT"
+		if strings.HasPrefix(e.FiatValue, "-") {
+			trnType = "DEBIT"
+		}
+		memo := strings.TrimSpace(fmt.Sprintf("%s %s %s", e.Amount, e.Currency, e.Description))
+		transaction(trnType, e, e.Kind+"-"+e.ID+"-"+e.Currency, e.FiatValue, memo)
+		if e.FiatFee != "" && e.FiatFee != "0.00" {
+			transaction("FEE", e, e.Kind+"-"+e.ID+"-"+e.Currency+"-fee", "-"+e.FiatFee, fmt.Sprintf("fee %s %s", e.Fee, e.Currency))
+		}
+	}
+
+	b.WriteString("</BANKTRANLIST>\r\n")
+	fmt.Fprintf(&b, "<LEDGERBAL><BALAMT>%s<DTASOF>%s</LEDGERBAL>\r\n", total.FloatString(2), ofxTime(end))
+	b.WriteString("</STMTRS></STMTTRNRS></BANKMSGSRSV1>\r\n</OFX>\r\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ofxText escapes text for an OFX element
+func ofxText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", " ", "\n", " ").Replace(s)
+}
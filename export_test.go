@@ -0,0 +1,163 @@
+package coinpayments_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+var exportDay = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// exportArchive returns signed IPNs as the archive holds them, and a forged one
+func exportArchive() []coinpayments.ArchivedIPN {
+	bodies := []string{
+		"ipn_type=api&merchant=merchantid&txn_id=TX1&status=1&currency2=BTC&amount2=0.1&fee=0.0005",
+		"ipn_type=api&merchant=merchantid&txn_id=TX1&status=100&currency2=BTC&amount2=0.1&fee=0.0005&item_name=Order&invoice=INV-1",
+		"ipn_type=api&merchant=merchantid&txn_id=TX1&status=100&currency2=BTC&amount2=0.1&fee=0.0005&item_name=Order&invoice=INV-1",
+		"ipn_type=deposit&merchant=merchantid&txn_id=DP1&status=100&currency=ltc&amount=2&fee=0.01&address=Laddr",
+		"ipn_type=withdrawal&merchant=merchantid&id=CW1&status=2&currency=BTC&amount=0.05&address=1addr",
+		"ipn_type=withdrawal&merchant=merchantid&id=CW2&status=1&currency=BTC&amount=1&address=1addr",
+	}
+	var ipns []coinpayments.ArchivedIPN
+	for i, body := range bodies {
+		header := http.Header{}
+		header.Set("HMAC", signIPN("ipnsecret", []byte(body)))
+		ipns = append(ipns, coinpayments.ArchivedIPN{ReceivedAt: exportDay.Add(time.Duration(i+1) * time.Hour), Header: header, Body: body})
+	}
+	forged := http.Header{}
+	forged.Set("HMAC", signIPN("guessed", []byte("ipn_type=deposit&merchant=merchantid&txn_id=DP2&status=100&currency=BTC&amount=5")))
+	return append(ipns, coinpayments.ArchivedIPN{ReceivedAt: exportDay.Add(7 * time.Hour), Header: forged, Body: "ipn_type=deposit&merchant=merchantid&txn_id=DP2&status=100&currency=BTC&amount=5"})
+}
+
+func exportEvents(t *testing.T) []coinpayments.AccountingEvent {
+	events, _ := coinpayments.AccountingEventsFromArchive(offlineClient(t), exportArchive())
+	return events
+}
+
+func exportRates() *coinpayments.RateHistory {
+	rates := map[string]string{"BTC": "1", "LTC": "0.002", "USD": "0.00002"}
+	return coinpayments.NewRateHistory(coinpayments.RateSnapshot{Time: exportDay, RateBTC: rates}, coinpayments.RateSnapshot{Time: exportDay.Add(6 * time.Hour), RateBTC: rates})
+}
+
+func TestAccountingEventsFromArchive(t *testing.T) {
+	events, rejected := coinpayments.AccountingEventsFromArchive(offlineClient(t), exportArchive())
+	if len(rejected) != 1 || !strings.Contains(rejected[0].Body, "DP2") {
+		t.Fatalf("Should have rejected the forged IPN, got %+v", rejected)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Should have built 3 events, got %+v", events)
+	}
+	if e := events[0]; e.Kind != coinpayments.ReconTransaction || e.ID != "TX1" || !e.Time.Equal(exportDay.Add(2*time.Hour)) || e.Net() != "0.09950000" {
+		t.Errorf("Should have built the transaction event net of its fee, got %+v", e)
+	}
+	if e := events[1]; e.Kind != coinpayments.ReconDeposit || e.Currency != "LTC" {
+		t.Errorf("Should have built the LTC deposit event, got %+v", e)
+	}
+	if e := events[2]; e.Kind != coinpayments.ReconWithdrawal || e.Amount != "-0.05" {
+		t.Errorf("Should have built the withdrawal event as an outflow, got %+v", e)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var out bytes.Buffer
+	err := coinpayments.Export(&out, exportEvents(t), &coinpayments.ExportOptions{
+		Format:     coinpayments.ExportCSV,
+		Columns:    []string{"id", "currency", "amount", "fiat_value", "fiat_fee"},
+		Currencies: []string{"btc"},
+		Fiat:       "USD",
+		Rates:      exportRates(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,currency,amount,fiat_value,fiat_fee\nTX1,BTC,0.1,5000.00,25.00\nCW1,BTC,-0.05,-2500.00,\n"
+	if out.String() != want {
+		t.Errorf("Should have written %q, got %q", want, out.String())
+	}
+
+	err = coinpayments.Export(&out, exportEvents(t), &coinpayments.ExportOptions{Columns: []string{"nope"}})
+	if err == nil || !strings.Contains(err.Error(), coinpayments.ErrUnknownExportColumn.Error()) {
+		t.Errorf("Should have refused an unknown column, got %v", err)
+	}
+}
+
+func TestExportJSONLDateRange(t *testing.T) {
+	var out bytes.Buffer
+	err := coinpayments.Export(&out, exportEvents(t), &coinpayments.ExportOptions{
+		Format: coinpayments.ExportJSONL,
+		Since:  exportDay.Add(3 * time.Hour),
+		Until:  exportDay.Add(5 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Should have exported 1 event in the range, got %q", out.String())
+	}
+	var e coinpayments.AccountingEvent
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "DP1" || e.FiatValue != "" {
+		t.Errorf("Should have exported the deposit without a fiat value, got %+v", e)
+	}
+}
+
+func TestExportOFX(t *testing.T) {
+	var out bytes.Buffer
+	if err := coinpayments.Export(&out, exportEvents(t), &coinpayments.ExportOptions{Format: coinpayments.ExportOFX}); err != coinpayments.ErrExportNeedsFiat {
+		t.Fatalf("Should have returned ErrExportNeedsFiat, got %v", err)
+	}
+
+	err := coinpayments.Export(&out, exportEvents(t), &coinpayments.ExportOptions{Format: coinpayments.ExportOFX, Fiat: "usd", Rates: exportRates(), Account: "merchant-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ofx := out.String()
+	for _, want := range []string{
+		"OFXHEADER:100",
+		"<CURDEF>USD",
+		"<ACCTID>merchant-1",
+		"<TRNTYPE>CREDIT<DTPOSTED>20260301020000<TRNAMT>5000.00<FITID>transaction-TX1-BTC",
+		"<TRNTYPE>FEE<DTPOSTED>20260301020000<TRNAMT>-25.00",
+		"<TRNTYPE>CREDIT<DTPOSTED>20260301040000<TRNAMT>200.00",
+		"<TRNTYPE>DEBIT<DTPOSTED>20260301050000<TRNAMT>-2500.00",
+		"<LEDGERBAL><BALAMT>2674.00",
+	} {
+		if !strings.Contains(ofx, want) {
+			t.Errorf("Should have written %q to the ofx, got\n%s", want, ofx)
+		}
+	}
+}
+
+func TestConversionsFromAuditLog(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdConvertCoins: {`{"error":"ok","result":{"id":"CV1"}}`, `{"error":"Insufficient funds"}`},
+	}}
+	client, log, path := auditedClient(t, api)
+	defer log.Close()
+
+	if _, err := client.CallConvertCoins(&coinpayments.ConvertRequest{Amount: "1.5", From: "LTC", To: "BTC"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CallConvertCoins(&coinpayments.ConvertRequest{Amount: "2", From: "LTC", To: "BTC"}); err == nil {
+		t.Fatal("Should have failed the second conversion, but it didn't")
+	}
+
+	conversions, err := coinpayments.ConversionsFromAuditLog(path)
+	if err != nil {
+		t.Fatalf("Should have read the conversions, but it threw error: %s", err.Error())
+	}
+	if len(conversions) != 1 || conversions[0].ID != "CV1" || conversions[0].From != "LTC" || conversions[0].To != "BTC" || conversions[0].Amount != "1.5" {
+		t.Fatalf("Should have only returned the accepted conversion, got %+v", conversions)
+	}
+	if events := coinpayments.ConversionEvents(&conversions[0], ""); len(events) != 1 || events[0].Currency != "LTC" || events[0].Amount != "-1.5" {
+		t.Errorf("Should have exported the conversion as LTC going out, got %+v", events)
+	}
+}
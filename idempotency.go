@@ -0,0 +1,265 @@
+package coinpayments
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotencyStore remembers which IPN deliveries have already been handled.
+type IdempotencyStore interface {
+	// Claim records the key and returns true, or returns false if the key was already claimed and hasn't expired.
+	// The check and insert must be atomic.
+	Claim(key string) (bool, error)
+	// Release forgets a claimed key, so a delivery that failed to be handled can be retried.
+	Release(key string) error
+}
+
+// IPNIDKey is the idempotency key of a single IPN, which stays the same across CoinPayments' retries
+func IPNIDKey(ipnID string) string {
+	return "ipn:" + ipnID
+}
+
+// IPNStatusKey is the idempotency key of a transaction reaching a status. CoinPayments sends one IPN per status
+// change, so this catches the same status being reported under a new ipn_id.
+func IPNStatusKey(txnID, status string) string {
+	return "txn:" + txnID + ":" + status
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps up to a fixed number of keys in memory, evicting the
+// least recently used key when it's full, and forgetting keys once they are older than the TTL.
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+	order    *list.List // front is the most recently used
+	keys     map[string]*list.Element
+}
+
+type idempotencyItem struct {
+	key     string
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns a MemoryIdempotencyStore holding up to capacity keys for ttl each.
+// A capacity or ttl of 0 means no limit.
+func NewMemoryIdempotencyStore(capacity int, ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{capacity: capacity, ttl: ttl, now: time.Now, order: list.New(), keys: map[string]*list.Element{}}
+}
+
+// Claim implements the IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Claim(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if el, ok := s.keys[key]; ok {
+		item := el.Value.(*idempotencyItem)
+		if item.expires.IsZero() || now.Before(item.expires) {
+			s.order.MoveToFront(el)
+			return false, nil
+		}
+		s.remove(el)
+	}
+
+	item := &idempotencyItem{key: key}
+	if s.ttl > 0 {
+		item.expires = now.Add(s.ttl)
+	}
+	s.keys[key] = s.order.PushFront(item)
+
+	if s.capacity > 0 && s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return true, nil
+}
+
+// Release implements the IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.keys[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) remove(el *list.Element) {
+	delete(s.keys, el.Value.(*idempotencyItem).key)
+	s.order.Remove(el)
+}
+
+// FileIdempotencyStore is an IdempotencyStore that survives restarts by appending every claim and release to a file.
+// The file is read back into memory when the store is opened, and rewritten with only the live keys once it has
+// grown to twice their number, dropping released and expired keys.
+type FileIdempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	now       func() time.Time
+	path      string
+	file      *os.File
+	keys      map[string]time.Time // key to the time it was claimed
+	lines     int                  // lines in the file
+	compactAt int                  // number of lines the file is compacted at
+}
+
+// minCompactLines is the smallest file a FileIdempotencyStore bothers compacting
+const minCompactLines = 1024
+
+// OpenFileIdempotencyStore opens, or creates, the store at path. Keys older than ttl are forgotten, a ttl of 0 keeps
+// them forever.
+func OpenFileIdempotencyStore(path string, ttl time.Duration) (*FileIdempotencyStore, error) {
+	s := &FileIdempotencyStore{ttl: ttl, now: time.Now, path: path, keys: map[string]time.Time{}}
+
+	if f, err := os.Open(path); err == nil {
+		err = s.load(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if s.lines > 0 {
+		// start from a compacted file
+		return s, s.compact()
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+	s.compactAt = minCompactLines
+	return s, nil
+}
+
+// load replays the lines of the file, which are either "+ <unix nanos> <key>" for a claim or "- <key>" for a release
+func (s *FileIdempotencyStore) load(f *os.File) error {
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.lines++
+		switch {
+		case strings.HasPrefix(line, "+ "):
+			parts := strings.SplitN(line[2:], " ", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("corrupt idempotency store line: %q", line)
+			}
+			nanos, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("corrupt idempotency store line: %q", line)
+			}
+			s.keys[parts[1]] = time.Unix(0, nanos)
+		case strings.HasPrefix(line, "- "):
+			delete(s.keys, line[2:])
+		case line == "":
+		default:
+			return fmt.Errorf("corrupt idempotency store line: %q", line)
+		}
+	}
+	return scanner.Err()
+}
+
+// Compact rewrites the file with only the keys that haven't been released or expired. It's done automatically as
+// the file grows.
+func (s *FileIdempotencyStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// compact rewrites the file next to the current one and swaps it in, so a crash halfway leaves the old file intact
+func (s *FileIdempotencyStore) compact() error {
+	now := s.now()
+	for key, claimed := range s.keys {
+		if s.ttl > 0 && now.Sub(claimed) >= s.ttl {
+			delete(s.keys, key)
+		}
+	}
+
+	// the new file is kept open for appending, it's the same file once renamed
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for key, claimed := range s.keys {
+		fmt.Fprintf(w, "+ %d %s\n", claimed.UnixNano(), key)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = f
+	s.lines = len(s.keys)
+	s.compactAt = 2*len(s.keys) + minCompactLines
+	return nil
+}
+
+// Claim implements the IdempotencyStore interface
+func (s *FileIdempotencyStore) Claim(key string) (bool, error) {
+	if strings.ContainsAny(key, "\r\n") {
+		return false, fmt.Errorf("idempotency key %q contains a newline", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if claimed, ok := s.keys[key]; ok && (s.ttl == 0 || now.Sub(claimed) < s.ttl) {
+		return false, nil
+	}
+
+	if _, err := fmt.Fprintf(s.file, "+ %d %s\n", now.UnixNano(), key); err != nil {
+		return false, err
+	}
+	s.keys[key] = now
+	s.lines++
+	if s.lines >= s.compactAt && s.compact() != nil {
+		// the claim is on file already, try compacting again later
+		s.compactAt = s.lines + minCompactLines
+	}
+	return true, nil
+}
+
+// Release implements the IdempotencyStore interface
+func (s *FileIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; !ok {
+		return nil
+	}
+	if _, err := fmt.Fprintf(s.file, "- %s\n", key); err != nil {
+		return err
+	}
+	delete(s.keys, key)
+	s.lines++
+	return nil
+}
+
+// Close closes the underlying file
+func (s *FileIdempotencyStore) Close() error {
+	return s.file.Close()
+}
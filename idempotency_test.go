@@ -0,0 +1,124 @@
+package coinpayments_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := coinpayments.NewMemoryIdempotencyStore(2, 0)
+
+	for _, key := range []string{"a", "b"} {
+		if ok, _ := store.Claim(key); !ok {
+			t.Fatalf("Should have claimed %s", key)
+		}
+	}
+	if ok, _ := store.Claim("a"); ok {
+		t.Fatalf("Should not have claimed a twice")
+	}
+
+	// a was just used, so claiming c evicts b
+	store.Claim("c")
+	if ok, _ := store.Claim("b"); !ok {
+		t.Fatalf("Should have evicted b as the least recently used key")
+	}
+
+	store.Release("c")
+	if ok, _ := store.Claim("c"); !ok {
+		t.Fatalf("Should have claimed c again after releasing it")
+	}
+
+	expiring := coinpayments.NewMemoryIdempotencyStore(0, time.Millisecond)
+	expiring.Claim("a")
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := expiring.Claim("a"); !ok {
+		t.Fatalf("Should have claimed a again once it expired")
+	}
+}
+
+func TestFileIdempotencyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ipn.log")
+
+	store, err := coinpayments.OpenFileIdempotencyStore(path, 0)
+	if err != nil {
+		t.Fatalf("Should have opened the store, but it threw error: %s", err.Error())
+	}
+	store.Claim("a")
+	store.Claim("b")
+	store.Release("b")
+	store.Close()
+
+	reopened, err := coinpayments.OpenFileIdempotencyStore(path, 0)
+	if err != nil {
+		t.Fatalf("Should have reopened the store, but it threw error: %s", err.Error())
+	}
+	defer reopened.Close()
+
+	if ok, _ := reopened.Claim("a"); ok {
+		t.Fatalf("Should have remembered a across restarts")
+	}
+	if ok, _ := reopened.Claim("b"); !ok {
+		t.Fatalf("Should have remembered that b was released")
+	}
+}
+
+func TestFileIdempotencyStoreCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ipn.log")
+
+	store, err := coinpayments.OpenFileIdempotencyStore(path, 0)
+	if err != nil {
+		t.Fatalf("Should have opened the store, but it threw error: %s", err.Error())
+	}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("ipn:%d", i)
+		store.Claim(key)
+		if i%10 != 0 {
+			store.Release(key)
+		}
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Should have compacted the store, but it threw error: %s", err.Error())
+	}
+	store.Claim("ipn:last")
+	store.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 301 {
+		t.Fatalf("Should have kept only the 300 claimed keys and the last claim, got %d lines", lines)
+	}
+
+	reopened, err := coinpayments.OpenFileIdempotencyStore(path, 0)
+	if err != nil {
+		t.Fatalf("Should have reopened the store, but it threw error: %s", err.Error())
+	}
+	defer reopened.Close()
+	if ok, _ := reopened.Claim("ipn:10"); ok {
+		t.Fatalf("Should have kept ipn:10 through compaction")
+	}
+	if ok, _ := reopened.Claim("ipn:11"); !ok {
+		t.Fatalf("Should have dropped the released ipn:11")
+	}
+	if ok, _ := reopened.Claim("ipn:last"); ok {
+		t.Fatalf("Should have appended to the compacted file")
+	}
+}
@@ -1,13 +1,196 @@
 package coinpayments
 
 import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
+// Errors returned while verifying an IPN
+var (
+	ErrMissingIPNSecret    = errors.New("ipn secret missing from config struct")
+	ErrMissingIPNSignature = errors.New("ipn is missing its HMAC header")
+	ErrInvalidIPNSignature = errors.New("ipn HMAC signature does not match")
+	ErrIPNMerchantMismatch = errors.New("ipn was sent for a different merchant")
+	ErrIPNModeNotAllowed   = errors.New("ipn was sent with an ipn mode we don't accept")
+	ErrInvalidIPNAuth      = errors.New("ipn HTTP Basic credentials do not match")
+)
+
+// IPN modes, sent by CoinPayments in the ipn_mode field. The IPNMode of the config decides which ones we accept.
+const (
+	IPNModeHMAC     = "hmac"     // the body is signed with the IPN secret in the HMAC header. The default.
+	IPNModeHTTPAuth = "httpauth" // HTTP Basic auth with the merchant id as user and the IPN secret as password
+	IPNModeAny      = "any"      // config only, accepts either of the above
+)
+
+// IPN types sent by CoinPayments in the ipn_type field
+const (
+	IPNTypeAPI        = "api"
+	IPNTypeDeposit    = "deposit"
+	IPNTypeSimple     = "simple"
+	IPNTypeButton     = "button"
+	IPNTypeCart       = "cart"
+	IPNTypeDonation   = "donation"
+	IPNTypeWithdrawal = "withdrawal"
+)
+
+// IPNHeader holds the fields CoinPayments sends with every IPN regardless of its type
+type IPNHeader struct {
+	IPNVersion string `json:"ipn_version"`
+	IPNID      string `json:"ipn_id"`
+	IPNMode    string `json:"ipn_mode"`
+	Merchant   string `json:"merchant"`
+	IPNType    string `json:"ipn_type"`
+}
+
+// VerifyIPN checks that an IPN was sent by CoinPayments, and that it was sent for our merchant if a MerchantID is
+// configured. In hmac mode the raw body must be signed with one of our IPN secrets, in httpauth mode the request must
+// carry our merchant id and one of our IPN secrets as Basic credentials. IPNs using a mode the IPNMode of the config
+// doesn't allow are rejected, so a client configured for hmac can't be downgraded to httpauth.
+// The body has to be read before handing it to the Handle methods:
+// IE: body, _ := ioutil.ReadAll(req.Body)
+// if err := cps.VerifyIPN(req.Header, body); err != nil { ... }
+// resp, err := cps.HandleIPNAPI(bytes.NewReader(body))
+func (c *Client) VerifyIPN(header http.Header, body []byte) error {
+	_, err := c.VerifyIPNSecret(header, body)
+	return err
+}
+
+// VerifyIPNSecret verifies an IPN the same way VerifyIPN does, and returns the name of the IPN secret it was sent with.
+func (c *Client) VerifyIPNSecret(header http.Header, body []byte) (string, error) {
+	return c.verifyIPN(header, body, time.Now(), true)
+}
+
+// verifyIPN verifies an IPN as if it was received at the time, against the secrets active then. Only live IPNs count
+// as uses of the secrets.
+func (c *Client) verifyIPN(header http.Header, body []byte, at time.Time, live bool) (string, error) {
+	if c.IPNSecret == "" && len(c.IPNSecrets) == 0 {
+		return "", ErrMissingIPNSecret
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", err
+	}
+
+	mode := values.Get("ipn_mode")
+	if mode == "" {
+		mode = IPNModeHMAC
+	}
+	if !c.ipnModeAllowed(mode) {
+		return "", ErrIPNModeNotAllowed
+	}
+
+	var secret *IPNSecret
+	switch mode {
+	case IPNModeHMAC:
+		signature := header.Get("HMAC")
+		if signature == "" {
+			return "", ErrMissingIPNSignature
+		}
+
+		secret, err = c.matchIPNSecret(func(s string) bool {
+			return hmac.Equal([]byte(signature), []byte(ipnHMAC(s, body)))
+		}, ErrInvalidIPNSignature, at, live)
+	case IPNModeHTTPAuth:
+		if c.MerchantID == "" {
+			return "", ErrMissingMerchantID
+		}
+
+		user, password, ok := (&http.Request{Header: header}).BasicAuth()
+		matches := func(s string) bool {
+			return subtle.ConstantTimeCompare([]byte(password), []byte(s)) == 1
+		}
+		if redacted := header.Get(RedactedAuthHeader); !ok && !live && redacted != "" {
+			// archived IPN, see RedactedAuthHeader. Live IPNs must come with their credentials.
+			parts := strings.SplitN(redacted, " ", 2)
+			if len(parts) == 2 {
+				user, ok = parts[0], true
+				matches = func(s string) bool {
+					return hmac.Equal([]byte(parts[1]), []byte(ipnHMAC(s, body)))
+				}
+			}
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(c.MerchantID)) != 1 {
+			return "", ErrInvalidIPNAuth
+		}
+		secret, err = c.matchIPNSecret(matches, ErrInvalidIPNAuth, at, live)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return secret.Name, c.verifyIPNMerchant(body)
+}
+
+// ipnModeAllowed returns whether the config lets us accept IPNs sent with the mode
+func (c *Client) ipnModeAllowed(mode string) bool {
+	switch c.IPNMode {
+	case "", IPNModeHMAC:
+		return mode == IPNModeHMAC
+	case IPNModeHTTPAuth:
+		return mode == IPNModeHTTPAuth
+	case IPNModeAny:
+		return mode == IPNModeHMAC || mode == IPNModeHTTPAuth
+	}
+	return false
+}
+
+// verifyIPNMerchant checks the merchant field of an IPN against our MerchantID, if we have one
+func (c *Client) verifyIPNMerchant(body []byte) error {
+	if c.MerchantID == "" {
+		return nil
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+	if values.Get("merchant") != c.MerchantID {
+		return ErrIPNMerchantMismatch
+	}
+	return nil
+}
+
+// ipnHMAC returns the hex encoded HMAC-SHA512 of an IPN body, keyed with the IPN secret
+func ipnHMAC(secret string, body []byte) string {
+	hash := hmac.New(sha512.New, []byte(secret))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// readIPN reads the post body of an IPN and parses it into its url values.
+func readIPN(reader io.Reader) (url.Values, error) {
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.ParseQuery(string(body))
+}
+
+// ipnHeader builds the IPNHeader out of the parsed values of an IPN
+func ipnHeader(values url.Values) IPNHeader {
+	return IPNHeader{
+		IPNVersion: values.Get("ipn_version"),
+		IPNID:      values.Get("ipn_id"),
+		IPNMode:    values.Get("ipn_mode"),
+		Merchant:   values.Get("merchant"),
+		IPNType:    values.Get("ipn_type"),
+	}
+}
+
 // IPNDepositResponse is a representation of a response received when any update is happening on a deposit
 type IPNDepositResponse struct {
+	IPNHeader
 	Address    string `json:"address"`
 	TxnID      string `json:"txn_id"`
 	Status     string `json:"status"`
@@ -26,17 +209,13 @@ type IPNDepositResponse struct {
 // IE: cps.HandleIPNDeposit(req.Body)
 func (c *Client) HandleIPNDeposit(reader io.Reader) (*IPNDepositResponse, error) {
 
-	body, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	values, err := url.ParseQuery(string(body))
+	values, err := readIPN(reader)
 	if err != nil {
 		return nil, err
 	}
 
 	return &IPNDepositResponse{
+		IPNHeader:  ipnHeader(values),
 		Address:    values.Get("address"),
 		TxnID:      values.Get("txn_id"),
 		Status:     values.Get("status"),
@@ -53,6 +232,7 @@ func (c *Client) HandleIPNDeposit(reader io.Reader) (*IPNDepositResponse, error)
 
 // IPNAPIResponse is the response we expect back from the server when the command is "api"
 type IPNAPIResponse struct {
+	IPNHeader
 	Status           string `json:"status"`
 	StatusText       string `json:"status_text"`
 	TxnID            string `json:"txn_id"`
@@ -75,16 +255,118 @@ type IPNAPIResponse struct {
 // HandleIPNAPI handles the IPN API on input and gives a response
 func (c *Client) HandleIPNAPI(reader io.Reader) (*IPNAPIResponse, error) {
 
-	body, err := ioutil.ReadAll(reader)
+	values, err := readIPN(reader)
 	if err != nil {
 		return nil, err
 	}
+	return &IPNAPIResponse{
+		IPNHeader:        ipnHeader(values),
+		Status:           values.Get("status"),
+		StatusText:       values.Get("status_text"),
+		TxnID:            values.Get("txn_id"),
+		Currency1:        values.Get("currency1"),
+		Currency2:        values.Get("currency2"),
+		Amount1:          values.Get("amount1"),
+		Amount2:          values.Get("amount2"),
+		Fee:              values.Get("fee"),
+		BuyerName:        values.Get("buyer_name"),
+		Email:            values.Get("email"),
+		ItemName:         values.Get("item_name"),
+		ItemNumber:       values.Get("item_number"),
+		Invoice:          values.Get("invoice"),
+		Custom:           values.Get("custom"),
+		SendTX:           values.Get("send_tx"),
+		ReceivedAmount:   values.Get("received_amount"),
+		ReceivedConfirms: values.Get("received_confirms"),
+	}, nil
+}
+
+// ErrUnexpectedIPNType is returned when an IPN is handed to a handler for a different ipn_type
+var ErrUnexpectedIPNType = errors.New("ipn type is not supported by this handler")
+
+// IPNShippingAddress is the shipping information the buyer entered on the checkout. It's only sent when the button
+// was created with want_shipping turned on.
+type IPNShippingAddress struct {
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Company     string `json:"company"`
+	Address1    string `json:"address1"`
+	Address2    string `json:"address2"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	Zip         string `json:"zip"`
+	Country     string `json:"country"` // 2 letter country code
+	CountryName string `json:"country_name"`
+	Phone       string `json:"phone"`
+}
+
+// ipnShippingAddress builds the shipping address out of the parsed values of an IPN
+func ipnShippingAddress(values url.Values) IPNShippingAddress {
+	return IPNShippingAddress{
+		FirstName:   values.Get("first_name"),
+		LastName:    values.Get("last_name"),
+		Company:     values.Get("company"),
+		Address1:    values.Get("address1"),
+		Address2:    values.Get("address2"),
+		City:        values.Get("city"),
+		State:       values.Get("state"),
+		Zip:         values.Get("zip"),
+		Country:     values.Get("country"),
+		CountryName: values.Get("country_name"),
+		Phone:       values.Get("phone"),
+	}
+}
 
-	values, err := url.ParseQuery(string(body))
+// IPNButtonResponse is the response we expect back from the server for payments made through a merchant button,
+// which covers the "simple", "button" and "donation" ipn types. Carts are handled by HandleIPNCart.
+type IPNButtonResponse struct {
+	IPNHeader
+	Status           string             `json:"status"`
+	StatusText       string             `json:"status_text"`
+	TxnID            string             `json:"txn_id"`
+	Currency1        string             `json:"currency1"`
+	Currency2        string             `json:"currency2"`
+	Amount1          string             `json:"amount1"`
+	Amount2          string             `json:"amount2"`
+	Fee              string             `json:"fee"`
+	BuyerName        string             `json:"buyer_name"`
+	Email            string             `json:"email"`
+	ItemName         string             `json:"item_name"`
+	ItemNumber       string             `json:"item_number"`
+	Quantity         string             `json:"quantity"`
+	Option1Name      string             `json:"on1"`
+	Option1Value     string             `json:"ov1"`
+	Option2Name      string             `json:"on2"`
+	Option2Value     string             `json:"ov2"`
+	Extra            string             `json:"extra"` // note from the buyer, if allow_extra was set on the button
+	Subtotal         string             `json:"subtotal"`
+	Tax              string             `json:"tax"`
+	Shipping         string             `json:"shipping"`
+	Invoice          string             `json:"invoice"`
+	Custom           string             `json:"custom"`
+	SendTX           string             `json:"send_tx"`
+	ReceivedAmount   string             `json:"received_amount"`
+	ReceivedConfirms string             `json:"received_confirms"`
+	ShippingAddress  IPNShippingAddress `json:"shipping_address"`
+}
+
+// HandleIPNButton handles the IPN sent for merchant button payments (simple, button and donation).
+// IE: cps.HandleIPNButton(req.Body)
+func (c *Client) HandleIPNButton(reader io.Reader) (*IPNButtonResponse, error) {
+
+	values, err := readIPN(reader)
 	if err != nil {
 		return nil, err
 	}
-	return &IPNAPIResponse{
+
+	switch values.Get("ipn_type") {
+	case IPNTypeSimple, IPNTypeButton, IPNTypeDonation:
+	default:
+		return nil, ErrUnexpectedIPNType
+	}
+
+	return &IPNButtonResponse{
+		IPNHeader:        ipnHeader(values),
 		Status:           values.Get("status"),
 		StatusText:       values.Get("status_text"),
 		TxnID:            values.Get("txn_id"),
@@ -97,10 +379,20 @@ func (c *Client) HandleIPNAPI(reader io.Reader) (*IPNAPIResponse, error) {
 		Email:            values.Get("email"),
 		ItemName:         values.Get("item_name"),
 		ItemNumber:       values.Get("item_number"),
+		Quantity:         values.Get("quantity"),
+		Option1Name:      values.Get("on1"),
+		Option1Value:     values.Get("ov1"),
+		Option2Name:      values.Get("on2"),
+		Option2Value:     values.Get("ov2"),
+		Extra:            values.Get("extra"),
+		Subtotal:         values.Get("subtotal"),
+		Tax:              values.Get("tax"),
+		Shipping:         values.Get("shipping"),
 		Invoice:          values.Get("invoice"),
 		Custom:           values.Get("custom"),
 		SendTX:           values.Get("send_tx"),
 		ReceivedAmount:   values.Get("received_amount"),
 		ReceivedConfirms: values.Get("received_confirms"),
+		ShippingAddress:  ipnShippingAddress(values),
 	}, nil
 }
@@ -0,0 +1,304 @@
+package coinpayments
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchivedIPN is an IPN exactly as it was delivered to us
+type ArchivedIPN struct {
+	ReceivedAt time.Time   `json:"received_at"`
+	RemoteAddr string      `json:"remote_addr"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// RedactedAuthHeader replaces the Authorization header of archived httpauth IPNs. It holds the user and the HMAC of the
+// body keyed with the password, which verifies the IPN on replay as the HMAC header would, without keeping the IPN
+// secret on disk. It's only accepted from the archive, by VerifyArchivedIPN and IPNHandler.Replay: live IPNs, and
+// archived ones replayed to an IPN URL, need the Authorization header.
+const RedactedAuthHeader = "X-Redacted-Basic-Auth"
+
+// redactIPNHeader returns a copy of the header fit for the archive, without the Authorization header
+func redactIPNHeader(header http.Header, body []byte) http.Header {
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		redacted[name] = append([]string(nil), values...)
+	}
+	redacted.Del("Authorization")
+	if user, password, ok := (&http.Request{Header: header}).BasicAuth(); ok {
+		redacted.Set(RedactedAuthHeader, user+" "+ipnHMAC(password, body))
+	}
+	return redacted
+}
+
+// VerifyArchivedIPN verifies an archived IPN the same way VerifyIPN does, against the IPN secrets that were active when
+// it was received. The archive keeps every IPN delivered, forged ones included, so check them before trusting them.
+func (c *Client) VerifyArchivedIPN(ipn *ArchivedIPN) error {
+	_, err := c.verifyIPN(ipn.Header, []byte(ipn.Body), ipn.ReceivedAt, false)
+	return err
+}
+
+// values parses the body of the archived IPN
+func (a *ArchivedIPN) values() url.Values {
+	values, _ := url.ParseQuery(a.Body)
+	return values
+}
+
+// ipnArchiveRotation is the layout of the suffix of rotated archive files
+const ipnArchiveRotation = "20060102T150405.000000000"
+
+// IPNArchive appends every IPN it's given to a JSON Lines file, one ArchivedIPN per line. Once the file grows past
+// MaxBytes it's renamed with the time of the rotation appended, ie: ipn.jsonl.20190102T150405.000000000, and a new file
+// is started.
+type IPNArchive struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	now      func() time.Time
+	MaxBytes int64 // 0 never rotates
+}
+
+// OpenIPNArchive opens, or creates, the archive at path
+func OpenIPNArchive(path string, maxBytes int64) (*IPNArchive, error) {
+	a := &IPNArchive{path: path, now: time.Now, MaxBytes: maxBytes}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *IPNArchive) open() error {
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, info.Size()
+	return nil
+}
+
+// Append writes the IPN to the archive, rotating the file first if it's full
+func (a *IPNArchive) Append(ipn *ArchivedIPN) error {
+	line, err := json.Marshal(ipn)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.MaxBytes > 0 && a.size > 0 && a.size+int64(len(line)) > a.MaxBytes {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *IPNArchive) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	rotated := a.path + "." + a.now().UTC().Format(ipnArchiveRotation)
+	if err := os.Rename(a.path, rotated); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// Close closes the archive file
+func (a *IPNArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// IPNArchiveFiles returns the rotated files of the archive at path, oldest first, followed by the current file. Other
+// files next to it, ie: ipn.jsonl.bak, are left out.
+func IPNArchiveFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	for _, name := range matches {
+		if _, err := time.Parse(ipnArchiveRotation, strings.TrimPrefix(name, path+".")); err == nil {
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Strings(rotated)
+
+	files := rotated
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// ReadIPNArchive reads every IPN of the archive at path, including its rotated files, in the order they were received
+func ReadIPNArchive(path string) ([]ArchivedIPN, error) {
+	files, err := IPNArchiveFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ipns []ArchivedIPN
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var ipn ArchivedIPN
+			if err := json.Unmarshal(scanner.Bytes(), &ipn); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, line, err)
+			}
+			ipns = append(ipns, ipn)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ipns, nil
+}
+
+// IPNFilter selects archived IPNs to replay. Empty fields match everything.
+type IPNFilter struct {
+	TxnIDs []string
+	Types  []string
+	Since  time.Time
+	Until  time.Time
+}
+
+// Match returns whether the archived IPN passes the filter
+func (f *IPNFilter) Match(ipn *ArchivedIPN) bool {
+	if !f.Since.IsZero() && ipn.ReceivedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !ipn.ReceivedAt.Before(f.Until) {
+		return false
+	}
+
+	values := ipn.values()
+	if len(f.Types) > 0 && !stringExistsInSlice(f.Types, values.Get("ipn_type")) {
+		return false
+	}
+	if len(f.TxnIDs) > 0 && !stringExistsInSlice(f.TxnIDs, values.Get("txn_id")) && !stringExistsInSlice(f.TxnIDs, values.Get("id")) {
+		return false
+	}
+	return true
+}
+
+// IPNReplayTarget is something archived IPNs can be fed back into
+type IPNReplayTarget interface {
+	Replay(ipn *ArchivedIPN) error
+}
+
+// Replay implements the IPNReplayTarget interface by running the archived IPN through the handler. It's verified
+// like VerifyArchivedIPN does, and goes through the idempotency store like a live IPN, so IPNs the handler already
+// handled aren't dispatched again. Use an IPNHandlerTarget with Force to dispatch them anyway.
+func (h *IPNHandler) Replay(ipn *ArchivedIPN) error {
+	return (&IPNHandlerTarget{Handler: h}).Replay(ipn)
+}
+
+// IPNHandlerTarget replays archived IPNs through a handler. With Force set, they're dispatched even if the
+// idempotency store has seen them, ie: to rebuild what the callbacks keep after fixing a bug in them. Side effects of
+// the callbacks, like ledger credits, then happen again.
+type IPNHandlerTarget struct {
+	Handler *IPNHandler
+	Force   bool
+}
+
+// Replay implements the IPNReplayTarget interface
+func (t *IPNHandlerTarget) Replay(ipn *ArchivedIPN) error {
+	secret, err := t.Handler.Client.verifyIPN(ipn.Header, []byte(ipn.Body), ipn.ReceivedAt, false)
+	if err != nil {
+		return err
+	}
+	_, err = t.Handler.handle(secret, []byte(ipn.Body), t.Force)
+	return err
+}
+
+// IPNURLTarget replays archived IPNs by posting them, with their original headers, to an IPN URL. The IPN handler
+// behind the URL still drops IPNs its idempotency store has seen.
+type IPNURLTarget struct {
+	URL        string
+	HTTPClient HTTPClient
+}
+
+// Replay implements the IPNReplayTarget interface
+func (t *IPNURLTarget) Replay(ipn *ArchivedIPN) error {
+	req, err := http.NewRequest("POST", t.URL, strings.NewReader(ipn.Body))
+	if err != nil {
+		return err
+	}
+	for name, values := range ipn.Header {
+		// the body is sent as is, so let the transport work the length out again
+		if name == "Content-Length" {
+			continue
+		}
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+
+	resp, err := t.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to replay ipn: expected status 2xx, got %s", resp.Status)
+	}
+	return nil
+}
+
+// IPNReplayResult is the outcome of replaying a single archived IPN
+type IPNReplayResult struct {
+	IPN   ArchivedIPN
+	Error error
+}
+
+// ReplayIPNs feeds every archived IPN that passes the filter into the target, in order, and returns the outcome
+// of each one. Failures don't stop the replay.
+func ReplayIPNs(ipns []ArchivedIPN, filter IPNFilter, target IPNReplayTarget) []IPNReplayResult {
+	var results []IPNReplayResult
+	for i := range ipns {
+		if !filter.Match(&ipns[i]) {
+			continue
+		}
+		results = append(results, IPNReplayResult{IPN: ipns[i], Error: target.Replay(&ipns[i])})
+	}
+	return results
+}
@@ -0,0 +1,175 @@
+package coinpayments_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestIPNArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipnarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ipn.jsonl")
+
+	// small enough that every IPN lands in its own file
+	archive, err := coinpayments.OpenIPNArchive(path, 10)
+	if err != nil {
+		t.Fatalf("Should have opened the archive, but it threw error: %s", err.Error())
+	}
+
+	var calls int
+	handler := &coinpayments.IPNHandler{
+		Client:  offlineClient(t),
+		Archive: archive,
+		OnAPI: func(resp *coinpayments.IPNAPIResponse) error {
+			calls++
+			return nil
+		},
+	}
+
+	bodies := []string{
+		"ipn_type=api&ipn_id=1&merchant=merchantid&txn_id=CP1&status=100",
+		"ipn_type=api&ipn_id=2&merchant=merchantid&txn_id=CP2&status=100",
+	}
+	for _, body := range bodies {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, signedIPNRequest(body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Should have handled the ipn, got %d", w.Code)
+		}
+		time.Sleep(time.Millisecond) // rotated files are named after the time of rotation
+	}
+	archive.Close()
+	if err := ioutil.WriteFile(path+".bak", []byte("not an archive\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := coinpayments.IPNArchiveFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Should have rotated the archive once and left other files out, got files %v", files)
+	}
+
+	ipns, err := coinpayments.ReadIPNArchive(path)
+	if err != nil {
+		t.Fatalf("Should have read the archive, but it threw error: %s", err.Error())
+	}
+	if len(ipns) != 2 || ipns[0].Body != bodies[0] || ipns[1].Body != bodies[1] || ipns[0].Header.Get("HMAC") == "" {
+		t.Fatalf("Read the wrong ipns back: %+v", ipns)
+	}
+
+	results := coinpayments.ReplayIPNs(ipns, coinpayments.IPNFilter{TxnIDs: []string{"CP2"}}, handler)
+	if len(results) != 1 || results[0].Error != nil {
+		t.Fatalf("Should have replayed CP2 through the handler, got %+v", results)
+	}
+	if calls != 3 {
+		t.Fatalf("Expected the handler to have been called 3 times, got %d", calls)
+	}
+
+	// the archive is closed, so stop archiving before serving the replays
+	handler.Archive = nil
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	results = coinpayments.ReplayIPNs(ipns, coinpayments.IPNFilter{Types: []string{coinpayments.IPNTypeAPI}}, &coinpayments.IPNURLTarget{URL: server.URL, HTTPClient: server.Client()})
+	if len(results) != 2 || results[0].Error != nil || results[1].Error != nil {
+		t.Fatalf("Should have replayed both ipns to the url, got %+v", results)
+	}
+
+	if results := coinpayments.ReplayIPNs(ipns, coinpayments.IPNFilter{Since: time.Now()}, handler); len(results) != 0 {
+		t.Fatalf("Should have filtered out ipns received before since, got %+v", results)
+	}
+}
+
+func TestIPNArchiveHTTPAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipnarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ipn.jsonl")
+
+	archive, err := coinpayments.OpenIPNArchive(path, 0)
+	if err != nil {
+		t.Fatalf("Should have opened the archive, but it threw error: %s", err.Error())
+	}
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey", MerchantID: "merchantid", IPNSecret: "ipnsecret", IPNMode: coinpayments.IPNModeHTTPAuth}, &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	handler := &coinpayments.IPNHandler{
+		Client:      client,
+		Archive:     archive,
+		Idempotency: coinpayments.NewMemoryIdempotencyStore(100, 0),
+		OnAPI: func(resp *coinpayments.IPNAPIResponse) error {
+			calls++
+			return nil
+		},
+	}
+
+	body := "ipn_mode=httpauth&ipn_type=api&ipn_id=1&merchant=merchantid&txn_id=CP1&status=100"
+	req := httptest.NewRequest("POST", "/ipn", strings.NewReader(body))
+	req.SetBasicAuth("merchantid", "ipnsecret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Should have handled the ipn, got %d: %s", w.Code, w.Body.String())
+	}
+	archive.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Authorization") || strings.Contains(string(data), base64.StdEncoding.EncodeToString([]byte("merchantid:ipnsecret"))) {
+		t.Fatalf("Should have redacted the credentials from the archive: %s", data)
+	}
+
+	// the replay is verified from the redacted header, and dropped as the ipn was handled already
+	ipns, err := coinpayments.ReadIPNArchive(path)
+	if err != nil {
+		t.Fatalf("Should have read the archive, but it threw error: %s", err.Error())
+	}
+	handler.Archive = nil
+	results := coinpayments.ReplayIPNs(ipns, coinpayments.IPNFilter{}, handler)
+	if len(results) != 1 || results[0].Error != nil {
+		t.Fatalf("Should have replayed the ipn through the handler, got %+v", results)
+	}
+	if calls != 1 {
+		t.Fatalf("Should not have dispatched an ipn the handler already handled, got %d calls", calls)
+	}
+
+	// unless the replay is forced
+	results = coinpayments.ReplayIPNs(ipns, coinpayments.IPNFilter{}, &coinpayments.IPNHandlerTarget{Handler: handler, Force: true})
+	if len(results) != 1 || results[0].Error != nil || calls != 2 {
+		t.Fatalf("Should have dispatched the forced replay, got %+v with %d calls", results, calls)
+	}
+
+	// live IPNs can't stand in the redacted header for their credentials
+	live := httptest.NewRequest("POST", "/ipn", strings.NewReader(ipns[0].Body))
+	live.Header = ipns[0].Header
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, live)
+	if w.Code != http.StatusUnauthorized || calls != 2 {
+		t.Fatalf("Should have refused a live ipn with the redacted header, got %d with %d calls", w.Code, calls)
+	}
+
+	// a forged redacted header doesn't verify
+	ipns[0].Header.Set(coinpayments.RedactedAuthHeader, "merchantid 00")
+	if results := coinpayments.ReplayIPNs(ipns, coinpayments.IPNFilter{}, handler); results[0].Error != coinpayments.ErrInvalidIPNAuth {
+		t.Fatalf("Should have rejected the forged header, got %v", results[0].Error)
+	}
+}
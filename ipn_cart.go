@@ -0,0 +1,112 @@
+package coinpayments
+
+import (
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// LineItem is a single item of a cart payment
+type LineItem struct {
+	Name         string `json:"item_name"`
+	Number       string `json:"item_number"`
+	Amount       string `json:"item_amount"` // price of a single item in currency1
+	Quantity     string `json:"item_quantity"`
+	Option1Name  string `json:"item_on1"`
+	Option1Value string `json:"item_ov1"`
+	Option2Name  string `json:"item_on2"`
+	Option2Value string `json:"item_ov2"`
+}
+
+// IPNCartResponse is the response we expect back from the server when the ipn_type is "cart"
+type IPNCartResponse struct {
+	IPNHeader
+	Status           string             `json:"status"`
+	StatusText       string             `json:"status_text"`
+	TxnID            string             `json:"txn_id"`
+	Currency1        string             `json:"currency1"`
+	Currency2        string             `json:"currency2"`
+	Amount1          string             `json:"amount1"`
+	Amount2          string             `json:"amount2"`
+	Fee              string             `json:"fee"`
+	BuyerName        string             `json:"buyer_name"`
+	Email            string             `json:"email"`
+	Items            []LineItem         `json:"items"`
+	Extra            string             `json:"extra"`
+	Subtotal         string             `json:"subtotal"`
+	Tax              string             `json:"tax"`
+	Shipping         string             `json:"shipping"`
+	Invoice          string             `json:"invoice"`
+	Custom           string             `json:"custom"`
+	SendTX           string             `json:"send_tx"`
+	ReceivedAmount   string             `json:"received_amount"`
+	ReceivedConfirms string             `json:"received_confirms"`
+	ShippingAddress  IPNShippingAddress `json:"shipping_address"`
+}
+
+// lineItems collects the numbered item fields (item_name_1, item_amount_1, ...) of a cart IPN into a slice.
+// Items are numbered from 1, and we stop at the first number without an item name.
+func lineItems(values url.Values) []LineItem {
+	var items []LineItem
+	for i := 1; ; i++ {
+		n := strconv.Itoa(i)
+		if _, ok := values["item_name_"+n]; !ok {
+			return items
+		}
+
+		quantity := values.Get("item_quantity_" + n)
+		if quantity == "" {
+			quantity = values.Get("quantity_" + n)
+		}
+
+		items = append(items, LineItem{
+			Name:         values.Get("item_name_" + n),
+			Number:       values.Get("item_number_" + n),
+			Amount:       values.Get("item_amount_" + n),
+			Quantity:     quantity,
+			Option1Name:  values.Get("item_on1_" + n),
+			Option1Value: values.Get("item_ov1_" + n),
+			Option2Name:  values.Get("item_on2_" + n),
+			Option2Value: values.Get("item_ov2_" + n),
+		})
+	}
+}
+
+// HandleIPNCart handles the IPN sent for payments made through a shopping cart button.
+// IE: cps.HandleIPNCart(req.Body)
+func (c *Client) HandleIPNCart(reader io.Reader) (*IPNCartResponse, error) {
+
+	values, err := readIPN(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if values.Get("ipn_type") != IPNTypeCart {
+		return nil, ErrUnexpectedIPNType
+	}
+
+	return &IPNCartResponse{
+		IPNHeader:        ipnHeader(values),
+		Status:           values.Get("status"),
+		StatusText:       values.Get("status_text"),
+		TxnID:            values.Get("txn_id"),
+		Currency1:        values.Get("currency1"),
+		Currency2:        values.Get("currency2"),
+		Amount1:          values.Get("amount1"),
+		Amount2:          values.Get("amount2"),
+		Fee:              values.Get("fee"),
+		BuyerName:        values.Get("buyer_name"),
+		Email:            values.Get("email"),
+		Items:            lineItems(values),
+		Extra:            values.Get("extra"),
+		Subtotal:         values.Get("subtotal"),
+		Tax:              values.Get("tax"),
+		Shipping:         values.Get("shipping"),
+		Invoice:          values.Get("invoice"),
+		Custom:           values.Get("custom"),
+		SendTX:           values.Get("send_tx"),
+		ReceivedAmount:   values.Get("received_amount"),
+		ReceivedConfirms: values.Get("received_confirms"),
+		ShippingAddress:  ipnShippingAddress(values),
+	}, nil
+}
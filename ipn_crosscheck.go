@@ -0,0 +1,200 @@
+package coinpayments
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrTransactionNotFound is returned by a TransactionRequestStore when there's no request stored for a txn id
+var ErrTransactionNotFound = errors.New("transaction request not found")
+
+// TransactionRequestStore keeps the TransactionRequest each transaction was created with, keyed by its txn id
+type TransactionRequestStore interface {
+	SaveTransactionRequest(txnID string, req *TransactionRequest) error
+	TransactionRequest(txnID string) (*TransactionRequest, error)
+}
+
+// MemoryTransactionRequestStore is a TransactionRequestStore that keeps everything in memory
+type MemoryTransactionRequestStore struct {
+	mu       sync.RWMutex
+	requests map[string]TransactionRequest
+}
+
+// NewMemoryTransactionRequestStore returns an empty MemoryTransactionRequestStore
+func NewMemoryTransactionRequestStore() *MemoryTransactionRequestStore {
+	return &MemoryTransactionRequestStore{requests: map[string]TransactionRequest{}}
+}
+
+// SaveTransactionRequest implements the TransactionRequestStore interface
+func (s *MemoryTransactionRequestStore) SaveTransactionRequest(txnID string, req *TransactionRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[txnID] = *req
+	return nil
+}
+
+// TransactionRequest implements the TransactionRequestStore interface
+func (s *MemoryTransactionRequestStore) TransactionRequest(txnID string) (*TransactionRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	req, ok := s.requests[txnID]
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	return &req, nil
+}
+
+// IPNMismatch is a single field of an IPN that doesn't agree with the API or the original request
+type IPNMismatch struct {
+	Field  string // name of the IPN field
+	Source string // "api" or "request"
+	IPN    string
+	Want   string // "(missing)" for a field get_tx_info didn't return
+}
+
+// missingField is the Want of a mismatch for a field get_tx_info didn't return
+const missingField = "(missing)"
+
+// IPNConsistencyError is returned when an IPN doesn't agree with what the API or the original request say about
+// its transaction, which means it was tampered with or something is badly wrong.
+type IPNConsistencyError struct {
+	TxnID      string
+	Mismatches []IPNMismatch
+}
+
+func (e *IPNConsistencyError) Error() string {
+	parts := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		parts[i] = fmt.Sprintf("%s: ipn has %q, %s has %q", m.Field, m.IPN, m.Source, m.Want)
+	}
+	return fmt.Sprintf("ipn for %s is inconsistent: %s", e.TxnID, strings.Join(parts, "; "))
+}
+
+// IPNCrossChecker checks completed API IPNs against get_tx_info and the TransactionRequest that created the
+// transaction before they are acted on.
+type IPNCrossChecker struct {
+	client *Client
+
+	// Requests, if set, is where the original TransactionRequest of each transaction is looked up. Transactions
+	// missing from it fail the check.
+	Requests TransactionRequestStore
+}
+
+// NewIPNCrossChecker returns an IPNCrossChecker that looks transactions up with the client
+func NewIPNCrossChecker(client *Client, requests TransactionRequestStore) *IPNCrossChecker {
+	return &IPNCrossChecker{client: client, Requests: requests}
+}
+
+// Check fetches the transaction of the IPN and returns an *IPNConsistencyError if the IPN doesn't match it. It fails
+// closed: a status, coin, amount or checkout field missing from get_tx_info is a mismatch.
+func (c *IPNCrossChecker) Check(ipn *IPNAPIResponse) error {
+	info, err := c.client.CallGetTxInfo(&TxInfoRequest{TxID: ipn.TxnID, Full: "1"})
+	if err != nil {
+		return err
+	}
+
+	var mismatches []IPNMismatch
+	mismatch := func(field, source, got, want string) {
+		mismatches = append(mismatches, IPNMismatch{Field: field, Source: source, IPN: got, Want: want})
+	}
+
+	// a field missing from get_tx_info fails the check, as the IPN can't be vouched for without it
+	expect := func(result map[string]interface{}, key, field, got string, same func(a, b string) bool) {
+		want, ok := txInfoString(result, key)
+		if !ok {
+			mismatch(field, "api", got, missingField)
+		} else if !same(got, want) {
+			mismatch(field, "api", got, want)
+		}
+	}
+	expect(info.Result, "status", "status", ipn.Status, sameStatusClass)
+	expect(info.Result, "coin", "currency2", ipn.Currency2, strings.EqualFold)
+	expect(info.Result, "amountf", "amount2", ipn.Amount2, sameAmount)
+	expect(info.Result, "receivedf", "received_amount", ipn.ReceivedAmount, sameAmount)
+
+	checkout, _ := info.Result["checkout"].(map[string]interface{})
+	expect(checkout, "currency", "currency1", ipn.Currency1, strings.EqualFold)
+	expect(checkout, "amountf", "amount1", ipn.Amount1, sameAmount)
+	// invoice and custom are optional, missing is empty
+	if invoice, _ := txInfoString(checkout, "invoice"); invoice != ipn.Invoice {
+		mismatch("invoice", "api", ipn.Invoice, invoice)
+	}
+	if custom, _ := txInfoString(checkout, "custom"); custom != ipn.Custom {
+		mismatch("custom", "api", ipn.Custom, custom)
+	}
+
+	if c.Requests != nil {
+		req, err := c.Requests.TransactionRequest(ipn.TxnID)
+		if err != nil {
+			return err
+		}
+		if !sameAmount(req.Amount, ipn.Amount1) {
+			mismatch("amount1", "request", ipn.Amount1, req.Amount)
+		}
+		if !strings.EqualFold(req.Currency1, ipn.Currency1) {
+			mismatch("currency1", "request", ipn.Currency1, req.Currency1)
+		}
+		if !strings.EqualFold(req.Currency2, ipn.Currency2) {
+			mismatch("currency2", "request", ipn.Currency2, req.Currency2)
+		}
+		if req.Invoice != ipn.Invoice {
+			mismatch("invoice", "request", ipn.Invoice, req.Invoice)
+		}
+		if req.Custom != ipn.Custom {
+			mismatch("custom", "request", ipn.Custom, req.Custom)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return &IPNConsistencyError{TxnID: ipn.TxnID, Mismatches: mismatches}
+	}
+	return nil
+}
+
+// ipnComplete returns whether an IPN status means the payment is complete
+func ipnComplete(status string) bool {
+	s, err := strconv.Atoi(status)
+	return err == nil && s >= 100
+}
+
+// sameStatusClass returns whether two statuses agree on the payment being complete, pending or failed. The API and
+// the IPN can be a step apart, so we don't compare the exact codes.
+func sameStatusClass(a, b string) bool {
+	class := func(status string) int {
+		s, err := strconv.Atoi(status)
+		switch {
+		case err != nil:
+			return -2
+		case s >= 100 || s == 2:
+			return 1
+		case s < 0:
+			return -1
+		}
+		return 0
+	}
+	return class(a) == class(b)
+}
+
+// sameAmount compares two decimal amounts numerically, so "1.5" and "1.50000000" are the same
+func sameAmount(a, b string) bool {
+	x, errA := ParseSatoshis(a)
+	y, errB := ParseSatoshis(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return x == y
+}
+
+// txInfoString returns a field of a get_tx_info result as a string. Numbers come back from the API as float64.
+func txInfoString(result map[string]interface{}, key string) (string, bool) {
+	switch v := result[key].(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
@@ -0,0 +1,74 @@
+package coinpayments_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestIPNCrossChecker(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo: {`{"error":"ok","result":{"status":100,"coin":"BTC","amountf":"0.01000000","receivedf":"0.01000000","checkout":{"currency":"USD","amountf":"100.00000000","invoice":"inv-1","custom":""}}}`},
+	}}
+	client := fakeClient(t, api)
+
+	requests := coinpayments.NewMemoryTransactionRequestStore()
+	requests.SaveTransactionRequest("CP1", &coinpayments.TransactionRequest{Amount: "100", Currency1: "USD", Currency2: "BTC", Invoice: "inv-1"})
+	checker := coinpayments.NewIPNCrossChecker(client, requests)
+
+	ipn := &coinpayments.IPNAPIResponse{TxnID: "CP1", Status: "100", Currency1: "USD", Currency2: "BTC", Amount1: "100.00", Amount2: "0.01", ReceivedAmount: "0.01", Invoice: "inv-1"}
+	if err := checker.Check(ipn); err != nil {
+		t.Fatalf("Should have passed a consistent ipn, but it threw error: %s", err.Error())
+	}
+
+	tampered := *ipn
+	tampered.Amount1 = "1.00"
+	err := checker.Check(&tampered)
+	consistency, ok := err.(*coinpayments.IPNConsistencyError)
+	if !ok {
+		t.Fatalf("Should have failed a tampered ipn with a consistency error, got %v", err)
+	}
+	if len(consistency.Mismatches) != 2 {
+		t.Fatalf("Should have flagged amount1 against both the api and the request, got %+v", consistency.Mismatches)
+	}
+
+	unknown := *ipn
+	unknown.TxnID = "CP2"
+	if err := checker.Check(&unknown); err != coinpayments.ErrTransactionNotFound {
+		t.Fatalf("Should have failed an ipn for a transaction we never created, got %v", err)
+	}
+
+	// fields missing from get_tx_info fail the check
+	api.responses[coinpayments.CmdGetTxInfo] = []string{`{"error":"ok","result":{"coin":"BTC","receivedf":"0.01000000"}}`}
+	err = checker.Check(ipn)
+	if consistency, ok = err.(*coinpayments.IPNConsistencyError); !ok {
+		t.Fatalf("Should have failed an ipn get_tx_info doesn't fully vouch for, got %v", err)
+	}
+	missing := map[string]bool{}
+	for _, m := range consistency.Mismatches {
+		if m.Want == "(missing)" {
+			missing[m.Field] = true
+		}
+	}
+	if len(missing) != 4 || !missing["status"] || !missing["amount2"] || !missing["currency1"] || !missing["amount1"] {
+		t.Fatalf("Should have flagged the missing status, amounts and checkout, got %+v", consistency.Mismatches)
+	}
+	api.responses[coinpayments.CmdGetTxInfo] = []string{`{"error":"ok","result":{"status":100,"coin":"BTC","amountf":"0.01000000","receivedf":"0.01000000","checkout":{"currency":"USD","amountf":"100.00000000","invoice":"inv-1","custom":""}}}`}
+
+	var calls int
+	handler := &coinpayments.IPNHandler{
+		Client:     client,
+		CrossCheck: checker,
+		OnAPI: func(resp *coinpayments.IPNAPIResponse) error {
+			calls++
+			return nil
+		},
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signedIPNRequest("ipn_type=api&merchant=merchantid&txn_id=CP1&status=100&currency1=USD&currency2=BTC&amount1=1.00&amount2=0.01&received_amount=0.01&invoice=inv-1"))
+	if w.Code == http.StatusOK || calls != 0 {
+		t.Fatalf("Should not have dispatched a tampered ipn, got %d with %d calls", w.Code, calls)
+	}
+}
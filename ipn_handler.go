@@ -0,0 +1,237 @@
+package coinpayments
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// IPNDelivery describes a single IPN received by an IPNHandler
+type IPNDelivery struct {
+	IPNID  string `json:"ipn_id"`
+	Type   string `json:"ipn_type"`
+	TxnID  string `json:"txn_id"` // the id of the withdrawal for withdrawal IPNs
+	Status string `json:"status"`
+	Replay bool   `json:"replay"` // true if the IPN was already handled, in which case it wasn't dispatched again
+	Secret string `json:"secret"` // name of the IPN secret the IPN was sent with
+}
+
+// IPNHandler verifies incoming IPNs, parses them by ipn_type and dispatches them to the matching callback.
+// It implements http.Handler, so it can be mounted on the IPN URL directly.
+// IE: http.Handle("/ipn", &coinpayments.IPNHandler{Client: cps, OnAPI: func(resp *coinpayments.IPNAPIResponse) error { ... }})
+type IPNHandler struct {
+	Client *Client
+
+	// Archive, if set, gets a copy of every IPN received by ServeHTTP, exactly as it was delivered and before it's
+	// verified, so it can be replayed later with ReplayIPNs
+	Archive *IPNArchive
+
+	// Idempotency, if set, is used to acknowledge IPNs that were already handled without dispatching them again.
+	// IPNs are keyed on their ipn_id.
+	Idempotency IdempotencyStore
+	// StatusKeys, if set, also keys IPNs on their transaction and status (IPNStatusKey), so the same status reported
+	// under a new ipn_id isn't dispatched again. Later IPNs with the same status but more confirmations or a larger
+	// received amount are then dropped too, so leave it off if your callbacks follow those.
+	StatusKeys bool
+
+	// CrossCheck, if set, checks completed API IPNs against the API and the original request before OnAPI is
+	// called. Inconsistent IPNs fail with an *IPNConsistencyError and are never dispatched.
+	CrossCheck *IPNCrossChecker
+
+	// callbacks by ipn_type. Returning an error makes the handler respond with an error, so CoinPayments retries.
+	// Verified IPNs of a type without a callback are acknowledged and dropped.
+	OnAPI        func(*IPNAPIResponse) error
+	OnDeposit    func(*IPNDepositResponse) error
+	OnButton     func(*IPNButtonResponse) error
+	OnCart       func(*IPNCartResponse) error
+	OnWithdrawal func(*IPNWithdrawalResponse) error
+
+	// OnDelivery, if set, is called for every verified IPN after it was dispatched, or skipped as a replay
+	OnDelivery func(*IPNDelivery)
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *IPNHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.Archive != nil {
+		archived := &ArchivedIPN{ReceivedAt: time.Now(), RemoteAddr: req.RemoteAddr, Header: redactIPNHeader(req.Header, body), Body: string(body)}
+		if err := h.Archive.Append(archived); err != nil {
+			// refuse the IPN rather than lose it, CoinPayments will send it again
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if _, err := h.Handle(req.Header, body); err != nil {
+		status := http.StatusInternalServerError
+		if isIPNVerificationError(err) {
+			status = http.StatusUnauthorized
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Write([]byte("IPN OK"))
+}
+
+// Handle verifies the raw body of an IPN and dispatches it. Use it directly if you aren't serving IPNs with
+// ServeHTTP.
+func (h *IPNHandler) Handle(header http.Header, body []byte) (*IPNDelivery, error) {
+	secret, err := h.Client.VerifyIPNSecret(header, body)
+	if err != nil {
+		return nil, err
+	}
+	return h.handle(secret, body, false)
+}
+
+// handle dispatches an IPN verified with the secret. Forced replays skip the idempotency store, they are meant to be
+// dispatched again.
+func (h *IPNHandler) handle(secret string, body []byte, force bool) (*IPNDelivery, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &IPNDelivery{
+		IPNID:  values.Get("ipn_id"),
+		Type:   values.Get("ipn_type"),
+		TxnID:  values.Get("txn_id"),
+		Status: values.Get("status"),
+		Secret: secret,
+	}
+	if delivery.Type == IPNTypeWithdrawal {
+		delivery.TxnID = values.Get("id")
+	}
+
+	var claimed []string
+	if !force {
+		if claimed, delivery.Replay, err = h.claim(delivery); err != nil {
+			return nil, err
+		}
+	}
+
+	if !delivery.Replay {
+		if err := h.dispatch(delivery.Type, body); err != nil {
+			h.release(claimed)
+			return nil, err
+		}
+	}
+
+	if h.OnDelivery != nil {
+		h.OnDelivery(delivery)
+	}
+	return delivery, nil
+}
+
+// claim claims the idempotency keys of the delivery, returning the keys it claimed and whether the delivery was
+// already handled before.
+func (h *IPNHandler) claim(d *IPNDelivery) ([]string, bool, error) {
+	if h.Idempotency == nil {
+		return nil, false, nil
+	}
+
+	var keys []string
+	if d.IPNID != "" {
+		keys = append(keys, IPNIDKey(d.IPNID))
+	}
+	if h.StatusKeys && d.TxnID != "" {
+		keys = append(keys, IPNStatusKey(d.TxnID, d.Status))
+	}
+
+	var claimed []string
+	replay := false
+	for _, key := range keys {
+		ok, err := h.Idempotency.Claim(key)
+		if err != nil {
+			h.release(claimed)
+			return nil, false, err
+		}
+		if ok {
+			claimed = append(claimed, key)
+		} else {
+			replay = true
+		}
+	}
+	return claimed, replay, nil
+}
+
+// release gives up the claimed keys so the IPN will be dispatched again when CoinPayments retries it
+func (h *IPNHandler) release(keys []string) {
+	for _, key := range keys {
+		h.Idempotency.Release(key)
+	}
+}
+
+// dispatch parses the body by ipn_type and hands it to the matching callback. Known types without a callback are
+// acknowledged as they are, so CoinPayments doesn't retry them forever. Unknown types fail with ErrUnexpectedIPNType.
+func (h *IPNHandler) dispatch(ipnType string, body []byte) error {
+	switch ipnType {
+	case IPNTypeAPI:
+		if h.OnAPI == nil {
+			return nil
+		}
+		resp, err := h.Client.HandleIPNAPI(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if h.CrossCheck != nil && ipnComplete(resp.Status) {
+			if err := h.CrossCheck.Check(resp); err != nil {
+				return err
+			}
+		}
+		return h.OnAPI(resp)
+	case IPNTypeDeposit:
+		if h.OnDeposit == nil {
+			return nil
+		}
+		resp, err := h.Client.HandleIPNDeposit(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		return h.OnDeposit(resp)
+	case IPNTypeSimple, IPNTypeButton, IPNTypeDonation:
+		if h.OnButton == nil {
+			return nil
+		}
+		resp, err := h.Client.HandleIPNButton(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		return h.OnButton(resp)
+	case IPNTypeCart:
+		if h.OnCart == nil {
+			return nil
+		}
+		resp, err := h.Client.HandleIPNCart(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		return h.OnCart(resp)
+	case IPNTypeWithdrawal:
+		if h.OnWithdrawal == nil {
+			return nil
+		}
+		resp, err := h.Client.HandleIPNWithdrawal(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		return h.OnWithdrawal(resp)
+	}
+	return ErrUnexpectedIPNType
+}
+
+// isIPNVerificationError returns whether the error came from verifying the IPN, rather than from handling it
+func isIPNVerificationError(err error) bool {
+	switch err {
+	case ErrMissingIPNSecret, ErrMissingIPNSignature, ErrInvalidIPNSignature, ErrIPNMerchantMismatch, ErrIPNModeNotAllowed, ErrInvalidIPNAuth, ErrIPNSecretNotActive:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package coinpayments_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+// signedIPNRequest builds an IPN request signed with the ipn secret of offlineClient
+func signedIPNRequest(body string) *http.Request {
+	req := httptest.NewRequest("POST", "/ipn", strings.NewReader(body))
+	req.Header.Set("HMAC", signIPN("ipnsecret", []byte(body)))
+	return req
+}
+
+func TestIPNHandler(t *testing.T) {
+	var calls int
+	var deliveries []*coinpayments.IPNDelivery
+	fail := false
+	handler := &coinpayments.IPNHandler{
+		Client:      offlineClient(t),
+		Idempotency: coinpayments.NewMemoryIdempotencyStore(100, 0),
+		OnAPI: func(resp *coinpayments.IPNAPIResponse) error {
+			if fail {
+				return errors.New("database is down")
+			}
+			calls++
+			return nil
+		},
+		OnDelivery: func(d *coinpayments.IPNDelivery) { deliveries = append(deliveries, d) },
+	}
+
+	body := "ipn_type=api&ipn_id=1&merchant=merchantid&txn_id=CP1&status=100"
+
+	fail = true
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signedIPNRequest(body))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Should have failed the delivery so it's retried, got %d", w.Code)
+	}
+
+	fail = false
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, signedIPNRequest(body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Should have acknowledged the ipn, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// the same status under a new ipn_id is dispatched, it may carry more confirmations
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signedIPNRequest(strings.Replace(body, "ipn_id=1", "ipn_id=2", 1)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Should have acknowledged the ipn, got %d", w.Code)
+	}
+
+	// unless the handler keys on statuses too
+	handler.StatusKeys = true
+	for _, id := range []string{"ipn_id=3", "ipn_id=4"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, signedIPNRequest(strings.Replace(body, "ipn_id=1", id, 1)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Should have acknowledged the ipn, got %d", w.Code)
+		}
+	}
+
+	if calls != 3 {
+		t.Fatalf("Should have dispatched the ipn three times, got %d", calls)
+	}
+	if len(deliveries) != 5 || deliveries[0].Replay || !deliveries[1].Replay || deliveries[2].Replay || deliveries[3].Replay || !deliveries[4].Replay {
+		t.Fatalf("Reported the wrong deliveries: %+v", deliveries)
+	}
+
+	// types without a callback are acknowledged, unknown types aren't
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signedIPNRequest("ipn_type=deposit&ipn_id=5&merchant=merchantid&txn_id=CP2&status=100"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Should have acknowledged a deposit ipn without a callback, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signedIPNRequest("ipn_type=mystery&ipn_id=6&merchant=merchantid&txn_id=CP3&status=100"))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Should have failed an ipn of an unknown type, got %d", w.Code)
+	}
+
+	req := signedIPNRequest(body)
+	req.Header.Set("HMAC", "forged")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Should have rejected a forged ipn, got %d", w.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package coinpayments
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrIPNSecretNotActive is returned when an IPN was sent with one of our IPN secrets outside of its validity window
+var ErrIPNSecretNotActive = errors.New("ipn was sent with an ipn secret that is not active")
+
+// defaultIPNSecretName is the name reported for the IPNSecret of the config
+const defaultIPNSecretName = "default"
+
+// IPNSecret is one of the IPN secrets we accept. Listing more than one lets the secret be rotated in the
+// CoinPayments dashboard without rejecting retries of IPNs that were signed with the old one.
+type IPNSecret struct {
+	Name      string    `mapstructure:"name" json:"name"` // reported when the secret matches, ie: "2019-q1"
+	Secret    string    `mapstructure:"secret" json:"secret"`
+	NotBefore time.Time `mapstructure:"not_before" json:"not_before"` // zero means no start
+	NotAfter  time.Time `mapstructure:"not_after" json:"not_after"`   // zero means no end
+	// Retired marks a secret that has been replaced in the dashboard. It's still accepted until NotAfter, but every
+	// IPN sent with it is reported to OnRetiredIPNSecret.
+	Retired bool `mapstructure:"retired" json:"retired"`
+}
+
+// active returns whether the secret is within its validity window at t
+func (s *IPNSecret) active(t time.Time) bool {
+	return (s.NotBefore.IsZero() || !t.Before(s.NotBefore)) && (s.NotAfter.IsZero() || t.Before(s.NotAfter))
+}
+
+// ipnSecrets returns every secret we know of, starting with the IPNSecret of the config if there is one
+func (c *Client) ipnSecrets() []IPNSecret {
+	secrets := make([]IPNSecret, 0, len(c.IPNSecrets)+1)
+	if c.IPNSecret != "" {
+		secrets = append(secrets, IPNSecret{Name: defaultIPNSecretName, Secret: c.IPNSecret})
+	}
+	return append(secrets, c.IPNSecrets...)
+}
+
+// matchIPNSecret returns the first of our secrets that matches, or fails with noMatch. Secrets outside their
+// validity window at the time never match, but are still reported for live IPNs so a sender stuck on an old secret
+// gets noticed.
+func (c *Client) matchIPNSecret(matches func(secret string) bool, noMatch error, at time.Time, live bool) (*IPNSecret, error) {
+	var inactive *IPNSecret
+	for _, s := range c.ipnSecrets() {
+		s := s
+		if !matches(s.Secret) {
+			continue
+		}
+		if !s.active(at) {
+			inactive = &s
+			continue
+		}
+
+		if live {
+			c.recordIPNSecretUse(&s)
+		}
+		return &s, nil
+	}
+
+	if inactive != nil {
+		if live {
+			c.recordIPNSecretUse(inactive)
+		}
+		return nil, ErrIPNSecretNotActive
+	}
+	return nil, noMatch
+}
+
+// recordIPNSecretUse counts an IPN sent with the secret, and warns about it if the secret is on its way out
+func (c *Client) recordIPNSecretUse(s *IPNSecret) {
+	c.ipnSecretMu.Lock()
+	if c.ipnSecretUses == nil {
+		c.ipnSecretUses = map[string]int{}
+	}
+	c.ipnSecretUses[s.Name]++
+	count := c.ipnSecretUses[s.Name]
+	c.ipnSecretMu.Unlock()
+
+	if (s.Retired || !s.active(time.Now())) && c.OnRetiredIPNSecret != nil {
+		c.OnRetiredIPNSecret(s.Name, count)
+	}
+}
+
+// IPNSecretUses returns how many IPNs were sent with each of our IPN secrets, by name
+func (c *Client) IPNSecretUses() map[string]int {
+	c.ipnSecretMu.Lock()
+	defer c.ipnSecretMu.Unlock()
+
+	uses := make(map[string]int, len(c.ipnSecretUses))
+	for name, count := range c.ipnSecretUses {
+		uses[name] = count
+	}
+	return uses
+}
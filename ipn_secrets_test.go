@@ -0,0 +1,57 @@
+package coinpayments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestIPNSecretRotation(t *testing.T) {
+	now := time.Now()
+	client, err := coinpayments.NewClient(&coinpayments.Config{
+		PublicKey:  "publickey",
+		PrivateKey: "privatekey",
+		IPNSecrets: []coinpayments.IPNSecret{
+			{Name: "old", Secret: "oldsecret", NotAfter: now.Add(time.Hour), Retired: true},
+			{Name: "new", Secret: "newsecret", NotBefore: now.Add(-time.Hour)},
+			{Name: "ancient", Secret: "ancientsecret", NotAfter: now.Add(-time.Hour)},
+		},
+	}, &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings []string
+	client.OnRetiredIPNSecret = func(name string, count int) { warnings = append(warnings, name) }
+
+	body := []byte("ipn_type=api&txn_id=CP1&status=100")
+	verify := func(secret string) (string, error) {
+		header := http.Header{}
+		header.Set("HMAC", signIPN(secret, body))
+		return client.VerifyIPNSecret(header, body)
+	}
+
+	if name, err := verify("newsecret"); err != nil || name != "new" {
+		t.Fatalf("Should have matched the new secret, got %q, %v", name, err)
+	}
+	if name, err := verify("oldsecret"); err != nil || name != "old" {
+		t.Fatalf("Should have still accepted the retired secret, got %q, %v", name, err)
+	}
+	if _, err := verify("ancientsecret"); err != coinpayments.ErrIPNSecretNotActive {
+		t.Fatalf("Should have rejected the expired secret, got %v", err)
+	}
+	if _, err := verify("unknown"); err != coinpayments.ErrInvalidIPNSignature {
+		t.Fatalf("Should have rejected an unknown secret, got %v", err)
+	}
+
+	if len(warnings) != 2 || warnings[0] != "old" || warnings[1] != "ancient" {
+		t.Fatalf("Should have warned about the retired and expired secrets, got %v", warnings)
+	}
+
+	uses := client.IPNSecretUses()
+	if uses["new"] != 1 || uses["old"] != 1 || uses["ancient"] != 1 {
+		t.Fatalf("Counted the wrong uses: %v", uses)
+	}
+}
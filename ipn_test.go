@@ -1,7 +1,15 @@
 package coinpayments_test
 
 import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
 	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
 )
 
 func TestHandleIPNDeposit(t *testing.T) {
@@ -9,3 +17,113 @@ func TestHandleIPNDeposit(t *testing.T) {
 
 func TestHandleIPNAPI(t *testing.T) {
 }
+
+func TestHandleIPNButton(t *testing.T) {
+	client := offlineClient(t)
+
+	resp, err := client.HandleIPNButton(strings.NewReader("ipn_version=1.0&ipn_id=abc&ipn_type=button&merchant=merchantid&txn_id=CP123&status=100&item_name=T-Shirt&quantity=2&amount1=40&currency1=USD"))
+	if err != nil {
+		t.Fatalf("Should have parsed the button ipn, but it threw error: %s", err.Error())
+	}
+	if resp.IPNType != coinpayments.IPNTypeButton || resp.TxnID != "CP123" || resp.Quantity != "2" {
+		t.Fatalf("Parsed the wrong values out of the button ipn: %+v", resp)
+	}
+
+	if _, err := client.HandleIPNButton(strings.NewReader("ipn_type=deposit")); err != coinpayments.ErrUnexpectedIPNType {
+		t.Fatalf("Should have rejected a deposit ipn, got %v", err)
+	}
+}
+
+func TestHandleIPNCart(t *testing.T) {
+	client := offlineClient(t)
+
+	body := "ipn_type=cart&txn_id=CP456&status=100&subtotal=50&tax=2.50&shipping=5" +
+		"&item_name_1=T-Shirt&item_amount_1=20&item_quantity_1=2" +
+		"&item_name_2=Sticker&item_amount_2=10&quantity_2=1" +
+		"&first_name=Sam&address1=1+Main+St&city=Springfield&country=US"
+	resp, err := client.HandleIPNCart(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Should have parsed the cart ipn, but it threw error: %s", err.Error())
+	}
+
+	if len(resp.Items) != 2 {
+		t.Fatalf("Expected 2 line items, got %d", len(resp.Items))
+	}
+	if resp.Items[0].Name != "T-Shirt" || resp.Items[0].Quantity != "2" || resp.Items[1].Amount != "10" || resp.Items[1].Quantity != "1" {
+		t.Fatalf("Parsed the wrong line items out of the cart ipn: %+v", resp.Items)
+	}
+	if resp.Subtotal != "50" || resp.Tax != "2.50" || resp.Shipping != "5" {
+		t.Fatalf("Parsed the wrong amounts out of the cart ipn: %+v", resp)
+	}
+	if resp.ShippingAddress.City != "Springfield" || resp.ShippingAddress.Address1 != "1 Main St" {
+		t.Fatalf("Parsed the wrong shipping address out of the cart ipn: %+v", resp.ShippingAddress)
+	}
+
+	if _, err := client.HandleIPNButton(strings.NewReader(body)); err != coinpayments.ErrUnexpectedIPNType {
+		t.Fatalf("Should have sent cart ipns to HandleIPNCart, got %v", err)
+	}
+}
+
+func TestVerifyIPN(t *testing.T) {
+	client := offlineClient(t)
+
+	body := []byte("ipn_type=api&merchant=merchantid&txn_id=CP123&status=100")
+	header := http.Header{}
+	header.Set("HMAC", signIPN("ipnsecret", body))
+
+	if err := client.VerifyIPN(header, body); err != nil {
+		t.Fatalf("Should have verified a correctly signed ipn, but it threw error: %s", err.Error())
+	}
+
+	if err := client.VerifyIPN(header, []byte("ipn_type=api&merchant=merchantid&txn_id=CP123&status=-1")); err != coinpayments.ErrInvalidIPNSignature {
+		t.Fatalf("Should have rejected a tampered body, got %v", err)
+	}
+
+	if err := client.VerifyIPN(http.Header{}, body); err != coinpayments.ErrMissingIPNSignature {
+		t.Fatalf("Should have rejected an ipn without a signature, got %v", err)
+	}
+
+	other := []byte("ipn_type=api&merchant=someoneelse")
+	header.Set("HMAC", signIPN("ipnsecret", other))
+	if err := client.VerifyIPN(header, other); err != coinpayments.ErrIPNMerchantMismatch {
+		t.Fatalf("Should have rejected an ipn for another merchant, got %v", err)
+	}
+}
+
+// signIPN signs an ipn body the same way CoinPayments does
+func signIPN(secret string, body []byte) string {
+	hash := hmac.New(sha512.New, []byte(secret))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func TestVerifyIPNHTTPAuth(t *testing.T) {
+	body := []byte("ipn_mode=httpauth&ipn_type=api&merchant=merchantid&txn_id=CP123&status=100")
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("merchantid:ipnsecret")))
+
+	// clients are hmac only unless configured otherwise
+	if err := offlineClient(t).VerifyIPN(header, body); err != coinpayments.ErrIPNModeNotAllowed {
+		t.Fatalf("Should have rejected an httpauth ipn on an hmac only client, got %v", err)
+	}
+
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey", MerchantID: "merchantid", IPNSecret: "ipnsecret", IPNMode: coinpayments.IPNModeAny}, &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.VerifyIPN(header, body); err != nil {
+		t.Fatalf("Should have verified the httpauth ipn, but it threw error: %s", err.Error())
+	}
+
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("merchantid:wrong")))
+	if err := client.VerifyIPN(header, body); err != coinpayments.ErrInvalidIPNAuth {
+		t.Fatalf("Should have rejected the wrong password, got %v", err)
+	}
+
+	hmacBody := []byte("ipn_mode=hmac&ipn_type=api&merchant=merchantid")
+	header = http.Header{}
+	header.Set("HMAC", signIPN("ipnsecret", hmacBody))
+	if err := client.VerifyIPN(header, hmacBody); err != nil {
+		t.Fatalf("Should have still verified hmac ipns, but it threw error: %s", err.Error())
+	}
+}
@@ -0,0 +1,179 @@
+package coinpayments
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Withdrawal statuses sent in the status field of a withdrawal IPN
+const (
+	WithdrawalStatusCancelled     = "-1"
+	WithdrawalStatusAwaitingEmail = "0"
+	WithdrawalStatusPending       = "1"
+	WithdrawalStatusComplete      = "2"
+)
+
+// ErrUnknownWithdrawal is returned when a withdrawal IPN comes in for a withdrawal we never tracked
+var ErrUnknownWithdrawal = errors.New("withdrawal is not being tracked")
+
+// IPNWithdrawalResponse is the response we expect back from the server when the ipn_type is "withdrawal"
+type IPNWithdrawalResponse struct {
+	IPNHeader
+	ID         string `json:"id"` // matches the ID of the WithdrawalResult returned when the withdrawal was created
+	Status     string `json:"status"`
+	StatusText string `json:"status_text"`
+	Address    string `json:"address"`
+	TxnID      string `json:"txn_id"` // only present once the withdrawal has been sent
+	Currency   string `json:"currency"`
+	Amount     string `json:"amount"`
+	AmountI    string `json:"amounti"` // amount in satoshis
+}
+
+// Complete returns whether the withdrawal has been sent out
+func (resp *IPNWithdrawalResponse) Complete() bool {
+	return resp.Status == WithdrawalStatusComplete
+}
+
+// HandleIPNWithdrawal handles the IPN sent when a withdrawal is processed. Verify the body with VerifyIPN first.
+// IE: cps.HandleIPNWithdrawal(bytes.NewReader(body))
+func (c *Client) HandleIPNWithdrawal(reader io.Reader) (*IPNWithdrawalResponse, error) {
+
+	values, err := readIPN(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if values.Get("ipn_type") != IPNTypeWithdrawal {
+		return nil, ErrUnexpectedIPNType
+	}
+
+	return &IPNWithdrawalResponse{
+		IPNHeader:  ipnHeader(values),
+		ID:         values.Get("id"),
+		Status:     values.Get("status"),
+		StatusText: values.Get("status_text"),
+		Address:    values.Get("address"),
+		TxnID:      values.Get("txn_id"),
+		Currency:   values.Get("currency"),
+		Amount:     values.Get("amount"),
+		AmountI:    values.Get("amounti"),
+	}, nil
+}
+
+// WithdrawalRecord links a withdrawal we created to our own reference for it, and holds the latest state we got
+// from its IPNs.
+type WithdrawalRecord struct {
+	ID        string            `json:"id"`
+	Reference string            `json:"reference"` // our own id for the payout, ie: a supplier invoice
+	Request   WithdrawalRequest `json:"request"`
+	Status    string            `json:"status"`
+	TxnID     string            `json:"txn_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// WithdrawalStore persists withdrawal records. Withdrawal returns ErrUnknownWithdrawal if there is no record for the id.
+type WithdrawalStore interface {
+	SaveWithdrawal(rec *WithdrawalRecord) error
+	Withdrawal(id string) (*WithdrawalRecord, error)
+}
+
+// MemoryWithdrawalStore is a WithdrawalStore that keeps everything in memory
+type MemoryWithdrawalStore struct {
+	mu      sync.RWMutex
+	records map[string]WithdrawalRecord
+}
+
+// NewMemoryWithdrawalStore returns an empty MemoryWithdrawalStore
+func NewMemoryWithdrawalStore() *MemoryWithdrawalStore {
+	return &MemoryWithdrawalStore{records: map[string]WithdrawalRecord{}}
+}
+
+// SaveWithdrawal implements the WithdrawalStore interface
+func (s *MemoryWithdrawalStore) SaveWithdrawal(rec *WithdrawalRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[rec.ID] = *rec
+	return nil
+}
+
+// Withdrawal implements the WithdrawalStore interface
+func (s *MemoryWithdrawalStore) Withdrawal(id string) (*WithdrawalRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.records[id]
+	if !ok {
+		return nil, ErrUnknownWithdrawal
+	}
+	return &rec, nil
+}
+
+// WithdrawalTracker correlates withdrawal IPNs with the withdrawals and transfers we created.
+type WithdrawalTracker struct {
+	store WithdrawalStore
+	now   func() time.Time
+}
+
+// NewWithdrawalTracker returns a WithdrawalTracker backed by the given store
+func NewWithdrawalTracker(store WithdrawalStore) *WithdrawalTracker {
+	return &WithdrawalTracker{store: store, now: time.Now}
+}
+
+// Track records a withdrawal returned by CallCreateWithdrawal or CallCreateTransfer under our own reference.
+func (t *WithdrawalTracker) Track(reference string, req *WithdrawalRequest, res *WithdrawalResult) (*WithdrawalRecord, error) {
+	if res == nil || res.ID == "" {
+		return nil, errors.New("withdrawal result has no id to track")
+	}
+
+	now := t.now()
+	rec := &WithdrawalRecord{
+		ID:        res.ID,
+		Reference: reference,
+		Request:   *req,
+		Status:    createdWithdrawalStatus(res.Status),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := t.store.SaveWithdrawal(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Resolve finds the record for a withdrawal IPN, updates it with the status and txn id of the IPN, and returns it.
+// A complete or cancelled withdrawal is never moved back to a status that isn't, in case IPNs arrive out of order.
+func (t *WithdrawalTracker) Resolve(resp *IPNWithdrawalResponse) (*WithdrawalRecord, error) {
+	rec, err := t.store.Withdrawal(resp.ID)
+	if err != nil {
+		return nil, err
+	}
+	if withdrawalFinal(rec.Status) && !withdrawalFinal(resp.Status) {
+		return rec, nil
+	}
+
+	rec.Status = resp.Status
+	if resp.TxnID != "" {
+		rec.TxnID = resp.TxnID
+	}
+	rec.UpdatedAt = t.now()
+	if err := t.store.SaveWithdrawal(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// withdrawalFinal returns whether a withdrawal status won't change anymore
+func withdrawalFinal(status string) bool {
+	return status == WithdrawalStatusComplete || status == WithdrawalStatusCancelled
+}
+
+// createdWithdrawalStatus maps the status of a freshly created withdrawal to the status the IPNs will use. The create calls
+// return 0 when the withdrawal is waiting on email confirmation and 1 when it went straight through.
+func createdWithdrawalStatus(status int) string {
+	if status == 1 {
+		return WithdrawalStatusPending
+	}
+	return WithdrawalStatusAwaitingEmail
+}
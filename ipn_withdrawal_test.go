@@ -0,0 +1,54 @@
+package coinpayments_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestHandleIPNWithdrawal(t *testing.T) {
+	client := offlineClient(t)
+
+	resp, err := client.HandleIPNWithdrawal(strings.NewReader("ipn_type=withdrawal&id=CWAB123&status=2&address=1BoatSLRHtKNngkdXEeobR76b53LETtpyT&txn_id=abcdef&currency=BTC&amount=0.5&amounti=50000000"))
+	if err != nil {
+		t.Fatalf("Should have parsed the withdrawal ipn, but it threw error: %s", err.Error())
+	}
+	if resp.ID != "CWAB123" || !resp.Complete() || resp.AmountI != "50000000" {
+		t.Fatalf("Parsed the wrong values out of the withdrawal ipn: %+v", resp)
+	}
+
+	if _, err := client.HandleIPNWithdrawal(strings.NewReader("ipn_type=api")); err != coinpayments.ErrUnexpectedIPNType {
+		t.Fatalf("Should have rejected an api ipn, got %v", err)
+	}
+}
+
+func TestWithdrawalTracker(t *testing.T) {
+	tracker := coinpayments.NewWithdrawalTracker(coinpayments.NewMemoryWithdrawalStore())
+
+	req := &coinpayments.WithdrawalRequest{Amount: "0.5", Currency: "BTC", Address: "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"}
+	if _, err := tracker.Track("supplier-invoice-7", req, &coinpayments.WithdrawalResult{ID: "CWAB123", Status: 1}); err != nil {
+		t.Fatalf("Should have tracked the withdrawal, but it threw error: %s", err.Error())
+	}
+
+	rec, err := tracker.Resolve(&coinpayments.IPNWithdrawalResponse{ID: "CWAB123", Status: coinpayments.WithdrawalStatusComplete, TxnID: "abcdef"})
+	if err != nil {
+		t.Fatalf("Should have resolved the withdrawal ipn, but it threw error: %s", err.Error())
+	}
+	if rec.Reference != "supplier-invoice-7" || rec.Status != coinpayments.WithdrawalStatusComplete || rec.TxnID != "abcdef" {
+		t.Fatalf("Resolved the wrong record: %+v", rec)
+	}
+
+	// a late pending IPN doesn't move a complete withdrawal back
+	rec, err = tracker.Resolve(&coinpayments.IPNWithdrawalResponse{ID: "CWAB123", Status: coinpayments.WithdrawalStatusPending})
+	if err != nil {
+		t.Fatalf("Should have resolved the late withdrawal ipn, but it threw error: %s", err.Error())
+	}
+	if rec.Status != coinpayments.WithdrawalStatusComplete || rec.TxnID != "abcdef" {
+		t.Fatalf("Should have kept the withdrawal complete, got %+v", rec)
+	}
+
+	if _, err := tracker.Resolve(&coinpayments.IPNWithdrawalResponse{ID: "nope"}); err != coinpayments.ErrUnknownWithdrawal {
+		t.Fatalf("Should have failed on an untracked withdrawal, got %v", err)
+	}
+}
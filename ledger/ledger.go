@@ -0,0 +1,266 @@
+// Package ledger is a double-entry ledger for crediting customers from CoinPayments deposit and API IPNs.
+//
+// Every IPN for the same txn_id maps to the same entry id, so a txn_id is only ever credited once no matter how
+// many times CoinPayments delivers it. Amounts are kept in satoshis (1e-8 of a coin).
+package ledger
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+// Errors returned by the ledger
+var (
+	ErrDuplicateEntry  = errors.New("ledger entry already exists")
+	ErrEntryNotFound   = errors.New("ledger entry not found")
+	ErrNotConfirmed    = errors.New("payment has not reached the required confirmations")
+	ErrUnbalanced      = errors.New("ledger entry postings do not balance")
+	ErrInvalidCustomer = errors.New("customer id can't be empty or contain a colon")
+)
+
+// Entry kinds
+const (
+	KindDeposit  = "deposit"
+	KindPayment  = "payment"
+	KindReversal = "reversal"
+)
+
+// Posting moves an amount in or out of an account. Debits are positive and credits are negative, so the postings
+// of an entry always sum to zero.
+type Posting struct {
+	Account string `json:"account"`
+	Amount  int64  `json:"amount"` // satoshis
+}
+
+// Entry is a single balanced transaction in the ledger.
+type Entry struct {
+	ID         string    `json:"id"` // kind:txn_id, which is what makes crediting exactly-once
+	Kind       string    `json:"kind"`
+	TxnID      string    `json:"txn_id"`
+	CustomerID string    `json:"customer_id"`
+	Currency   string    `json:"currency"`
+	Amount     int64     `json:"amount"` // gross amount credited to the customer
+	Fee        int64     `json:"fee"`    // fee kept by CoinPayments
+	Reverses   string    `json:"reverses,omitempty"`
+	Memo       string    `json:"memo,omitempty"`
+	Postings   []Posting `json:"postings"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Store persists ledger entries.
+type Store interface {
+	// Append stores the entry, or returns ErrDuplicateEntry if an entry with the same id already exists.
+	// The check and insert must be atomic, it's what guarantees a payment is only credited once.
+	Append(e *Entry) error
+	// Entry returns the entry with the id, or ErrEntryNotFound
+	Entry(id string) (*Entry, error)
+	// Balance returns the sum of every posting to the account
+	Balance(account string) (int64, error)
+	// Accounts returns every account that starts with prefix
+	Accounts(prefix string) ([]string, error)
+}
+
+// Ledger credits customers from IPNs.
+type Ledger struct {
+	store Store
+	now   func() time.Time
+
+	// Confirms is the number of confirmations needed per currency, whatever its case, before a payment is credited.
+	// Currencies missing from the map are credited once CoinPayments marks the payment complete (status >= 100).
+	Confirms map[string]int
+}
+
+// New returns a Ledger backed by the store
+func New(store Store) *Ledger {
+	return &Ledger{store: store, now: time.Now, Confirms: map[string]int{}}
+}
+
+// CustomerAccount is the account holding what we owe a customer in a currency. Customer ids can't contain a colon, it
+// separates the parts of the account.
+func CustomerAccount(customerID, currency string) string {
+	return "customer:" + customerID + ":" + strings.ToUpper(currency)
+}
+
+// WalletAccount is the account holding what CoinPayments holds for us in a currency
+func WalletAccount(currency string) string {
+	return "wallet:" + strings.ToUpper(currency)
+}
+
+// FeeAccount is the account holding the fees CoinPayments charged us in a currency
+func FeeAccount(currency string) string {
+	return "fees:" + strings.ToUpper(currency)
+}
+
+// EntryID returns the id of the entry of the given kind for a txn_id
+func EntryID(kind, txnID string) string {
+	return kind + ":" + txnID
+}
+
+// CreditDeposit credits a customer for a deposit IPN. It returns ErrNotConfirmed if the deposit doesn't have enough
+// confirmations yet, and ErrDuplicateEntry if the txn_id was already credited.
+func (l *Ledger) CreditDeposit(customerID string, ipn *coinpayments.IPNDepositResponse) (*Entry, error) {
+	if !l.confirmed(ipn.Currency, ipn.Status, ipn.Confirms) {
+		return nil, ErrNotConfirmed
+	}
+
+	amount, err := satoshis(ipn.AmountI, ipn.Amount)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := satoshis(ipn.FeeI, ipn.Fee)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.credit(KindDeposit, customerID, ipn.TxnID, ipn.Currency, amount, fee)
+}
+
+// CreditPayment credits a customer for an API IPN, in currency2. It returns ErrNotConfirmed if the payment doesn't
+// have enough confirmations yet, and ErrDuplicateEntry if the txn_id was already credited.
+func (l *Ledger) CreditPayment(customerID string, ipn *coinpayments.IPNAPIResponse) (*Entry, error) {
+	if !l.confirmed(ipn.Currency2, ipn.Status, ipn.ReceivedConfirms) {
+		return nil, ErrNotConfirmed
+	}
+
+	amount, err := satoshis("", ipn.Amount2)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := satoshis("", ipn.Fee)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.credit(KindPayment, customerID, ipn.TxnID, ipn.Currency2, amount, fee)
+}
+
+// Reverse books a reversal of the entry of the given kind for a txn_id, ie: after a chargeback or a double spend.
+// Like credits, a txn_id can only be reversed once.
+func (l *Ledger) Reverse(kind, txnID, memo string) (*Entry, error) {
+	original, err := l.store.Entry(EntryID(kind, txnID))
+	if err != nil {
+		return nil, err
+	}
+
+	postings := make([]Posting, len(original.Postings))
+	for i, p := range original.Postings {
+		postings[i] = Posting{Account: p.Account, Amount: -p.Amount}
+	}
+
+	e := &Entry{
+		ID:         EntryID(KindReversal, original.ID),
+		Kind:       KindReversal,
+		TxnID:      txnID,
+		CustomerID: original.CustomerID,
+		Currency:   original.Currency,
+		Amount:     -original.Amount,
+		Fee:        -original.Fee,
+		Reverses:   original.ID,
+		Memo:       memo,
+		Postings:   postings,
+		CreatedAt:  l.now(),
+	}
+	if err := l.store.Append(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Balance returns what we owe the customer in the currency, in satoshis
+func (l *Ledger) Balance(customerID, currency string) (int64, error) {
+	if !validCustomer(customerID) {
+		return 0, ErrInvalidCustomer
+	}
+	// customer accounts are credited, so flip the sign to get a positive balance
+	balance, err := l.store.Balance(CustomerAccount(customerID, currency))
+	return -balance, err
+}
+
+// Balances returns what we owe the customer in every currency they have an account for, in satoshis
+func (l *Ledger) Balances(customerID string) (map[string]int64, error) {
+	if !validCustomer(customerID) {
+		return nil, ErrInvalidCustomer
+	}
+	prefix := CustomerAccount(customerID, "")
+	accounts, err := l.store.Accounts(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]int64, len(accounts))
+	for _, account := range accounts {
+		balance, err := l.store.Balance(account)
+		if err != nil {
+			return nil, err
+		}
+		balances[strings.TrimPrefix(account, prefix)] = -balance
+	}
+	return balances, nil
+}
+
+// credit books a gross amount to the customer, with the net amount going into our wallet and the fee into fees
+func (l *Ledger) credit(kind, customerID, txnID, currency string, amount, fee int64) (*Entry, error) {
+	if txnID == "" {
+		return nil, errors.New("ipn has no txn_id to credit")
+	}
+	if !validCustomer(customerID) {
+		return nil, ErrInvalidCustomer
+	}
+
+	e := &Entry{
+		ID:         EntryID(kind, txnID),
+		Kind:       kind,
+		TxnID:      txnID,
+		CustomerID: customerID,
+		Currency:   strings.ToUpper(currency),
+		Amount:     amount,
+		Fee:        fee,
+		Postings: []Posting{
+			{Account: WalletAccount(currency), Amount: amount - fee},
+			{Account: FeeAccount(currency), Amount: fee},
+			{Account: CustomerAccount(customerID, currency), Amount: -amount},
+		},
+		CreatedAt: l.now(),
+	}
+	if err := l.store.Append(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// confirmed returns whether a payment has enough confirmations to be credited
+func (l *Ledger) confirmed(currency, status, confirms string) bool {
+	s, err := strconv.Atoi(status)
+	if err != nil || s < 0 {
+		return false
+	}
+
+	for k, needed := range l.Confirms {
+		if strings.EqualFold(k, currency) {
+			c, err := strconv.Atoi(confirms)
+			return err == nil && c >= needed
+		}
+	}
+	return s >= 100
+}
+
+// validCustomer returns whether the customer id can be used in an account, without running into another customer's
+// accounts, ie: "alice" and "alice:x"
+func validCustomer(customerID string) bool {
+	return customerID != "" && !strings.Contains(customerID, ":")
+}
+
+// satoshis returns the satoshi amount if the IPN sent one, and parses the decimal amount otherwise
+func satoshis(amountI, amount string) (int64, error) {
+	if amountI != "" {
+		return strconv.ParseInt(amountI, 10, 64)
+	}
+	if amount == "" {
+		return 0, nil
+	}
+	return coinpayments.ParseSatoshis(amount)
+}
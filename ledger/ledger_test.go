@@ -0,0 +1,113 @@
+package ledger_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+	"github.com/jeffwalsh/go-coinpayments/ledger"
+)
+
+func TestCreditDeposit(t *testing.T) {
+	l := ledger.New(ledger.NewMemoryStore())
+	l.Confirms["BTC"] = 3
+
+	ipn := &coinpayments.IPNDepositResponse{TxnID: "tx1", Currency: "BTC", Status: "0", Confirms: "1", Amount: "0.5", AmountI: "50000000", Fee: "0.0025", FeeI: "250000"}
+	if _, err := l.CreditDeposit("customer-1", ipn); err != ledger.ErrNotConfirmed {
+		t.Fatalf("Should not have credited a deposit with 1 of 3 confirmations, got %v", err)
+	}
+
+	ipn.Confirms = "3"
+	entry, err := l.CreditDeposit("customer-1", ipn)
+	if err != nil {
+		t.Fatalf("Should have credited the deposit, but it threw error: %s", err.Error())
+	}
+	if entry.Amount != 50000000 || entry.Fee != 250000 {
+		t.Fatalf("Credited the wrong amounts: %+v", entry)
+	}
+
+	// CoinPayments sends another IPN once the deposit is complete, which must not credit again
+	ipn.Status, ipn.Confirms = "100", "6"
+	if _, err := l.CreditDeposit("customer-1", ipn); err != ledger.ErrDuplicateEntry {
+		t.Fatalf("Should not have credited the same txn_id twice, got %v", err)
+	}
+
+	balance, err := l.Balance("customer-1", "btc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 50000000 {
+		t.Fatalf("Expected a balance of 50000000, got %d", balance)
+	}
+}
+
+func TestCreditPayment(t *testing.T) {
+	l := ledger.New(ledger.NewMemoryStore())
+
+	ipn := &coinpayments.IPNAPIResponse{TxnID: "tx2", Currency2: "LTC", Status: "1", Amount2: "2.5", Fee: "0.0125"}
+	if _, err := l.CreditPayment("customer-2", ipn); err != ledger.ErrNotConfirmed {
+		t.Fatalf("Should not have credited a pending payment without a configured threshold, got %v", err)
+	}
+
+	ipn.Status = "100"
+	if _, err := l.CreditPayment("customer-2", ipn); err != nil {
+		t.Fatalf("Should have credited the payment, but it threw error: %s", err.Error())
+	}
+
+	balances, err := l.Balances("customer-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances["LTC"] != 250000000 {
+		t.Fatalf("Expected an LTC balance of 250000000, got %v", balances)
+	}
+
+	// a colon would let the customer reach into the accounts of customer-2
+	ipn.TxnID = "tx3"
+	if _, err := l.CreditPayment("customer-2:x", ipn); err != ledger.ErrInvalidCustomer {
+		t.Fatalf("Should have refused a customer id with a colon, got %v", err)
+	}
+	if _, err := l.Balances("customer-2:x"); err != ledger.ErrInvalidCustomer {
+		t.Fatalf("Should have refused a customer id with a colon, got %v", err)
+	}
+}
+
+func TestConfirmsCase(t *testing.T) {
+	l := ledger.New(ledger.NewMemoryStore())
+	l.Confirms["btc"] = 2
+
+	ipn := &coinpayments.IPNDepositResponse{TxnID: "tx1", Currency: "BTC", Status: "0", Confirms: "2", Amount: "0.5"}
+	if _, err := l.CreditDeposit("customer-1", ipn); err != nil {
+		t.Fatalf("Should have used the lower case threshold, but it threw error: %s", err.Error())
+	}
+}
+
+func TestReverse(t *testing.T) {
+	store := ledger.NewMemoryStore()
+	l := ledger.New(store)
+
+	ipn := &coinpayments.IPNDepositResponse{TxnID: "tx3", Currency: "BTC", Status: "100", AmountI: "1000", FeeI: "10"}
+	if _, err := l.CreditDeposit("customer-3", ipn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Reverse(ledger.KindDeposit, "tx3", "double spend"); err != nil {
+		t.Fatalf("Should have reversed the deposit, but it threw error: %s", err.Error())
+	}
+	if _, err := l.Reverse(ledger.KindDeposit, "tx3", "double spend"); err != ledger.ErrDuplicateEntry {
+		t.Fatalf("Should not have reversed the deposit twice, got %v", err)
+	}
+
+	for _, account := range []string{ledger.CustomerAccount("customer-3", "BTC"), ledger.WalletAccount("BTC"), ledger.FeeAccount("BTC")} {
+		balance, err := store.Balance(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != 0 {
+			t.Fatalf("Expected %s to be back at 0 after the reversal, got %d", account, balance)
+		}
+	}
+
+	if _, err := l.Reverse(ledger.KindDeposit, "unknown", ""); err != ledger.ErrEntryNotFound {
+		t.Fatalf("Should have failed to reverse an unknown entry, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package ledger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps everything in memory
+type MemoryStore struct {
+	mu       sync.RWMutex
+	entries  map[string]Entry
+	order    []string
+	balances map[string]int64
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]Entry{}, balances: map[string]int64{}}
+}
+
+// Append implements the Store interface
+func (s *MemoryStore) Append(e *Entry) error {
+	var sum int64
+	for _, p := range e.Postings {
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return ErrUnbalanced
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[e.ID]; ok {
+		return ErrDuplicateEntry
+	}
+
+	s.entries[e.ID] = *e
+	s.order = append(s.order, e.ID)
+	for _, p := range e.Postings {
+		s.balances[p.Account] += p.Amount
+	}
+	return nil
+}
+
+// Entry implements the Store interface
+func (s *MemoryStore) Entry(id string) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	return &e, nil
+}
+
+// Entries returns every entry in the order they were appended
+func (s *MemoryStore) Entries() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, len(s.order))
+	for i, id := range s.order {
+		entries[i] = s.entries[id]
+	}
+	return entries
+}
+
+// Balance implements the Store interface
+func (s *MemoryStore) Balance(account string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.balances[account], nil
+}
+
+// Accounts implements the Store interface
+func (s *MemoryStore) Accounts(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var accounts []string
+	for account := range s.balances {
+		if strings.HasPrefix(account, prefix) {
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
@@ -0,0 +1,317 @@
+package coinpayments
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPaymentNotTracked is returned for a transaction the PaymentTracker isn't tracking
+var ErrPaymentNotTracked = errors.New("payment is not being tracked")
+
+// PollError is returned by Poll for the transactions it couldn't poll, keyed by txn id. The others were updated.
+type PollError struct {
+	Errors map[string]error
+}
+
+func (e *PollError) Error() string {
+	txnIDs := make([]string, 0, len(e.Errors))
+	for txnID := range e.Errors {
+		txnIDs = append(txnIDs, txnID)
+	}
+	sort.Strings(txnIDs)
+	msgs := make([]string, len(txnIDs))
+	for i, txnID := range txnIDs {
+		msgs[i] = txnID + ": " + e.Errors[txnID].Error()
+	}
+	return fmt.Sprintf("polling %d transactions failed: %s", len(txnIDs), strings.Join(msgs, "; "))
+}
+
+// PaymentStatus is the latest state of a tracked transaction. Amounts are in the coin.
+type PaymentStatus struct {
+	TxnID          string    `json:"txn_id"`
+	Status         int       `json:"status"`
+	StatusText     string    `json:"status_text"`
+	Coin           string    `json:"coin"`
+	Address        string    `json:"address"`
+	DestTag        string    `json:"dest_tag,omitempty"`
+	Amount         string    `json:"amount"` // due
+	Received       string    `json:"received"`
+	Confirms       int       `json:"confirms"`
+	ConfirmsNeeded int       `json:"confirms_needed"`
+	Expires        time.Time `json:"expires"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Complete returns whether the payment has been received in full and confirmed
+func (s *PaymentStatus) Complete() bool {
+	return s.Status >= 100
+}
+
+// Cancelled returns whether the payment timed out or was cancelled
+func (s *PaymentStatus) Cancelled() bool {
+	return s.Status < 0
+}
+
+// Final returns whether the status won't change anymore
+func (s *PaymentStatus) Final() bool {
+	return s.Complete() || s.Cancelled()
+}
+
+// Shortfall returns how much is still due, in satoshis, once something was received. It's 0 for payments nothing was
+// received for yet and for payments received in full.
+func (s *PaymentStatus) Shortfall() int64 {
+	amount, _ := ParseSatoshis(s.Amount)
+	received, _ := ParseSatoshis(s.Received)
+	if received == 0 || received >= amount {
+		return 0
+	}
+	return amount - received
+}
+
+// Remaining returns how long is left before the payment times out, or 0 once it has
+func (s *PaymentStatus) Remaining(now time.Time) time.Duration {
+	if s.Expires.IsZero() || !now.Before(s.Expires) {
+		return 0
+	}
+	return s.Expires.Sub(now)
+}
+
+// PaymentTracker follows transactions until they complete or time out, from API IPNs or by polling get_tx_info, and
+// hands every change to its subscribers. It's all in memory: transactions are kept until they're forgotten or pruned,
+// and are lost on restart.
+type PaymentTracker struct {
+	mu          sync.Mutex
+	client      *Client
+	now         func() time.Time
+	payments    map[string]*PaymentStatus
+	subscribers map[string][]chan PaymentStatus
+
+	// OnError, if set, is called with the errors of polls made by Run
+	OnError func(error)
+	// Retention, if set, makes Run prune transactions that have been final, or expired, for longer, see Prune
+	Retention time.Duration
+}
+
+// NewPaymentTracker returns a PaymentTracker polling with the client
+func NewPaymentTracker(client *Client) *PaymentTracker {
+	return &PaymentTracker{client: client, now: time.Now, payments: map[string]*PaymentStatus{}, subscribers: map[string][]chan PaymentStatus{}}
+}
+
+// Track starts tracking a transaction returned by CallCreateTransaction, paid in coin, ie: the Currency2 of the
+// request
+func (t *PaymentTracker) Track(coin string, res *TransactionResult) PaymentStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	status := &PaymentStatus{
+		TxnID:      res.TxnID,
+		StatusText: "Waiting for buyer funds...",
+		Coin:       strings.ToUpper(coin),
+		Address:    res.Address,
+		DestTag:    res.DestTag,
+		Amount:     res.Amount,
+		Received:   "0",
+		UpdatedAt:  now,
+	}
+	status.ConfirmsNeeded, _ = strconv.Atoi(res.ConfirmsNeeded)
+	if res.Timeout > 0 {
+		status.Expires = now.Add(time.Duration(res.Timeout) * time.Second)
+	}
+	t.payments[res.TxnID] = status
+	return *status
+}
+
+// Status returns the latest status of a tracked transaction
+func (t *PaymentTracker) Status(txnID string) (PaymentStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.payments[txnID]
+	if !ok {
+		return PaymentStatus{}, ErrPaymentNotTracked
+	}
+	return *status, nil
+}
+
+// Subscribe returns a channel getting the status of the transaction every time it changes, starting with the current
+// one, and a function to unsubscribe. Subscribers that fall behind only get the latest status.
+func (t *PaymentTracker) Subscribe(txnID string) (<-chan PaymentStatus, func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status, ok := t.payments[txnID]
+	if !ok {
+		return nil, nil, ErrPaymentNotTracked
+	}
+	ch := make(chan PaymentStatus, 1)
+	ch <- *status
+	t.subscribers[txnID] = append(t.subscribers[txnID], ch)
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			subs := t.subscribers[txnID]
+			for i, sub := range subs {
+				if sub == ch {
+					t.subscribers[txnID] = append(subs[:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(t.subscribers[txnID]) == 0 {
+				delete(t.subscribers, txnID)
+			}
+		})
+	}
+	return ch, unsubscribe, nil
+}
+
+// Forget stops tracking a transaction
+func (t *PaymentTracker) Forget(txnID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.payments, txnID)
+}
+
+// Prune forgets the transactions that have been final, or past their expiry, for olderThan, and returns how many it
+// forgot. Call it now and then, or set Retention, so a long running
+// tracker doesn't grow without bound.
+func (t *PaymentTracker) Prune(olderThan time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	pruned := 0
+	for txnID, status := range t.payments {
+		final := status.Final() && now.Sub(status.UpdatedAt) >= olderThan
+		expired := !status.Expires.IsZero() && now.Sub(status.Expires) >= olderThan
+		if final || expired {
+			delete(t.payments, txnID)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// HandleAPI updates a tracked transaction from its API IPN. IPNs of transactions that aren't tracked are ignored, so it
+// can be used from IPNHandler.OnAPI alongside other handlers.
+func (t *PaymentTracker) HandleAPI(ipn *IPNAPIResponse) error {
+	status, err := strconv.Atoi(ipn.Status)
+	if err != nil {
+		return errors.New("invalid status " + ipn.Status)
+	}
+	confirms, _ := strconv.Atoi(ipn.ReceivedConfirms)
+	t.update(ipn.TxnID, status, ipn.StatusText, ipn.ReceivedAmount, confirms, time.Time{})
+	return nil
+}
+
+// Poll checks every tracked transaction that isn't final yet with get_tx_info. A transaction that can't be polled
+// doesn't hold up the others, its error is returned in a *PollError once they're all done.
+func (t *PaymentTracker) Poll() error {
+	t.mu.Lock()
+	var pending []string
+	for txnID, status := range t.payments {
+		if !status.Final() {
+			pending = append(pending, txnID)
+		}
+	}
+	t.mu.Unlock()
+
+	errs := map[string]error{}
+	for _, txnID := range pending {
+		if err := t.poll(txnID); err != nil {
+			errs[txnID] = err
+		}
+	}
+	if len(errs) > 0 {
+		return &PollError{Errors: errs}
+	}
+	return nil
+}
+
+// poll checks a single transaction with get_tx_info
+func (t *PaymentTracker) poll(txnID string) error {
+	info, err := t.client.CallGetTxInfo(&TxInfoRequest{TxID: txnID})
+	if err != nil {
+		return err
+	}
+	statusText, _ := txInfoString(info.Result, "status")
+	status, err := strconv.Atoi(statusText)
+	if err != nil {
+		return errors.New("invalid status " + statusText)
+	}
+	text, _ := txInfoString(info.Result, "status_text")
+	received, _ := txInfoString(info.Result, "receivedf")
+	confirmsText, _ := txInfoString(info.Result, "recv_confirms")
+	confirms, _ := strconv.Atoi(confirmsText)
+	var expires time.Time
+	if expiresText, ok := txInfoString(info.Result, "time_expires"); ok {
+		if unix, err := strconv.ParseInt(expiresText, 10, 64); err == nil {
+			expires = time.Unix(unix, 0)
+		}
+	}
+	t.update(txnID, status, text, received, confirms, expires)
+	return nil
+}
+
+// Run polls every interval until stop is closed
+func (t *PaymentTracker) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := t.Poll(); err != nil && t.OnError != nil {
+			t.OnError(err)
+		}
+		if t.Retention > 0 {
+			t.Prune(t.Retention)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update applies a new state to a tracked transaction and notifies the subscribers if it changed. The first final
+// status is kept, ie: a complete payment never turns cancelled, in case IPNs arrive out of order.
+func (t *PaymentTracker) update(txnID string, status int, text, received string, confirms int, expires time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, ok := t.payments[txnID]
+	if !ok {
+		return
+	}
+	next := *current
+	// the first final status is kept, only the confirmations and amount of a completed payment can still change
+	if (current.Complete() && status < 100) || (current.Cancelled() && status >= 0) {
+		return
+	}
+	next.Status, next.StatusText, next.Confirms = status, text, confirms
+	if received != "" {
+		next.Received = received
+	}
+	if !expires.IsZero() {
+		next.Expires = expires
+	}
+	if next == *current {
+		return
+	}
+	next.UpdatedAt = t.now()
+	*current = next
+
+	for _, ch := range t.subscribers[txnID] {
+		select {
+		case <-ch: // drop the status the subscriber hasn't read yet
+		default:
+		}
+		ch <- next
+	}
+}
@@ -0,0 +1,188 @@
+package coinpayments_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestPaymentTrackerIPN(t *testing.T) {
+	tracker := coinpayments.NewPaymentTracker(offlineClient(t))
+	status := tracker.Track("btc", &coinpayments.TransactionResult{TxnID: "TX1", Amount: "0.01000000", Address: "1Boat", ConfirmsNeeded: "2", Timeout: 900})
+	if status.Coin != "BTC" || status.ConfirmsNeeded != 2 || status.Remaining(time.Now()) <= 890*time.Second {
+		t.Fatalf("Should have tracked the payment in upper case with its timeout, got %+v", status)
+	}
+
+	updates, unsubscribe, err := tracker.Subscribe("TX1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	if first := <-updates; first.TxnID != "TX1" {
+		t.Fatalf("Should have sent the current status first, got %+v", first)
+	}
+
+	// part of the amount
+	if err := tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "1", StatusText: "Funds received", ReceivedAmount: "0.004", ReceivedConfirms: "0"}); err != nil {
+		t.Fatal(err)
+	}
+	partial := <-updates
+	if partial.Status != 1 || partial.Shortfall() != 600000 || partial.Final() {
+		t.Errorf("Should have updated the payment to partially paid, got %+v", partial)
+	}
+
+	// a repeated IPN changes nothing
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "1", StatusText: "Funds received", ReceivedAmount: "0.004", ReceivedConfirms: "0"})
+	select {
+	case s := <-updates:
+		t.Errorf("Should not have sent an unchanged status, got %+v", s)
+	default:
+	}
+
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "100", StatusText: "Complete", ReceivedAmount: "0.01", ReceivedConfirms: "2"})
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "1", StatusText: "late", ReceivedAmount: "0.01", ReceivedConfirms: "1"})
+	complete := <-updates
+	if !complete.Complete() || complete.Confirms != 2 || complete.Shortfall() != 0 {
+		t.Errorf("Should have completed the payment, got %+v", complete)
+	}
+	if s, _ := tracker.Status("TX1"); s.StatusText != "Complete" {
+		t.Errorf("Should have ignored a late IPN, got %+v", s)
+	}
+
+	// untracked transactions are ignored
+	if err := tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "OTHER", Status: "100"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := tracker.Status("OTHER"); err != coinpayments.ErrPaymentNotTracked {
+		t.Errorf("Should not have tracked OTHER, got %v", err)
+	}
+}
+
+func TestPaymentTrackerPoll(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo: {
+			`{"error":"ok","result":{"status":0,"status_text":"Waiting for buyer funds...","receivedf":"0.00000000","recv_confirms":0,"time_expires":4102444800}}`,
+			`{"error":"ok","result":{"status":-1,"status_text":"Cancelled / Timed Out","receivedf":"0.00500000","recv_confirms":3,"time_expires":4102444800}}`,
+		},
+	}}
+	tracker := coinpayments.NewPaymentTracker(fakeClient(t, api))
+	tracker.Track("BTC", &coinpayments.TransactionResult{TxnID: "TX1", Amount: "0.01000000"})
+
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := tracker.Status("TX1")
+	if !status.Expires.Equal(time.Unix(4102444800, 0)) || status.Final() {
+		t.Errorf("Should have polled the expiry of the payment, got %+v", status)
+	}
+
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	status, _ = tracker.Status("TX1")
+	if !status.Cancelled() || status.Received != "0.00500000" || status.Shortfall() != 500000 {
+		t.Errorf("Should have cancelled the underpaid payment, got %+v", status)
+	}
+
+	// final payments aren't polled again
+	tracker.Poll()
+	if calls := api.callsFor(coinpayments.CmdGetTxInfo); len(calls) != 2 || calls[0].Get("txid") != "TX1" {
+		t.Errorf("Should have polled TX1 twice, got %v", calls)
+	}
+}
+
+// unknownTxAPI answers like its fakeAPI, except get_tx_info for txid, which the API doesn't know
+type unknownTxAPI struct {
+	*fakeAPI
+	txid string
+}
+
+func (f unknownTxAPI) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if values, _ := url.ParseQuery(string(body)); values.Get("cmd") == coinpayments.CmdGetTxInfo && values.Get("txid") == f.txid {
+		return &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`{"error":"Invalid transaction ID"}`))}, nil
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return f.fakeAPI.Do(req)
+}
+
+func TestPaymentTrackerPollErrors(t *testing.T) {
+	api := unknownTxAPI{&fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo: {`{"error":"ok","result":{"status":100,"status_text":"Complete","receivedf":"0.01000000","recv_confirms":2}}`},
+	}}, "BAD"}
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey"}, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := coinpayments.NewPaymentTracker(client)
+	for _, txnID := range []string{"BAD", "TX1", "TX2"} {
+		tracker.Track("BTC", &coinpayments.TransactionResult{TxnID: txnID, Amount: "0.01000000"})
+	}
+
+	err = tracker.Poll()
+	pollErr, ok := err.(*coinpayments.PollError)
+	if !ok || len(pollErr.Errors) != 1 || pollErr.Errors["BAD"] == nil {
+		t.Fatalf("Should have returned the error of the unknown transaction, got %v", err)
+	}
+	for _, txnID := range []string{"TX1", "TX2"} {
+		if status, _ := tracker.Status(txnID); !status.Complete() {
+			t.Errorf("Should have polled %s despite the unknown transaction, got %+v", txnID, status)
+		}
+	}
+}
+
+func TestPaymentTrackerKeepsFirstFinalStatus(t *testing.T) {
+	tracker := coinpayments.NewPaymentTracker(offlineClient(t))
+	tracker.Track("BTC", &coinpayments.TransactionResult{TxnID: "TX1", Amount: "0.01000000"})
+
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "100", StatusText: "Complete", ReceivedAmount: "0.01", ReceivedConfirms: "1"})
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "-1", StatusText: "Cancelled / Timed Out", ReceivedAmount: "0.01", ReceivedConfirms: "1"})
+	if s, _ := tracker.Status("TX1"); !s.Complete() {
+		t.Fatalf("Should have kept the payment complete, got %+v", s)
+	}
+
+	// a completed payment still picks up confirmations
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "100", StatusText: "Complete", ReceivedAmount: "0.01", ReceivedConfirms: "3"})
+	if s, _ := tracker.Status("TX1"); s.Confirms != 3 {
+		t.Errorf("Should have updated the confirmations of the complete payment, got %+v", s)
+	}
+}
+
+func TestPaymentTrackerPrune(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo: {`{"error":"ok","result":{"status":0,"status_text":"Waiting for buyer funds...","receivedf":"0.00000000","recv_confirms":0,"time_expires":1000000000}}`},
+	}}
+	tracker := coinpayments.NewPaymentTracker(fakeClient(t, api))
+	tracker.Track("BTC", &coinpayments.TransactionResult{TxnID: "DONE", Amount: "0.01000000"})
+	tracker.Track("BTC", &coinpayments.TransactionResult{TxnID: "OPEN", Amount: "0.01000000", Timeout: 900})
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "DONE", Status: "100", StatusText: "Complete", ReceivedAmount: "0.01"})
+
+	if pruned := tracker.Prune(time.Hour); pruned != 0 {
+		t.Fatalf("Should have kept the recent payments, pruned %d", pruned)
+	}
+	if pruned := tracker.Prune(0); pruned != 1 {
+		t.Fatalf("Should have pruned the final payment, pruned %d", pruned)
+	}
+	if _, err := tracker.Status("DONE"); err != coinpayments.ErrPaymentNotTracked {
+		t.Fatalf("Should have forgotten the final payment, got %v", err)
+	}
+
+	// polled past its expiry, long ago
+	tracker.Track("BTC", &coinpayments.TransactionResult{TxnID: "OLD", Amount: "0.01000000"})
+	tracker.Forget("OPEN")
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if pruned := tracker.Prune(time.Hour); pruned != 1 {
+		t.Errorf("Should have pruned the expired payment, pruned %d", pruned)
+	}
+}
@@ -0,0 +1,196 @@
+package coinpayments
+
+import (
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jeffwalsh/go-coinpayments/qr"
+)
+
+// ErrNoURIScheme is returned for coins we don't know a payment URI scheme for
+var ErrNoURIScheme = errors.New("no payment URI scheme for coin")
+
+// URISchemes are the BIP21 schemes of bitcoin-like coins, keyed by coin
+var URISchemes = map[string]string{
+	"BTC":  "bitcoin",
+	"LTC":  "litecoin",
+	"LTCT": "litecoin",
+	"DOGE": "dogecoin",
+	"BCH":  "bitcoincash",
+	"DASH": "dash",
+	"ZEC":  "zcash",
+}
+
+// EthereumChains are the EIP-155 chain ids of coins paid with EIP-681 URIs
+var EthereumChains = map[string]int64{
+	"ETH": 1,
+	"ETC": 61,
+}
+
+// ERC20Token is a token contract, for EIP-681 transfer URIs
+type ERC20Token struct {
+	Contract string
+	Decimals int
+	ChainID  int64
+}
+
+// ERC20Tokens are the tokens payment URIs can be made for, keyed by coin. Add any others you accept.
+var ERC20Tokens = map[string]ERC20Token{
+	"USDT.ERC20": {Contract: "0xdAC17F958D2ee523a2206206994597C13D831ec7", Decimals: 6, ChainID: 1},
+	"USDC":       {Contract: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", Decimals: 6, ChainID: 1},
+}
+
+// PaymentURI is a payment to an address, which wallets can open from a link or a QR code
+type PaymentURI struct {
+	Coin    string
+	Address string
+	Amount  string // in the coin, ie: "0.015". Leave empty to let the buyer fill it in.
+	DestTag string // XRP destination tag or XLM memo
+	Label   string // who is being paid, for wallets that show it
+	Message string
+}
+
+// TransactionPaymentURI returns the payment URI of a transaction created with CallCreateTransaction, in coin, ie:
+// the Currency2 of the request
+func TransactionPaymentURI(coin string, res *TransactionResult) *PaymentURI {
+	return &PaymentURI{Coin: coin, Address: res.Address, Amount: res.Amount, DestTag: res.DestTag}
+}
+
+// CallbackPaymentURI returns the payment URI of a callback or deposit address, for the amount if it's not empty
+func CallbackPaymentURI(coin string, res *CallbackAddressResult, amount string) *PaymentURI {
+	return &PaymentURI{Coin: coin, Address: res.Address, Amount: amount, DestTag: res.DestTag}
+}
+
+// String returns the URI, or an empty string if it can't be made, see Encode
+func (p *PaymentURI) String() string {
+	uri, _ := p.Encode()
+	return uri
+}
+
+// Encode returns the URI: BIP21 for bitcoin-like coins, EIP-681 for ETH and ERC-20 tokens, SEP-7 for XLM, and the
+// ripple: scheme for XRP. It returns ErrNoURIScheme for other coins.
+func (p *PaymentURI) Encode() (string, error) {
+	coin := strings.ToUpper(p.Coin)
+	var params []string
+	add := func(key, value string) {
+		if value != "" {
+			params = append(params, key+"="+uriEscape(value))
+		}
+	}
+
+	var uri string
+	switch {
+	case URISchemes[coin] != "":
+		scheme := URISchemes[coin]
+		amount, err := decimalString(p.Amount, 8)
+		if err != nil {
+			return "", err
+		}
+		uri = scheme + ":" + strings.TrimPrefix(p.Address, scheme+":")
+		add("amount", amount)
+		add("label", p.Label)
+		add("message", p.Message)
+
+	case EthereumChains[coin] != 0:
+		wei, err := scaledInteger(p.Amount, 18)
+		if err != nil {
+			return "", err
+		}
+		uri = "ethereum:" + p.Address + "@" + strconv.FormatInt(EthereumChains[coin], 10)
+		add("value", wei)
+
+	case ERC20Tokens[coin].Contract != "":
+		token := ERC20Tokens[coin]
+		units, err := scaledInteger(p.Amount, token.Decimals)
+		if err != nil {
+			return "", err
+		}
+		uri = "ethereum:" + token.Contract + "@" + strconv.FormatInt(token.ChainID, 10) + "/transfer"
+		add("address", p.Address)
+		add("uint256", units)
+
+	case coin == "XRP":
+		amount, err := decimalString(p.Amount, 6)
+		if err != nil {
+			return "", err
+		}
+		uri = "ripple:" + p.Address
+		add("amount", amount)
+		add("dt", p.DestTag)
+
+	case coin == "XLM":
+		amount, err := decimalString(p.Amount, 7)
+		if err != nil {
+			return "", err
+		}
+		uri = "web+stellar:pay"
+		add("destination", p.Address)
+		add("amount", amount)
+		if p.DestTag != "" {
+			add("memo", p.DestTag)
+			if _, err := strconv.ParseUint(p.DestTag, 10, 64); err == nil {
+				add("memo_type", "MEMO_ID")
+			} else {
+				add("memo_type", "MEMO_TEXT")
+			}
+		}
+		add("msg", p.Message)
+
+	default:
+		return "", ErrNoURIScheme
+	}
+
+	if len(params) > 0 {
+		uri += "?" + strings.Join(params, "&")
+	}
+	return uri, nil
+}
+
+// QRCode returns a QR code of the URI, or of the bare address for coins without a URI scheme
+func (p *PaymentURI) QRCode() (*qr.Code, error) {
+	uri, err := p.Encode()
+	if err == ErrNoURIScheme {
+		uri = p.Address
+	} else if err != nil {
+		return nil, err
+	}
+	return qr.Encode(uri, qr.M)
+}
+
+// uriEscape escapes a query value, with spaces as %20 rather than +, which not every wallet reads as a space
+func uriEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// decimalString returns the amount without trailing zeros, checking it has no more decimals than the coin
+func decimalString(amount string, decimals int) (string, error) {
+	if amount == "" {
+		return "", nil
+	}
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok || r.Sign() < 0 || strings.ContainsAny(amount, "eE/") {
+		return "", ErrInvalidAmount
+	}
+	s := r.FloatString(decimals)
+	if exact, _ := new(big.Rat).SetString(s); exact.Cmp(r) != 0 {
+		return "", ErrInvalidAmount
+	}
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s, nil
+}
+
+// scaledInteger returns the amount in the smallest unit of a coin with the decimals, ie: wei for 18
+func scaledInteger(amount string, decimals int) (string, error) {
+	s, err := decimalString(amount, decimals)
+	if err != nil || s == "" {
+		return s, err
+	}
+	r, _ := new(big.Rat).SetString(s)
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	return r.Num().String(), nil
+}
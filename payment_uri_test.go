@@ -0,0 +1,78 @@
+package coinpayments_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestPaymentURI(t *testing.T) {
+	tests := []struct {
+		uri      coinpayments.PaymentURI
+		expected string
+	}{
+		{coinpayments.PaymentURI{Coin: "BTC", Address: "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", Amount: "0.01500000", Label: "Cafe & Co", Message: "Order 42"},
+			"bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.015&label=Cafe%20%26%20Co&message=Order%2042"},
+		{coinpayments.PaymentURI{Coin: "ltc", Address: "LKKHMBjCU89fyFNgSRprDoD8Jb25N8uWvd"},
+			"litecoin:LKKHMBjCU89fyFNgSRprDoD8Jb25N8uWvd"},
+		{coinpayments.PaymentURI{Coin: "BCH", Address: "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", Amount: "1"},
+			"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a?amount=1"},
+		{coinpayments.PaymentURI{Coin: "ETH", Address: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", Amount: "0.25"},
+			"ethereum:0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed@1?value=250000000000000000"},
+		{coinpayments.PaymentURI{Coin: "USDT.ERC20", Address: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", Amount: "12.5"},
+			"ethereum:0xdAC17F958D2ee523a2206206994597C13D831ec7@1/transfer?address=0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed&uint256=12500000"},
+		{coinpayments.PaymentURI{Coin: "XRP", Address: "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", Amount: "20", DestTag: "12345"},
+			"ripple:rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh?amount=20&dt=12345"},
+		{coinpayments.PaymentURI{Coin: "XLM", Address: "GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZX", Amount: "100.5", DestTag: "98765"},
+			"web+stellar:pay?destination=GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZX&amount=100.5&memo=98765&memo_type=MEMO_ID"},
+		{coinpayments.PaymentURI{Coin: "XLM", Address: "GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZX", DestTag: "order 42"},
+			"web+stellar:pay?destination=GAAACAQDAQCQMBYIBEFAWDANBYHRAEISCMKBKFQXDAMRUGY4DUPB7JZX&memo=order%2042&memo_type=MEMO_TEXT"},
+	}
+	for _, tt := range tests {
+		uri, err := tt.uri.Encode()
+		if err != nil {
+			t.Errorf("Should have encoded the %s uri, but it threw error: %s", tt.uri.Coin, err.Error())
+			continue
+		}
+		if uri != tt.expected {
+			t.Errorf("Should have encoded the %s uri as %s, got %s", tt.uri.Coin, tt.expected, uri)
+		}
+	}
+}
+
+func TestPaymentURIErrors(t *testing.T) {
+	if _, err := (&coinpayments.PaymentURI{Coin: "NXT", Address: "NXT-1234"}).Encode(); err != coinpayments.ErrNoURIScheme {
+		t.Errorf("Should not have had a scheme for NXT, got %v", err)
+	}
+	if _, err := (&coinpayments.PaymentURI{Coin: "BTC", Address: "1Boat", Amount: "0.123456789"}).Encode(); err != coinpayments.ErrInvalidAmount {
+		t.Errorf("Should have refused more than 8 decimals of BTC, got %v", err)
+	}
+	if _, err := (&coinpayments.PaymentURI{Coin: "ETH", Address: "0x1", Amount: "-1"}).Encode(); err != coinpayments.ErrInvalidAmount {
+		t.Errorf("Should have refused a negative amount, got %v", err)
+	}
+}
+
+func TestTransactionPaymentURI(t *testing.T) {
+	res := &coinpayments.TransactionResult{Amount: "0.00150000", Address: "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", TxnID: "TX1"}
+	uri := coinpayments.TransactionPaymentURI("BTC", res)
+	if uri.String() != "bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.0015" {
+		t.Errorf("Should have built the bitcoin uri of the transaction, got %s", uri)
+	}
+	code, err := uri.QRCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Size == 0 {
+		t.Error("Should have drawn a QR code, but it's empty")
+	}
+
+	deposit := coinpayments.CallbackPaymentURI("XRP", &coinpayments.CallbackAddressResult{Address: "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", DestTag: "7"}, "")
+	if deposit.String() != "ripple:rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh?dt=7" {
+		t.Errorf("Should have built the ripple uri with the destination tag, got %s", deposit)
+	}
+
+	// coins without a scheme get a QR code of the address
+	if _, err := (&coinpayments.PaymentURI{Coin: "NXT", Address: "NXT-1234"}).QRCode(); err != nil {
+		t.Error(err)
+	}
+}
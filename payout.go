@@ -0,0 +1,391 @@
+package coinpayments
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payout proposal statuses
+const (
+	PayoutPending    = "pending"
+	PayoutSubmitting = "submitting" // reached quorum and is being sent. It stays so if the outcome is unknown, see ResolveSubmitting.
+	PayoutSubmitted  = "submitted"  // reached quorum and was accepted by the API
+	PayoutFailed     = "failed"     // reached quorum but the API refused it
+	PayoutCancelled  = "cancelled"
+	PayoutExpired    = "expired"
+)
+
+// Errors returned by PayoutApprovals
+var (
+	ErrPayoutNotFound       = errors.New("payout proposal not found")
+	ErrPayoutNotPending     = errors.New("payout proposal is no longer pending")
+	ErrUnknownApprover      = errors.New("approver is not allowed to approve payouts")
+	ErrInvalidApprovalToken = errors.New("approval token does not match the payout proposal")
+	ErrSelfApproval         = errors.New("payouts can't be approved by whoever proposed them")
+	ErrInvalidQuorum        = errors.New("quorum must be between 1 and the number of approvers")
+	ErrPayoutNotSubmitting  = errors.New("payout proposal is not waiting on the outcome of its submission")
+)
+
+// Approver is someone allowed to approve payouts. Approvers sign approval tokens with their ed25519 private key,
+// which never leaves them, and PayoutApprovals only holds their public keys. Nobody with access to the server can
+// approve on their behalf.
+type Approver struct {
+	Name      string
+	PublicKey ed25519.PublicKey
+}
+
+// PayoutApproval is a single approval of a payout proposal
+type PayoutApproval struct {
+	Approver string    `json:"approver"`
+	At       time.Time `json:"at"`
+}
+
+// PayoutProposal is a transfer or withdrawal waiting for approval before it is sent to the API
+type PayoutProposal struct {
+	ID         string            `json:"id"`
+	Cmd        string            `json:"cmd"` // CmdCreateTransfer or CmdCreateWithdrawal
+	Request    WithdrawalRequest `json:"request"`
+	ProposedBy string            `json:"proposed_by"`
+	Status     string            `json:"status"`
+	Approvals  []PayoutApproval  `json:"approvals"`
+	Result     *WithdrawalResult `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// approvedBy returns whether the approver already approved the proposal
+func (p *PayoutProposal) approvedBy(name string) bool {
+	for _, a := range p.Approvals {
+		if a.Approver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// digest is what an approval token signs, so a token only approves this exact payout
+func (p *PayoutProposal) digest() []byte {
+	req, _ := json.Marshal(p.Request)
+	sum := sha256.Sum256([]byte(p.ID + "\n" + p.Cmd + "\n" + string(req)))
+	return sum[:]
+}
+
+// PayoutStore persists payout proposals. Proposal returns ErrPayoutNotFound if there is no proposal with the id.
+type PayoutStore interface {
+	SaveProposal(p *PayoutProposal) error
+	Proposal(id string) (*PayoutProposal, error)
+}
+
+// MemoryPayoutStore is a PayoutStore that keeps everything in memory
+type MemoryPayoutStore struct {
+	mu        sync.RWMutex
+	proposals map[string]PayoutProposal
+}
+
+// NewMemoryPayoutStore returns an empty MemoryPayoutStore
+func NewMemoryPayoutStore() *MemoryPayoutStore {
+	return &MemoryPayoutStore{proposals: map[string]PayoutProposal{}}
+}
+
+// SaveProposal implements the PayoutStore interface
+func (s *MemoryPayoutStore) SaveProposal(p *PayoutProposal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *p
+	cp.Approvals = append([]PayoutApproval(nil), p.Approvals...)
+	s.proposals[p.ID] = cp
+	return nil
+}
+
+// Proposal implements the PayoutStore interface
+func (s *MemoryPayoutStore) Proposal(id string) (*PayoutProposal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.proposals[id]
+	if !ok {
+		return nil, ErrPayoutNotFound
+	}
+	p.Approvals = append([]PayoutApproval(nil), p.Approvals...)
+	return &p, nil
+}
+
+// PayoutApprovals records transfers and withdrawals as proposals, and only sends them to the API once Quorum of
+// the approvers have approved them with a signed token.
+type PayoutApprovals struct {
+	mu        sync.Mutex
+	client    *Client
+	store     PayoutStore
+	approvers map[string]Approver
+	now       func() time.Time
+	unchecked []thresholdRecord // payouts sent without approvals within the ThresholdWindow, oldest first
+
+	// Quorum is the number of approvals a proposal needs
+	Quorum int
+	// TTL is how long a proposal can wait for approvals before it expires. Defaults to 24 hours.
+	TTL time.Duration
+	// Thresholds, per currency, is the total amount that can be sent without approvals within the ThresholdWindow,
+	// so a large payout split into small ones still needs approval. Currencies missing from the map, and payouts
+	// with their amount in a currency2, always need approval.
+	Thresholds map[string]string
+	// ThresholdWindow is the rolling window of the Thresholds, kept in memory. Defaults to 24 hours.
+	ThresholdWindow time.Duration
+}
+
+// thresholdRecord is a payout sent without approvals
+type thresholdRecord struct {
+	currency string
+	amount   int64
+	at       time.Time
+}
+
+// NewPayoutApprovals returns a PayoutApprovals needing quorum approvals out of the given approvers. The quorum must
+// be at least 1 and no more than the number of approvers.
+func NewPayoutApprovals(client *Client, store PayoutStore, quorum int, approvers ...Approver) (*PayoutApprovals, error) {
+	byName := make(map[string]Approver, len(approvers))
+	for _, a := range approvers {
+		byName[a.Name] = a
+	}
+	if quorum < 1 || quorum > len(byName) {
+		return nil, ErrInvalidQuorum
+	}
+	return &PayoutApprovals{client: client, store: store, approvers: byName, now: time.Now, Quorum: quorum, TTL: 24 * time.Hour, Thresholds: map[string]string{}, ThresholdWindow: 24 * time.Hour}, nil
+}
+
+// Propose records a transfer (CmdCreateTransfer) or withdrawal (CmdCreateWithdrawal) as a pending proposal. Payouts
+// under the threshold of their currency are submitted right away.
+func (a *PayoutApprovals) Propose(proposedBy, cmd string, req *WithdrawalRequest) (*PayoutProposal, error) {
+	if cmd != CmdCreateTransfer && cmd != CmdCreateWithdrawal {
+		return nil, fmt.Errorf("payouts can't be proposed for the %s command", cmd)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	p := &PayoutProposal{
+		ID:         hex.EncodeToString(id),
+		Cmd:        cmd,
+		Request:    *req,
+		ProposedBy: proposedBy,
+		Status:     PayoutPending,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(a.TTL),
+		UpdatedAt:  now,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if amount, ok := a.underThreshold(req); ok {
+		if err := a.submit(p); err != nil {
+			return nil, err
+		}
+		if p.Status != PayoutFailed {
+			a.unchecked = append(a.unchecked, thresholdRecord{currency: strings.ToUpper(req.Currency), amount: amount, at: now})
+		}
+		return p, nil
+	}
+	if err := a.store.SaveProposal(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ApprovalMessage returns what the approver signs to approve the proposal. It covers the approver's name and the
+// exact payout, so a token can't be reused by someone else or for a different amount or address.
+func ApprovalMessage(approver string, p *PayoutProposal) []byte {
+	return append([]byte(approver+"\n"), p.digest()...)
+}
+
+// SignApproval returns the approval token of the proposal, signed with the approver's private key. Approvers run it
+// on their own side, ie: in a CLI holding their key.
+func SignApproval(approver string, key ed25519.PrivateKey, p *PayoutProposal) string {
+	return hex.EncodeToString(ed25519.Sign(key, ApprovalMessage(approver, p)))
+}
+
+// Approve adds the approval of the approver to the proposal, and submits it to the API once it reaches quorum.
+func (a *PayoutApprovals) Approve(id, approverName, token string) (*PayoutProposal, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.pending(id)
+	if err != nil {
+		return nil, err
+	}
+
+	approver, ok := a.approvers[approverName]
+	if !ok {
+		return nil, ErrUnknownApprover
+	}
+	if approverName == p.ProposedBy {
+		return nil, ErrSelfApproval
+	}
+	signature, err := hex.DecodeString(token)
+	if err != nil || len(approver.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(approver.PublicKey, ApprovalMessage(approverName, p), signature) {
+		return nil, ErrInvalidApprovalToken
+	}
+
+	if !p.approvedBy(approverName) {
+		p.Approvals = append(p.Approvals, PayoutApproval{Approver: approverName, At: a.now()})
+		p.UpdatedAt = a.now()
+	}
+	if a.Quorum >= 1 && len(p.Approvals) >= a.Quorum {
+		if err := a.submit(p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	if err := a.store.SaveProposal(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ResolveSubmitting settles a proposal whose submission had an unknown outcome, ie: the call timed out, once you've
+// checked the withdrawal history. Pass the result of the payout if it went out, or nil if it didn't, which fails
+// the proposal so it can be proposed again.
+func (a *PayoutApprovals) ResolveSubmitting(id string, res *WithdrawalResult) (*PayoutProposal, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.store.Proposal(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PayoutSubmitting {
+		return nil, ErrPayoutNotSubmitting
+	}
+
+	p.UpdatedAt = a.now()
+	if res == nil {
+		p.Status = PayoutFailed
+	} else {
+		p.Status, p.Result, p.Error = PayoutSubmitted, res, ""
+	}
+	if err := a.store.SaveProposal(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Cancel cancels a pending proposal
+func (a *PayoutApprovals) Cancel(id string) (*PayoutProposal, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.pending(id)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Status = PayoutCancelled
+	p.UpdatedAt = a.now()
+	if err := a.store.SaveProposal(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Proposal returns the proposal with the id
+func (a *PayoutApprovals) Proposal(id string) (*PayoutProposal, error) {
+	return a.store.Proposal(id)
+}
+
+// pending loads a proposal and makes sure it can still be acted on, expiring it if its time is up
+func (a *PayoutApprovals) pending(id string) (*PayoutProposal, error) {
+	p, err := a.store.Proposal(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Status == PayoutPending && !a.now().Before(p.ExpiresAt) {
+		p.Status = PayoutExpired
+		p.UpdatedAt = a.now()
+		if err := a.store.SaveProposal(p); err != nil {
+			return nil, err
+		}
+	}
+	if p.Status != PayoutPending {
+		return nil, ErrPayoutNotPending
+	}
+	return p, nil
+}
+
+// submit sends the proposal to the API and records the outcome on it. The proposal is saved as submitting first, so a
+// crash or a failed save halfway never leaves it pending to be sent again. It only fails when the API refused the
+// payout; after other errors it may have gone out, so it stays submitting until ResolveSubmitting.
+func (a *PayoutApprovals) submit(p *PayoutProposal) error {
+	p.Status, p.UpdatedAt = PayoutSubmitting, a.now()
+	if err := a.store.SaveProposal(p); err != nil {
+		return err
+	}
+
+	var res *WithdrawalResult
+	var err error
+	if p.Cmd == CmdCreateTransfer {
+		res, err = a.client.CallCreateTransfer(&p.Request)
+	} else {
+		res, err = a.client.CallCreateWithdrawal(&p.Request)
+	}
+
+	p.UpdatedAt = a.now()
+	switch {
+	case err == nil:
+		p.Status, p.Result = PayoutSubmitted, res
+	case callRefused(err):
+		p.Status, p.Error = PayoutFailed, err.Error()
+	default:
+		p.Error = err.Error()
+	}
+	return a.store.SaveProposal(p)
+}
+
+// underThreshold returns the amount of the payout in satoshis, and whether it's small enough, together with the
+// other payouts sent without approvals within the window, to skip approvals
+func (a *PayoutApprovals) underThreshold(req *WithdrawalRequest) (int64, bool) {
+	currency := strings.ToUpper(req.Currency)
+	threshold, ok := a.Thresholds[currency]
+	if !ok || (req.Currency2 != "" && !strings.EqualFold(req.Currency2, currency)) {
+		return 0, false
+	}
+	max, err := ParseSatoshis(threshold)
+	if err != nil {
+		return 0, false
+	}
+	amount, err := ParseSatoshis(req.Amount)
+	if err != nil || amount <= 0 {
+		return 0, false
+	}
+
+	window := a.ThresholdWindow
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	cutoff := a.now().Add(-window)
+	i := 0
+	for i < len(a.unchecked) && !a.unchecked[i].at.After(cutoff) {
+		i++
+	}
+	a.unchecked = a.unchecked[i:]
+
+	total := amount
+	for _, rec := range a.unchecked {
+		if rec.currency == currency {
+			total += rec.amount
+		}
+	}
+	return amount, total <= max
+}
@@ -0,0 +1,217 @@
+package coinpayments_test
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+// testApprover returns an approver and the private key they sign with
+func testApprover(name string) (coinpayments.Approver, ed25519.PrivateKey) {
+	key := ed25519.NewKeyFromSeed([]byte(strings.Repeat(name, 32)[:ed25519.SeedSize]))
+	return coinpayments.Approver{Name: name, PublicKey: key.Public().(ed25519.PublicKey)}, key
+}
+
+func TestPayoutApprovals(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1,"amount":"2"}}`},
+	}}
+	alice, aliceKey := testApprover("alice")
+	bob, bobKey := testApprover("bob")
+	carol, carolKey := testApprover("carol")
+	approvals, err := coinpayments.NewPayoutApprovals(fakeClient(t, api), coinpayments.NewMemoryPayoutStore(), 2, alice, bob, carol)
+	if err != nil {
+		t.Fatalf("Should have created the approvals, but it threw error: %s", err.Error())
+	}
+	approvals.Thresholds["BTC"] = "0.1"
+
+	small, err := approvals.Propose("alice", coinpayments.CmdCreateWithdrawal, &coinpayments.WithdrawalRequest{Amount: "0.05", Currency: "BTC", Address: "supplier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Status != coinpayments.PayoutSubmitted {
+		t.Fatalf("Should have submitted a payout under the threshold straight away, got %s", small.Status)
+	}
+
+	p, err := approvals.Propose("alice", coinpayments.CmdCreateWithdrawal, &coinpayments.WithdrawalRequest{Amount: "2", Currency: "BTC", Address: "supplier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != coinpayments.PayoutPending {
+		t.Fatalf("Should have held a payout over the threshold, got %s", p.Status)
+	}
+
+	if _, err := approvals.Approve(p.ID, "alice", coinpayments.SignApproval("alice", aliceKey, p)); err != coinpayments.ErrSelfApproval {
+		t.Fatalf("Should not have let the proposer approve, got %v", err)
+	}
+	if _, err := approvals.Approve(p.ID, "bob", coinpayments.SignApproval("bob", carolKey, p)); err != coinpayments.ErrInvalidApprovalToken {
+		t.Fatalf("Should not have accepted a token signed with someone else's key, got %v", err)
+	}
+	if _, err := approvals.Approve(p.ID, "bob", coinpayments.SignApproval("carol", carolKey, p)); err != coinpayments.ErrInvalidApprovalToken {
+		t.Fatalf("Should not have accepted a token signed for someone else, got %v", err)
+	}
+
+	if p, err = approvals.Approve(p.ID, "bob", coinpayments.SignApproval("bob", bobKey, p)); err != nil || p.Status != coinpayments.PayoutPending {
+		t.Fatalf("Should have stayed pending with one approval, got %v, %v", p, err)
+	}
+	if calls := api.callsFor(coinpayments.CmdCreateWithdrawal); len(calls) != 1 {
+		t.Fatalf("Should not have sent the payout before quorum, got %d calls", len(calls))
+	}
+
+	p, err = approvals.Approve(p.ID, "carol", coinpayments.SignApproval("carol", carolKey, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != coinpayments.PayoutSubmitted || p.Result == nil || p.Result.ID != "CW1" {
+		t.Fatalf("Should have submitted the payout at quorum, got %+v", p)
+	}
+
+	if _, err := approvals.Cancel(p.ID); err != coinpayments.ErrPayoutNotPending {
+		t.Fatalf("Should not be able to cancel a submitted payout, got %v", err)
+	}
+}
+
+func TestPayoutApprovalsQuorum(t *testing.T) {
+	bob, _ := testApprover("bob")
+	carol, _ := testApprover("carol")
+	for _, quorum := range []int{0, -1, 3} {
+		if _, err := coinpayments.NewPayoutApprovals(offlineClient(t), coinpayments.NewMemoryPayoutStore(), quorum, bob, carol); err != coinpayments.ErrInvalidQuorum {
+			t.Fatalf("Should have refused a quorum of %d out of 2 approvers, got %v", quorum, err)
+		}
+	}
+}
+
+func TestPayoutApprovalsRollingThreshold(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1,"amount":"0.04"}}`},
+	}}
+	bob, _ := testApprover("bob")
+	approvals, err := coinpayments.NewPayoutApprovals(fakeClient(t, api), coinpayments.NewMemoryPayoutStore(), 1, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approvals.Thresholds["BTC"] = "0.1"
+
+	for i, expected := range []string{coinpayments.PayoutSubmitted, coinpayments.PayoutSubmitted, coinpayments.PayoutPending} {
+		p, err := approvals.Propose("alice", coinpayments.CmdCreateWithdrawal, &coinpayments.WithdrawalRequest{Amount: "0.04", Currency: "BTC", Address: "supplier"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != expected {
+			t.Fatalf("Should have left payout %d %s, the total going over the threshold, got %s", i+1, expected, p.Status)
+		}
+	}
+
+	p, err := approvals.Propose("alice", coinpayments.CmdCreateWithdrawal, &coinpayments.WithdrawalRequest{Amount: "0.01", Currency: "BTC", Currency2: "USD", Address: "supplier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != coinpayments.PayoutPending {
+		t.Fatalf("Should have held a payout with its amount in USD, got %s", p.Status)
+	}
+}
+
+func TestPayoutApprovalsSubmitting(t *testing.T) {
+	bob, bobKey := testApprover("bob")
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey"}, timeoutAPI{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := coinpayments.NewMemoryPayoutStore()
+	approvals, err := coinpayments.NewPayoutApprovals(client, store, 1, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := approvals.Propose("alice", coinpayments.CmdCreateWithdrawal, &coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", Address: "supplier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err = approvals.Approve(p.ID, "bob", coinpayments.SignApproval("bob", bobKey, p)); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != coinpayments.PayoutSubmitting || p.Error == "" {
+		t.Fatalf("Should have left a payout that timed out submitting, got %+v", p)
+	}
+	if _, err := approvals.Approve(p.ID, "bob", coinpayments.SignApproval("bob", bobKey, p)); err != coinpayments.ErrPayoutNotPending {
+		t.Fatalf("Should not have sent a submitting payout again, got %v", err)
+	}
+
+	p, err = approvals.ResolveSubmitting(p.ID, &coinpayments.WithdrawalResult{ID: "CW1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := store.Proposal(p.ID); saved.Status != coinpayments.PayoutSubmitted || saved.Result.ID != "CW1" {
+		t.Fatalf("Should have saved the payout as submitted, got %+v", saved)
+	}
+	if _, err := approvals.ResolveSubmitting(p.ID, nil); err != coinpayments.ErrPayoutNotSubmitting {
+		t.Fatalf("Should only resolve submitting payouts, got %v", err)
+	}
+}
+
+func TestPayoutApprovalsRefused(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"Insufficient funds"}`},
+	}}
+	bob, bobKey := testApprover("bob")
+	approvals, err := coinpayments.NewPayoutApprovals(fakeClient(t, api), coinpayments.NewMemoryPayoutStore(), 1, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := approvals.Propose("alice", coinpayments.CmdCreateWithdrawal, &coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", Address: "supplier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err = approvals.Approve(p.ID, "bob", coinpayments.SignApproval("bob", bobKey, p)); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != coinpayments.PayoutFailed || p.Error != "Insufficient funds" {
+		t.Fatalf("Should have failed a payout the API refused, got %+v", p)
+	}
+}
+
+func TestPayoutApprovalsInvalidAddress(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1}}`},
+	}}
+	client := fakeClient(t, api)
+	client.ValidateAddresses = true
+	bob, bobKey := testApprover("bob")
+	approvals, err := coinpayments.NewPayoutApprovals(client, coinpayments.NewMemoryPayoutStore(), 1, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := approvals.Propose("alice", coinpayments.CmdCreateWithdrawal, &coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", Address: "1BoatSLRHtKNngkdXEeobR76b53LETtpyU"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err = approvals.Approve(p.ID, "bob", coinpayments.SignApproval("bob", bobKey, p)); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != coinpayments.PayoutFailed || len(api.callsFor(coinpayments.CmdCreateWithdrawal)) != 0 {
+		t.Fatalf("Should have failed a payout the address check stopped, got %+v", p)
+	}
+}
+
+func TestPayoutApprovalsCancel(t *testing.T) {
+	bob, bobKey := testApprover("bob")
+	approvals, err := coinpayments.NewPayoutApprovals(fakeClient(t, &fakeAPI{}), coinpayments.NewMemoryPayoutStore(), 1, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := approvals.Propose("alice", coinpayments.CmdCreateTransfer, &coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", PBNTag: "$supplier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := approvals.Cancel(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := approvals.Approve(p.ID, "bob", coinpayments.SignApproval("bob", bobKey, p)); err != coinpayments.ErrPayoutNotPending {
+		t.Fatalf("Should not be able to approve a cancelled payout, got %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package coinpayments
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutgoingPayment describes a transfer, withdrawal or conversion about to be sent to the API
+type OutgoingPayment struct {
+	Cmd        string // CmdCreateTransfer, CmdCreateWithdrawal or CmdConvertCoins
+	Amount     string
+	Currency   string // the currency sent. The from currency for conversions.
+	Currency2  string // the to currency for conversions. For transfers and withdrawals, the currency the amount is in.
+	Address    string
+	DestTag    string
+	PBNTag     string
+	MerchantID string
+}
+
+// outgoingPayment builds the OutgoingPayment for the values of an API call, or returns nil if the command doesn't
+// move funds out of our account.
+func outgoingPayment(cmd string, data url.Values) *OutgoingPayment {
+	switch cmd {
+	case CmdCreateTransfer, CmdCreateWithdrawal:
+		return &OutgoingPayment{
+			Cmd:        cmd,
+			Amount:     data.Get("amount"),
+			Currency:   data.Get("currency"),
+			Currency2:  data.Get("currency2"),
+			Address:    data.Get("address"),
+			DestTag:    data.Get("dest_tag"),
+			PBNTag:     data.Get("pbntag"),
+			MerchantID: data.Get("merchant"),
+		}
+	case CmdConvertCoins:
+		return &OutgoingPayment{
+			Cmd:       cmd,
+			Amount:    data.Get("amount"),
+			Currency:  data.Get("from"),
+			Currency2: data.Get("to"),
+			Address:   data.Get("address"),
+			DestTag:   data.Get("dest_tag"),
+		}
+	}
+	return nil
+}
+
+// WithdrawalPolicy decides whether outgoing payments may be sent. When the Policy of a client is set, every
+// transfer, withdrawal and conversion made through Call is checked before it reaches the API.
+type WithdrawalPolicy interface {
+	// Check returns a *PolicyError if the payment is denied. An allowed payment counts towards the limits of the
+	// policy straight away, so concurrent payments can't slip past them together.
+	Check(p *OutgoingPayment) error
+	// Release is called if the API refused an allowed payment, so it stops counting towards the limits. It's not
+	// called for transport errors, after which the payment may have gone out.
+	Release(p *OutgoingPayment)
+}
+
+// PolicyDenial is the reason a single rule denied a payment
+type PolicyDenial struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// PolicyError is returned when an outgoing payment is denied by the policy
+type PolicyError struct {
+	Payment OutgoingPayment
+	Denials []PolicyDenial
+}
+
+func (e *PolicyError) Error() string {
+	reasons := make([]string, len(e.Denials))
+	for i, d := range e.Denials {
+		reasons[i] = d.Rule + ": " + d.Reason
+	}
+	return fmt.Sprintf("%s of %s %s denied by policy: %s", e.Payment.Cmd, e.Payment.Amount, e.Payment.Currency, strings.Join(reasons, "; "))
+}
+
+// PolicyRecord is an allowed payment the policy engine remembers
+type PolicyRecord struct {
+	Payment OutgoingPayment
+	At      time.Time
+}
+
+// PolicyRule is a single rule of a PolicyEngine. Evaluate returns nil if the payment is allowed. history holds the
+// payments allowed so far, oldest first.
+type PolicyRule interface {
+	Evaluate(p *OutgoingPayment, history []PolicyRecord, now time.Time) *PolicyDenial
+}
+
+// PolicyEngine is a WithdrawalPolicy that runs every payment through its rules, and denies it if any rule does.
+// It keeps the payments it allowed for the last day in memory for the limit and velocity rules.
+type PolicyEngine struct {
+	mu      sync.Mutex
+	rules   []PolicyRule
+	history []PolicyRecord
+	now     func() time.Time
+
+	// AllowUnlimited lets through payments in currencies no LimitRule covers. By default they are denied.
+	AllowUnlimited bool
+}
+
+// NewPolicyEngine returns a PolicyEngine with the given rules
+func NewPolicyEngine(rules ...PolicyRule) *PolicyEngine {
+	return &PolicyEngine{rules: rules, now: time.Now}
+}
+
+// Check implements the WithdrawalPolicy interface
+func (e *PolicyEngine) Check(p *OutgoingPayment) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	e.prune(now)
+
+	var denials []PolicyDenial
+	limited := false
+	for _, rule := range e.rules {
+		if limit, ok := rule.(*LimitRule); ok && strings.EqualFold(limit.Currency, p.Currency) {
+			limited = true
+		}
+		if d := rule.Evaluate(p, e.history, now); d != nil {
+			denials = append(denials, *d)
+		}
+	}
+	if !limited && !e.AllowUnlimited {
+		denials = append(denials, PolicyDenial{Rule: "limit", Reason: fmt.Sprintf("no limit is set for %s", strings.ToUpper(p.Currency))})
+	}
+	if len(denials) > 0 {
+		return &PolicyError{Payment: *p, Denials: denials}
+	}
+
+	e.history = append(e.history, PolicyRecord{Payment: *p, At: now})
+	return nil
+}
+
+// Release implements the WithdrawalPolicy interface
+func (e *PolicyEngine) Release(p *OutgoingPayment) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := len(e.history) - 1; i >= 0; i-- {
+		if e.history[i].Payment == *p {
+			e.history = append(e.history[:i], e.history[i+1:]...)
+			return
+		}
+	}
+}
+
+// prune forgets payments older than a day, which is the longest window of the built in rules
+func (e *PolicyEngine) prune(now time.Time) {
+	cutoff := now.Add(-24 * time.Hour)
+	i := 0
+	for i < len(e.history) && e.history[i].At.Before(cutoff) {
+		i++
+	}
+	e.history = e.history[i:]
+}
+
+// LimitRule caps the amount of a currency sent in a single payment, and in total over the last 24 hours.
+// Amounts are decimals in the currency, an empty limit isn't enforced. Transfers and withdrawals with a currency2
+// have their amount in currency2 rather than in the currency sent, so they are denied.
+type LimitRule struct {
+	Currency  string
+	MaxSingle string
+	MaxDaily  string
+}
+
+// Evaluate implements the PolicyRule interface
+func (r *LimitRule) Evaluate(p *OutgoingPayment, history []PolicyRecord, now time.Time) *PolicyDenial {
+	if !strings.EqualFold(p.Currency, r.Currency) {
+		return nil
+	}
+	if p.Cmd != CmdConvertCoins && p.Currency2 != "" && !strings.EqualFold(p.Currency2, p.Currency) {
+		return &PolicyDenial{Rule: "limit", Reason: fmt.Sprintf("the amount is in %s, it can't be checked against the %s limits", strings.ToUpper(p.Currency2), r.Currency)}
+	}
+
+	amount, err := ParseSatoshis(p.Amount)
+	if err != nil || amount <= 0 {
+		return &PolicyDenial{Rule: "limit", Reason: fmt.Sprintf("invalid amount %q", p.Amount)}
+	}
+
+	if r.MaxSingle != "" {
+		max, err := ParseSatoshis(r.MaxSingle)
+		if err != nil || amount > max {
+			return &PolicyDenial{Rule: "limit", Reason: fmt.Sprintf("%s %s is over the single payment limit of %s", p.Amount, r.Currency, r.MaxSingle)}
+		}
+	}
+
+	if r.MaxDaily != "" {
+		max, err := ParseSatoshis(r.MaxDaily)
+		if err != nil {
+			return &PolicyDenial{Rule: "limit", Reason: fmt.Sprintf("invalid daily limit %q", r.MaxDaily)}
+		}
+		total := amount
+		cutoff := now.Add(-24 * time.Hour)
+		for _, rec := range history {
+			if rec.At.After(cutoff) && strings.EqualFold(rec.Payment.Currency, r.Currency) {
+				sent, _ := ParseSatoshis(rec.Payment.Amount)
+				total += sent
+			}
+		}
+		if total > max {
+			return &PolicyDenial{Rule: "limit", Reason: fmt.Sprintf("%s %s would bring the last 24 hours to %s, over the daily limit of %s", p.Amount, r.Currency, FormatSatoshis(total), r.MaxDaily)}
+		}
+	}
+	return nil
+}
+
+// VelocityRule caps the number of outgoing payments within a window, across all currencies
+type VelocityRule struct {
+	MaxPayments int
+	Window      time.Duration // at most 24 hours
+}
+
+// Evaluate implements the PolicyRule interface
+func (r *VelocityRule) Evaluate(p *OutgoingPayment, history []PolicyRecord, now time.Time) *PolicyDenial {
+	count := 0
+	cutoff := now.Add(-r.Window)
+	for _, rec := range history {
+		if rec.At.After(cutoff) {
+			count++
+		}
+	}
+	if count >= r.MaxPayments {
+		return &PolicyDenial{Rule: "velocity", Reason: fmt.Sprintf("%d payments were already sent in the last %s", count, r.Window)}
+	}
+	return nil
+}
+
+// AllowlistRule only allows payments to known destinations. Conversions that keep the coins in our account have
+// no destination and are always allowed.
+type AllowlistRule struct {
+	Addresses   []string // withdrawal and conversion addresses
+	PBNTags     []string
+	MerchantIDs []string
+}
+
+// Evaluate implements the PolicyRule interface
+func (r *AllowlistRule) Evaluate(p *OutgoingPayment, history []PolicyRecord, now time.Time) *PolicyDenial {
+	switch {
+	case p.Address != "":
+		if !stringExistsInSlice(r.Addresses, p.Address) {
+			return &PolicyDenial{Rule: "allowlist", Reason: fmt.Sprintf("address %s is not on the allowlist", p.Address)}
+		}
+	case p.PBNTag != "":
+		if !stringExistsInSlice(r.PBNTags, p.PBNTag) {
+			return &PolicyDenial{Rule: "allowlist", Reason: fmt.Sprintf("pbntag %s is not on the allowlist", p.PBNTag)}
+		}
+	case p.MerchantID != "":
+		if !stringExistsInSlice(r.MerchantIDs, p.MerchantID) {
+			return &PolicyDenial{Rule: "allowlist", Reason: fmt.Sprintf("merchant %s is not on the allowlist", p.MerchantID)}
+		}
+	case p.Cmd != CmdConvertCoins:
+		return &PolicyDenial{Rule: "allowlist", Reason: "payment has no destination"}
+	}
+	return nil
+}
+
+// DestTagRule requires a destination tag for withdrawals of coins that share one address between accounts,
+// ie: XRP or XLM, since the funds are lost to the exchange without one.
+type DestTagRule struct {
+	Currencies []string
+}
+
+// Evaluate implements the PolicyRule interface
+func (r *DestTagRule) Evaluate(p *OutgoingPayment, history []PolicyRecord, now time.Time) *PolicyDenial {
+	if p.Address == "" || p.DestTag != "" {
+		return nil
+	}
+
+	// conversions send the coin they convert to. Withdrawals send currency, currency2 is only the unit of the amount.
+	currency := p.Currency
+	if p.Cmd == CmdConvertCoins && p.Currency2 != "" {
+		currency = p.Currency2
+	}
+	for _, c := range r.Currencies {
+		if strings.EqualFold(c, currency) {
+			return &PolicyDenial{Rule: "dest_tag", Reason: fmt.Sprintf("%s withdrawals need a destination tag", strings.ToUpper(currency))}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package coinpayments_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestPolicyEngine(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1,"amount":"0.5"}}`},
+		coinpayments.CmdConvertCoins:     {`{"error":"ok","result":{"id":"CV1"}}`},
+	}}
+	client := fakeClient(t, api)
+	client.Policy = coinpayments.NewPolicyEngine(
+		&coinpayments.LimitRule{Currency: "BTC", MaxSingle: "1", MaxDaily: "1.5"},
+		&coinpayments.VelocityRule{MaxPayments: 5, Window: time.Hour},
+		&coinpayments.AllowlistRule{Addresses: []string{"cold-btc", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh"}},
+		&coinpayments.DestTagRule{Currencies: []string{"XRP"}},
+	)
+
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", Address: "cold-btc"}); err != nil {
+		t.Fatalf("Should have allowed the withdrawal, but it threw error: %s", err.Error())
+	}
+
+	_, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "0.6", Currency: "BTC", Address: "attacker"})
+	policyErr, ok := err.(*coinpayments.PolicyError)
+	if !ok {
+		t.Fatalf("Should have denied the withdrawal with a policy error, got %v", err)
+	}
+	if len(policyErr.Denials) != 2 || policyErr.Denials[0].Rule != "limit" || policyErr.Denials[1].Rule != "allowlist" {
+		t.Fatalf("Should have denied the withdrawal for the daily limit and the allowlist, got %+v", policyErr.Denials)
+	}
+
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "10", Currency: "XRP", Address: "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh"}); err == nil {
+		t.Fatalf("Should have denied an XRP withdrawal without a destination tag")
+	}
+
+	if _, err := client.CallConvertCoins(&coinpayments.ConvertRequest{Amount: "0.4", From: "BTC", To: "ETH"}); err != nil {
+		t.Fatalf("Should have allowed a conversion within the limits, but it threw error: %s", err.Error())
+	}
+
+	// 5 BTC worth of DOGE isn't 5 DOGE
+	client.Policy.(*coinpayments.PolicyEngine).AllowUnlimited = true
+	if _, err := client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "0.1", Currency: "BTC", Currency2: "USD", Address: "cold-btc"}); err == nil {
+		t.Fatalf("Should have denied a withdrawal with its amount in another currency")
+	}
+	client.Policy.(*coinpayments.PolicyEngine).AllowUnlimited = false
+	_, err = client.CallCreateWithdrawal(&coinpayments.WithdrawalRequest{Amount: "5", Currency: "DOGE", Currency2: "BTC", Address: "cold-btc"})
+	if policyErr, ok := err.(*coinpayments.PolicyError); !ok || policyErr.Denials[0].Reason != "no limit is set for DOGE" {
+		t.Fatalf("Should have denied a currency without a limit, got %v", err)
+	}
+
+	if calls := api.callsFor(coinpayments.CmdCreateWithdrawal); len(calls) != 1 {
+		t.Fatalf("Denied withdrawals should never reach the api, got %d calls", len(calls))
+	}
+}
+
+func TestPolicyEngineRelease(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateTransfer: {`{"error":"insufficient funds"}`, `{"error":"ok","result":{"id":"CT1","status":1}}`},
+	}}
+	client := fakeClient(t, api)
+	client.Policy = coinpayments.NewPolicyEngine(
+		&coinpayments.LimitRule{Currency: "BTC", MaxDaily: "1"},
+		&coinpayments.AllowlistRule{PBNTags: []string{"$supplier"}},
+	)
+
+	req := &coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", PBNTag: "$supplier"}
+	if _, err := client.CallCreateTransfer(req); err == nil || err.Error() != "insufficient funds" {
+		t.Fatalf("Should have passed the api error through, got %v", err)
+	}
+
+	// the failed transfer shouldn't count towards the daily limit
+	if _, err := client.CallCreateTransfer(req); err != nil {
+		t.Fatalf("Should have allowed the retry, but it threw error: %s", err.Error())
+	}
+}
+
+// timeoutAPI fails every call as if the response never arrived
+type timeoutAPI struct{}
+
+func (timeoutAPI) Do(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("i/o timeout")
+}
+
+func TestPolicyEngineKeepsAmbiguousPayments(t *testing.T) {
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey"}, timeoutAPI{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Policy = coinpayments.NewPolicyEngine(
+		&coinpayments.LimitRule{Currency: "BTC", MaxDaily: "1"},
+		&coinpayments.AllowlistRule{PBNTags: []string{"$supplier"}},
+	)
+
+	req := &coinpayments.WithdrawalRequest{Amount: "1", Currency: "BTC", PBNTag: "$supplier"}
+	if _, err := client.CallCreateTransfer(req); err == nil {
+		t.Fatalf("Should have failed the transfer")
+	}
+
+	// the transfer may have gone out, so a retry would exceed the daily limit
+	if _, err := client.CallCreateTransfer(req); err == nil {
+		t.Fatalf("Should have counted the timed out transfer towards the daily limit")
+	} else if _, ok := err.(*coinpayments.PolicyError); !ok {
+		t.Fatalf("Should have denied the retry with a policy error, got %v", err)
+	}
+}
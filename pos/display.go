@@ -0,0 +1,104 @@
+package pos
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+	"github.com/jeffwalsh/go-coinpayments/qr"
+)
+
+// TerminalDisplay shows payments on an ANSI terminal, redrawing the screen on every update
+type TerminalDisplay struct {
+	W io.Writer
+	// Plain, if set, prints every change of status on a new line instead of redrawing, ie: when writing to a log
+	Plain bool
+
+	payment string // the payment part of the screen, drawn above the status
+	last    string // the last status printed in plain mode
+}
+
+// NewTerminalDisplay returns a TerminalDisplay writing to w
+func NewTerminalDisplay(w io.Writer) *TerminalDisplay {
+	return &TerminalDisplay{W: w}
+}
+
+// ShowPayment draws the QR code, amount and address of the payment
+func (d *TerminalDisplay) ShowPayment(p *Payment) {
+	var b strings.Builder
+	b.WriteString(QRText(p.QR))
+	fmt.Fprintf(&b, "\n  Pay      %s %s  (%s %s)\n", p.Transaction.Amount, strings.ToUpper(p.Sale.Coin), p.Sale.Amount, p.Fiat)
+	fmt.Fprintf(&b, "  To       %s\n", p.Transaction.Address)
+	if p.Transaction.DestTag != "" {
+		fmt.Fprintf(&b, "  Tag      %s\n", p.Transaction.DestTag)
+	}
+	d.payment = b.String()
+	if d.Plain {
+		io.WriteString(d.W, d.payment)
+	}
+}
+
+// ShowStatus draws the status, amount received and countdown of the payment
+func (d *TerminalDisplay) ShowStatus(p *Payment, status coinpayments.PaymentStatus, remaining time.Duration) {
+	line := fmt.Sprintf("  %-8s %s", "Status", status.StatusText)
+	if received, _ := coinpayments.ParseSatoshis(status.Received); received > 0 {
+		line += fmt.Sprintf("  received %s %s", status.Received, status.Coin)
+		if status.ConfirmsNeeded > 0 {
+			line += fmt.Sprintf(", %d/%d confirms", status.Confirms, status.ConfirmsNeeded)
+		}
+	}
+	if shortfall := status.Shortfall(); shortfall > 0 {
+		line += fmt.Sprintf("  short by %s", coinpayments.FormatSatoshis(shortfall))
+	}
+	if d.Plain {
+		// the countdown alone isn't worth a line
+		if line != d.last {
+			d.last = line
+			fmt.Fprintln(d.W, line)
+		}
+		return
+	}
+	if !status.Final() && !status.Expires.IsZero() {
+		line += "  " + Countdown(remaining)
+	}
+	// move home, clear the screen and draw it all again
+	fmt.Fprintf(d.W, "\x1b[H\x1b[2J%s\n%s\n", d.payment, line)
+}
+
+// Countdown formats the time left to pay, ie: "14:05 left"
+func Countdown(remaining time.Duration) string {
+	if remaining <= 0 {
+		return "expired"
+	}
+	seconds := int(remaining.Round(time.Second) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d left", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d left", seconds/60, seconds%60)
+}
+
+// QRText draws the code with half block characters, two modules per character, dark on a light background so phones
+// scan it off terminals with dark themes too
+func QRText(code *qr.Code) string {
+	var b strings.Builder
+	for y := -qr.QuietZone; y < code.Size+qr.QuietZone; y += 2 {
+		b.WriteString("\x1b[30;47m")
+		for x := -qr.QuietZone; x < code.Size+qr.QuietZone; x++ {
+			top, bottom := code.Black(x, y), code.Black(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
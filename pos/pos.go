@@ -0,0 +1,253 @@
+// Package pos takes in-person payments. A Terminal creates a transaction for a fiat amount, shows the buyer the
+// address, amount and QR code, follows the payment live through IPNs or polling, and prints a receipt.
+package pos
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+	"github.com/jeffwalsh/go-coinpayments/qr"
+)
+
+// Outcomes of a sale
+const (
+	OutcomePaid      = "paid"
+	OutcomeUnderpaid = "underpaid"
+	OutcomeTimedOut  = "timed out"
+	OutcomeCancelled = "cancelled"
+)
+
+// ErrMissingAmount is returned for a sale without a fiat amount or coin
+var ErrMissingAmount = errors.New("sale needs an amount and a coin")
+
+// Sale is what the buyer is paying for
+type Sale struct {
+	Amount   string // in the fiat currency of the terminal, ie: "4.50"
+	Coin     string // the coin the buyer pays with, ie: BTC
+	ItemName string
+	Invoice  string
+}
+
+// Payment is a sale waiting to be paid
+type Payment struct {
+	Sale        Sale
+	Fiat        string
+	Transaction *coinpayments.TransactionResult
+	URI         string // the payment URI the QR code holds, or the bare address for coins without one
+	QR          *qr.Code
+	Started     time.Time
+}
+
+// Display shows a payment to the buyer
+type Display interface {
+	// ShowPayment is called once the transaction is created
+	ShowPayment(p *Payment)
+	// ShowStatus is called every time the status changes, and every second for the countdown
+	ShowStatus(p *Payment, status coinpayments.PaymentStatus, remaining time.Duration)
+}
+
+// Terminal is a point of sale. Feed the IPNs of the client to Tracker.HandleAPI, or set PollInterval.
+type Terminal struct {
+	Client  *coinpayments.Client
+	Tracker *coinpayments.PaymentTracker
+	Display Display
+
+	// Fiat is the currency prices are in, ie: USD
+	Fiat string
+	// BuyerEmail is required by create_transaction. In-person buyers rarely give one, so it's the shop's own address.
+	BuyerEmail string
+	// Merchant is the name printed on receipts
+	Merchant string
+	// PollInterval, if set, polls get_tx_info while waiting, for terminals that don't get IPNs
+	PollInterval time.Duration
+	// OnError, if set, gets the errors polling get_tx_info. They don't stop Wait, which keeps following the payment.
+	OnError func(err error)
+
+	now func() time.Time
+}
+
+// NewTerminal returns a Terminal selling in the fiat currency
+func NewTerminal(client *coinpayments.Client, fiat, buyerEmail string) *Terminal {
+	return &Terminal{Client: client, Tracker: coinpayments.NewPaymentTracker(client), Fiat: strings.ToUpper(fiat), BuyerEmail: buyerEmail, now: time.Now}
+}
+
+// Start creates the transaction of the sale, starts tracking it and shows it on the display
+func (t *Terminal) Start(sale *Sale) (*Payment, error) {
+	if sale.Amount == "" || sale.Coin == "" {
+		return nil, ErrMissingAmount
+	}
+
+	res, err := t.Client.CallCreateTransaction(&coinpayments.TransactionRequest{
+		Amount:     sale.Amount,
+		Currency1:  t.Fiat,
+		Currency2:  sale.Coin,
+		BuyerEmail: t.BuyerEmail,
+		ItemName:   sale.ItemName,
+		Invoice:    sale.Invoice,
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.Tracker.Track(sale.Coin, res)
+
+	p := &Payment{Sale: *sale, Fiat: t.Fiat, Transaction: res, Started: t.now()}
+	uri := coinpayments.TransactionPaymentURI(sale.Coin, res)
+	if p.URI, err = uri.Encode(); err == coinpayments.ErrNoURIScheme {
+		p.URI = res.Address
+	} else if err != nil {
+		return nil, err
+	}
+	if p.QR, err = qr.Encode(p.URI, qr.M); err != nil {
+		return nil, err
+	}
+
+	if t.Display != nil {
+		t.Display.ShowPayment(p)
+	}
+	return p, nil
+}
+
+// Wait follows the payment until it completes, times out, or cancel is closed, ie: the cashier gave up on it, and
+// returns its receipt. A payment that timed out with part of the amount received is underpaid. Before timing it out
+// when nothing was received, it polls get_tx_info once in case an IPN went missing, and keeps trying until that poll
+// goes through. Poll errors go to OnError rather than ending the sale. The transaction isn't cancelled with
+// CoinPayments, anything the buyer still sends after giving up on it is received as usual. The tracker forgets the
+// transaction once Wait returns.
+func (t *Terminal) Wait(p *Payment, cancel <-chan struct{}) (*Receipt, error) {
+	defer t.Tracker.Forget(p.Transaction.TxnID)
+	updates, unsubscribe, err := t.Tracker.Subscribe(p.Transaction.TxnID)
+	if err != nil {
+		return nil, err
+	}
+	defer unsubscribe()
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	var poll <-chan time.Time
+	if t.PollInterval > 0 {
+		pollTicker := time.NewTicker(t.PollInterval)
+		defer pollTicker.Stop()
+		poll = pollTicker.C
+	}
+
+	status, _ := t.Tracker.Status(p.Transaction.TxnID)
+	checked := false
+	for {
+		if t.Display != nil {
+			t.Display.ShowStatus(p, status, status.Remaining(t.now()))
+		}
+		switch {
+		case status.Complete():
+			return t.receipt(p, status, OutcomePaid), nil
+		case status.Cancelled() && status.Shortfall() > 0:
+			return t.receipt(p, status, OutcomeUnderpaid), nil
+		case status.Cancelled():
+			return t.receipt(p, status, OutcomeTimedOut), nil
+		case status.Remaining(t.now()) == 0 && !status.Expires.IsZero():
+			// nothing received by the deadline. With something received the payment may still complete, so it
+			// waits for CoinPayments to decide.
+			if received, _ := coinpayments.ParseSatoshis(status.Received); received == 0 {
+				if checked {
+					return t.receipt(p, status, OutcomeTimedOut), nil
+				}
+				// the local deadline alone isn't enough, the buyer may have paid without an IPN reaching us
+				if err := t.Tracker.Poll(); err != nil {
+					t.pollFailed(err)
+				} else {
+					checked = true
+					status, _ = t.Tracker.Status(p.Transaction.TxnID)
+					continue
+				}
+			}
+		}
+
+		select {
+		case status = <-updates:
+		case <-tick.C:
+		case <-poll:
+			if err := t.Tracker.Poll(); err != nil {
+				t.pollFailed(err)
+			}
+		case <-cancel:
+			return t.receipt(p, status, OutcomeCancelled), nil
+		}
+	}
+}
+
+// pollFailed reports an error polling get_tx_info to OnError
+func (t *Terminal) pollFailed(err error) {
+	if t.OnError != nil {
+		t.OnError(err)
+	}
+}
+
+// Receipt is the record of a sale, given to the buyer
+type Receipt struct {
+	Merchant   string    `json:"merchant,omitempty"`
+	TxnID      string    `json:"txn_id"`
+	Time       time.Time `json:"time"`
+	ItemName   string    `json:"item_name,omitempty"`
+	Invoice    string    `json:"invoice,omitempty"`
+	FiatAmount string    `json:"fiat_amount"`
+	Fiat       string    `json:"fiat"`
+	Coin       string    `json:"coin"`
+	Amount     string    `json:"amount"` // due, in the coin
+	Received   string    `json:"received"`
+	Shortfall  string    `json:"shortfall,omitempty"`
+	Outcome    string    `json:"outcome"`
+}
+
+// receipt returns the receipt of the payment
+func (t *Terminal) receipt(p *Payment, status coinpayments.PaymentStatus, outcome string) *Receipt {
+	r := &Receipt{
+		Merchant:   t.Merchant,
+		TxnID:      p.Transaction.TxnID,
+		Time:       t.now(),
+		ItemName:   p.Sale.ItemName,
+		Invoice:    p.Sale.Invoice,
+		FiatAmount: p.Sale.Amount,
+		Fiat:       p.Fiat,
+		Coin:       status.Coin,
+		Amount:     status.Amount,
+		Received:   status.Received,
+		Outcome:    outcome,
+	}
+	if shortfall := status.Shortfall(); shortfall > 0 {
+		r.Shortfall = coinpayments.FormatSatoshis(shortfall)
+	}
+	return r
+}
+
+// WriteTo writes the receipt as plain text, ie: to a receipt printer
+func (r *Receipt) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%-10s %s\n", label, value)
+		}
+	}
+
+	if r.Merchant != "" {
+		fmt.Fprintf(&b, "%s\n", r.Merchant)
+	}
+	fmt.Fprintf(&b, "%s\n", r.Time.Format("2006-01-02 15:04:05"))
+	b.WriteString(strings.Repeat("-", 32) + "\n")
+	line("Item", r.ItemName)
+	line("Invoice", r.Invoice)
+	line("Total", r.FiatAmount+" "+r.Fiat)
+	line("Due", r.Amount+" "+r.Coin)
+	line("Received", r.Received+" "+r.Coin)
+	if r.Shortfall != "" {
+		line("Short by", r.Shortfall+" "+r.Coin)
+	}
+	b.WriteString(strings.Repeat("-", 32) + "\n")
+	line("Status", strings.ToUpper(r.Outcome))
+	line("Txn", r.TxnID)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
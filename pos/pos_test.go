@@ -0,0 +1,264 @@
+package pos_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+	"github.com/jeffwalsh/go-coinpayments/pos"
+)
+
+// fakeAPI answers every command with its canned response
+type fakeAPI struct {
+	mu        sync.Mutex
+	responses map[string]string
+	calls     []url.Values
+}
+
+func (f *fakeAPI) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, values)
+	response, ok := f.responses[values.Get("cmd")]
+	if !ok {
+		response = `{"error":"no canned response"}`
+	}
+	return &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(response))}, nil
+}
+
+// recordingDisplay keeps every status it is shown
+type recordingDisplay struct {
+	mu       sync.Mutex
+	payment  *pos.Payment
+	statuses []coinpayments.PaymentStatus
+}
+
+func (d *recordingDisplay) ShowPayment(p *pos.Payment) {
+	d.payment = p
+}
+
+func (d *recordingDisplay) ShowStatus(p *pos.Payment, status coinpayments.PaymentStatus, remaining time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statuses = append(d.statuses, status)
+}
+
+// testTerminal returns a terminal whose transactions time out after the timeout, in seconds
+func testTerminal(t *testing.T, timeout int) (*pos.Terminal, *fakeAPI, *recordingDisplay) {
+	api := &fakeAPI{responses: map[string]string{
+		coinpayments.CmdCreateTransaction: `{"error":"ok","result":{"amount":"0.00100000","address":"1BoatSLRHtKNngkdXEeobR76b53LETtpyT","txn_id":"TX1","confirms_needed":"1","timeout":` + strconv.Itoa(timeout) + `}}`,
+	}}
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey", MerchantID: "merchantid", IPNSecret: "ipnsecret"}, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	display := &recordingDisplay{}
+	terminal := pos.NewTerminal(client, "usd", "shop@example.com")
+	terminal.Merchant = "Corner Cafe"
+	terminal.Display = display
+	return terminal, api, display
+}
+
+func TestSalePaid(t *testing.T) {
+	terminal, api, display := testTerminal(t, 900)
+	payment, err := terminal.Start(&pos.Sale{Amount: "4.50", Coin: "BTC", ItemName: "Flat white"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.URI != "bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.001" || payment.QR == nil || display.payment != payment {
+		t.Errorf("Should have shown the payment with its uri and QR code, got %+v", payment)
+	}
+	call := api.calls[0]
+	if call.Get("amount") != "4.50" || call.Get("currency1") != "USD" || call.Get("currency2") != "BTC" || call.Get("buyer_email") != "shop@example.com" {
+		t.Errorf("Should have created the transaction in fiat, got %v", call)
+	}
+
+	go func() {
+		terminal.Tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "1", StatusText: "Funds received", ReceivedAmount: "0.001", ReceivedConfirms: "0"})
+		terminal.Tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "100", StatusText: "Complete", ReceivedAmount: "0.001", ReceivedConfirms: "1"})
+	}()
+	receipt, err := terminal.Wait(payment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Outcome != pos.OutcomePaid || receipt.Received != "0.001" || receipt.Fiat != "USD" || receipt.Shortfall != "" {
+		t.Errorf("Should have printed a paid receipt, got %+v", receipt)
+	}
+
+	var b bytes.Buffer
+	if _, err := receipt.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Corner Cafe", "Flat white", "4.50 USD", "0.00100000 BTC", "PAID", "TX1"} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Should have had %q on the receipt:\n%s", expected, b.String())
+		}
+	}
+}
+
+func TestSaleUnderpaid(t *testing.T) {
+	terminal, _, _ := testTerminal(t, 900)
+	payment, err := terminal.Start(&pos.Sale{Amount: "4.50", Coin: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go terminal.Tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "-1", StatusText: "Cancelled / Timed Out", ReceivedAmount: "0.0004"})
+	receipt, err := terminal.Wait(payment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Outcome != pos.OutcomeUnderpaid || receipt.Shortfall != "0.00060000" {
+		t.Errorf("Should have printed an underpaid receipt with the shortfall, got %+v", receipt)
+	}
+}
+
+func TestSaleTimedOut(t *testing.T) {
+	terminal, api, display := testTerminal(t, 1)
+	api.responses[coinpayments.CmdGetTxInfo] = `{"error":"ok","result":{"status":0,"status_text":"Waiting for buyer funds...","receivedf":"0.00000000","recv_confirms":0}}`
+	payment, err := terminal.Start(&pos.Sale{Amount: "4.50", Coin: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := terminal.Wait(payment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Outcome != pos.OutcomeTimedOut || len(display.statuses) < 2 {
+		t.Errorf("Should have timed out the sale after counting down, got %+v", receipt)
+	}
+	var polls int
+	for _, call := range api.calls {
+		if call.Get("cmd") == coinpayments.CmdGetTxInfo {
+			polls++
+		}
+	}
+	if polls != 1 {
+		t.Errorf("Should have polled the transaction once before timing it out, got %d polls", polls)
+	}
+	if _, err := terminal.Tracker.Status("TX1"); err != coinpayments.ErrPaymentNotTracked {
+		t.Errorf("Should have stopped tracking the transaction, got %v", err)
+	}
+}
+
+func TestSalePaidAtDeadline(t *testing.T) {
+	terminal, api, _ := testTerminal(t, 1)
+	api.responses[coinpayments.CmdGetTxInfo] = `{"error":"ok","result":{"status":100,"status_text":"Complete","receivedf":"0.00100000","recv_confirms":1}}`
+	payment, err := terminal.Start(&pos.Sale{Amount: "4.50", Coin: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := terminal.Wait(payment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Outcome != pos.OutcomePaid || receipt.Received != "0.00100000" {
+		t.Errorf("Should have found the payment with the final poll instead of timing it out, got %+v", receipt)
+	}
+}
+
+func TestSalePollErrors(t *testing.T) {
+	terminal, api, _ := testTerminal(t, 900)
+	api.responses[coinpayments.CmdGetTxInfo] = `{"error":"Server busy"}`
+	terminal.PollInterval = 10 * time.Millisecond
+	var mu sync.Mutex
+	var errs []error
+	terminal.OnError = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+	payment, err := terminal.Start(&pos.Sale{Amount: "4.50", Coin: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the IPN still gets through while get_tx_info fails
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		terminal.Tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "100", StatusText: "Complete", ReceivedAmount: "0.001", ReceivedConfirms: "1"})
+	}()
+	receipt, err := terminal.Wait(payment, nil)
+	if err != nil {
+		t.Fatalf("Should have kept waiting through the poll errors, but it threw error: %s", err)
+	}
+	if receipt.Outcome != pos.OutcomePaid {
+		t.Errorf("Should have printed a paid receipt, got %+v", receipt)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) == 0 {
+		t.Error("Should have reported the poll errors to OnError, but it didn't")
+	}
+}
+
+func TestSaleCancelled(t *testing.T) {
+	terminal, _, _ := testTerminal(t, 900)
+	payment, err := terminal.Start(&pos.Sale{Amount: "4.50", Coin: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	receipt, err := terminal.Wait(payment, cancel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Outcome != pos.OutcomeCancelled {
+		t.Errorf("Should have cancelled the sale, got %+v", receipt)
+	}
+
+	if _, err := terminal.Start(&pos.Sale{Coin: "BTC"}); err != pos.ErrMissingAmount {
+		t.Errorf("Should have refused a sale without an amount, got %v", err)
+	}
+}
+
+func TestTerminalDisplay(t *testing.T) {
+	terminal, _, _ := testTerminal(t, 900)
+	var b bytes.Buffer
+	display := pos.NewTerminalDisplay(&b)
+	display.Plain = true
+	terminal.Display = display
+
+	payment, err := terminal.Start(&pos.Sale{Amount: "4.50", Coin: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	display.ShowStatus(payment, coinpayments.PaymentStatus{StatusText: "Funds received", Coin: "BTC", Amount: "0.001", Received: "0.0004", Confirms: 0, ConfirmsNeeded: 1, Expires: time.Now().Add(time.Hour)}, 14*time.Minute+5*time.Second)
+
+	out := b.String()
+	for _, expected := range []string{"▀", "0.00100000 BTC  (4.50 USD)", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", "received 0.0004 BTC, 0/1 confirms", "short by 0.00060000"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Should have had %q on the display:\n%s", expected, out)
+		}
+	}
+
+	display.Plain = false
+	display.ShowStatus(payment, coinpayments.PaymentStatus{StatusText: "Waiting for buyer funds...", Coin: "BTC", Amount: "0.001", Received: "0", Expires: time.Now().Add(time.Hour)}, 14*time.Minute+5*time.Second)
+	if out := b.String(); !strings.Contains(out, "\x1b[2J") || !strings.HasSuffix(out, "Waiting for buyer funds...  14:05 left\n") {
+		t.Errorf("Should have redrawn the screen with the countdown:\n%s", out)
+	}
+
+	if pos.Countdown(0) != "expired" || pos.Countdown(2*time.Hour+3*time.Second) != "2:00:03 left" {
+		t.Error("Should have formatted the countdowns, but it didn't")
+	}
+}
@@ -0,0 +1,179 @@
+// Package qr encodes QR codes, so payment addresses and URIs can be shown without sending them to a third party.
+//
+// Data is encoded in byte mode, in the smallest version (1 to 40) that holds it at the error correction level asked
+// for, with the mask that scores best against the penalty rules of ISO/IEC 18004.
+package qr
+
+import "errors"
+
+// ErrTooLong is returned when the data doesn't fit in a version 40 QR code at the error correction level
+var ErrTooLong = errors.New("data is too long for a QR code")
+
+// Level is the error correction level, how much of the code can be damaged and still be read
+type Level int
+
+// Error correction levels
+const (
+	L Level = iota // 7%
+	M              // 15%
+	Q              // 25%
+	H              // 30%
+)
+
+// formatBits are the bits of the level in the format information
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// Error correction codewords per block and number of blocks, by level and version. Index 0 is unused.
+var (
+	eccCodewordsPerBlock = [4][41]int{
+		{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	eccBlocks = [4][41]int{
+		{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+)
+
+// Code is an encoded QR code
+type Code struct {
+	Version int
+	Level   Level
+	Size    int // modules per side, without the quiet zone
+	Mask    int
+
+	modules    []bool
+	isFunction []bool
+}
+
+// Encode encodes the text as a QR code at the error correction level
+func Encode(text string, level Level) (*Code, error) {
+	return encode([]byte(text), level, -1)
+}
+
+// encode encodes the data with the mask, or the best mask if it's -1
+func encode(data []byte, level Level, mask int) (*Code, error) {
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if 4+charCountBits(v)+len(data)*8 <= dataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	// byte mode, the count, the data, then a terminator and padding up to the capacity
+	capacity := dataCodewords(version, level) * 8
+	var bb bitBuffer
+	bb.append(4, 4)
+	bb.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	terminator := capacity - len(bb)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xec; len(bb) < capacity; pad ^= 0xec ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	size := version*4 + 17
+	c := &Code{Version: version, Level: level, Size: size, modules: make([]bool, size*size), isFunction: make([]bool, size*size)}
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addErrorCorrection(bb.bytes()))
+
+	if mask < 0 {
+		best := 0
+		for m := 0; m < 8; m++ {
+			c.applyMask(m)
+			c.drawFormatBits(m)
+			if penalty := c.penalty(); m == 0 || penalty < best {
+				best, mask = penalty, m
+			}
+			c.applyMask(m) // masks undo themselves
+		}
+	}
+	c.Mask = mask
+	c.applyMask(mask)
+	c.drawFormatBits(mask)
+	c.isFunction = nil
+	return c, nil
+}
+
+// Black returns whether the module at x, y is dark. Modules outside the code, ie: the quiet zone, are light.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+// charCountBits is the length of the byte count in byte mode
+func charCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawDataModules is the number of modules of a version available for data and error correction
+func rawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		result -= (25*align-10)*align - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// dataCodewords is the number of 8 bit codewords of data a version holds at the level
+func dataCodewords(version int, level Level) int {
+	return rawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// alignmentPositions returns the centres of the alignment patterns on each axis
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i := count - 1; i >= 1; i-- {
+		positions[i] = version*4 + 17 - 7 - (count-1-i)*step
+	}
+	return positions
+}
+
+// bitBuffer is a sequence of bits
+type bitBuffer []bool
+
+// append appends the n low bits of value, most significant first
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 == 1)
+	}
+}
+
+// bytes packs the bits into bytes
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, (len(b)+7)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return out
+}
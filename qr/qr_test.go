@@ -0,0 +1,216 @@
+package qr_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments/qr"
+)
+
+func TestEncodeVersion(t *testing.T) {
+	tests := []struct {
+		length  int
+		level   qr.Level
+		version int
+	}{
+		{17, qr.L, 1},
+		{18, qr.L, 2},
+		{14, qr.M, 1},
+		{15, qr.M, 2},
+		{7, qr.H, 1},
+		{271, qr.L, 10}, // the count takes 16 bits from version 10
+		{2953, qr.L, 40},
+		{1273, qr.H, 40},
+	}
+	for _, tt := range tests {
+		code, err := qr.Encode(strings.Repeat("a", tt.length), tt.level)
+		if err != nil {
+			t.Fatalf("Should have encoded %d bytes, but it threw error: %s", tt.length, err.Error())
+		}
+		if code.Version != tt.version || code.Size != tt.version*4+17 {
+			t.Errorf("Should have encoded %d bytes at level %d in version %d, got %d", tt.length, tt.level, tt.version, code.Version)
+		}
+	}
+
+	if _, err := qr.Encode(strings.Repeat("a", 2954), qr.L); err != qr.ErrTooLong {
+		t.Errorf("Should have refused too much data, got %v", err)
+	}
+}
+
+func TestEncodeStructure(t *testing.T) {
+	code, err := qr.Encode("bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.015", qr.M)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// finder patterns in three corners
+	for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+		for i := 0; i < 7; i++ {
+			if !code.Black(corner[0]+i, corner[1]) || !code.Black(corner[0], corner[1]+i) {
+				t.Fatalf("Should have drawn a finder pattern at %v", corner)
+			}
+		}
+		if code.Black(corner[0]+1, corner[1]+1) || !code.Black(corner[0]+3, corner[1]+3) {
+			t.Fatalf("Should have drawn a finder pattern at %v", corner)
+		}
+	}
+
+	// timing patterns between them
+	for i := 8; i < code.Size-8; i++ {
+		if code.Black(i, 6) != (i%2 == 0) || code.Black(6, i) != (i%2 == 0) {
+			t.Fatalf("Should have drawn a timing pattern at %d", i)
+		}
+	}
+
+	// format information, which reads back as level M and the chosen mask
+	format := 0
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | bit(code.Black(14-i, 8))
+	}
+	format = format<<1 | bit(code.Black(7, 8))
+	format = format<<1 | bit(code.Black(8, 8))
+	format = format<<1 | bit(code.Black(8, 7))
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | bit(code.Black(8, i))
+	}
+	format ^= 0x5412
+	if level, mask := format>>13, format>>10&7; level != 0 || mask != code.Mask {
+		t.Errorf("Should have written level M with mask %d in the format information, got %d and %d", code.Mask, level, mask)
+	}
+
+	if code.Black(-1, 0) || code.Black(code.Size, 0) {
+		t.Error("Should have left the quiet zone light, but it's dark")
+	}
+}
+
+// TestEncodeGolden checks every module, data and error correction included, against codes made by a reference
+// encoder (rsc.io/qr/coding) at the same version, level and mask
+func TestEncodeGolden(t *testing.T) {
+	tests := []struct {
+		text    string
+		level   qr.Level
+		version int
+		mask    int
+		modules []string
+	}{
+		{"coinpayments", qr.L, 1, 2, []string{
+			"#######..#..#.#######",
+			"#.....#.#..#..#.....#",
+			"#.###.#..#....#.###.#",
+			"#.###.#.#..#..#.###.#",
+			"#.###.#...###.#.###.#",
+			"#.....#.###.#.#.....#",
+			"#######.#.#.#.#######",
+			"..........###........",
+			"#####.####..##.#.#.#.",
+			"...#.#....#.######..#",
+			"##...##..#.#.#...#.#.",
+			"###....###...#...####",
+			"#.#####..#.#..###....",
+			"........#.#.##..#...#",
+			"#######.##..##...###.",
+			"#.....#....#.#...##..",
+			"#.###.#.##..#.##...##",
+			"#.###.#.#.##.#####...",
+			"#.###.#.##.##.#......",
+			"#.....#.###.##.#.##..",
+			"#######.#.######.#.#.",
+		}},
+		// two block sizes, so the codewords are interleaved
+		{"bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.015", qr.Q, 5, 2, []string{
+			"#######.##.#..##.#..###.#...#.#######",
+			"#.....#...#..##......#....###.#.....#",
+			"#.###.#..#.....####.###.#.#.#.#.###.#",
+			"#.###.#......###.#.##.#...###.#.###.#",
+			"#.###.#.#.####......###...#...#.###.#",
+			"#.....#.#..#.######.###....#..#.....#",
+			"#######.#.#.#.#.#.#.#.#.#.#.#.#######",
+			".........#.#..#.#...###.#...#........",
+			".#######.##.###...###.####..#..##...#",
+			"###.#..##...##.#.#..##.#.##..#....##.",
+			".####.#........#.#.####..#.#..#...#.#",
+			"##..##.#.#..##.#..#..#......##.#.....",
+			"##.#.##...#..######.#...##...####.#.#",
+			"####.#.#.#.###..#####.##.####....#.##",
+			".###.###...#.#.#####..#..#.#....#####",
+			"#####...#.#.##..#......#..####......#",
+			"#.#..######...###...#######..##..###.",
+			"#.#.#..#..###..#...#.#......#.##.#...",
+			"##...######....#.###.####.##.##..####",
+			"##.#...#..#..#.##.#.##.#....####...#.",
+			"#....##.#.#..##.#.######.##...#.##.##",
+			"###..#.##..#..####.##.##..#.##.#.....",
+			".#...##..#...#..#.##..#.#..###....###",
+			"..###...#.#.#..##..#.##.#.##.###.#.##",
+			"##.##.#.#..#...#...#..#.##....###.##.",
+			"###.#..#######.#..#.#.....#..#.#...##",
+			"#.#.###..##.#####.#...#.#..#.#..#..##",
+			"#......##.###...##.####.#.##.#.##....",
+			"#.#.###..###..#######.###.#########.#",
+			"........##.#...###..##.#...##...###.#",
+			"#######.#...##...#.###..#.###.#.#..##",
+			"#.....#.#.##.#..#########...#...#..#.",
+			"#.###.#.#..#.#..#....#..#.#######.#.#",
+			"#.###.#.##......#.#.###.#.#.###.####.",
+			"#.###.#.###..###...#.#....##...#.#..#",
+			"#.....#.#.###.#.#######....###..#...#",
+			"#######......##.#..#..#..##....#..###",
+		}},
+	}
+	for _, tt := range tests {
+		code, err := qr.Encode(tt.text, tt.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code.Version != tt.version || code.Mask != tt.mask || code.Size != len(tt.modules) {
+			t.Fatalf("Should have encoded %q in version %d with mask %d, got version %d with mask %d", tt.text, tt.version, tt.mask, code.Version, code.Mask)
+		}
+		for y, row := range tt.modules {
+			for x := range row {
+				if code.Black(x, y) != (row[x] == '#') {
+					t.Fatalf("Should have matched the reference encoding of %q at %d, %d, but it doesn't", tt.text, x, y)
+				}
+			}
+		}
+	}
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestRender(t *testing.T) {
+	code, err := qr.Encode("hello", qr.L)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := code.PNG(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := (code.Size + 2*qr.QuietZone) * 3
+	if b := img.Bounds(); b.Dx() != side || b.Dy() != side {
+		t.Fatalf("Should have drawn a %dx%d image, got %v", side, side, b)
+	}
+	if r, _, _, _ := img.At(qr.QuietZone*3, qr.QuietZone*3).RGBA(); r != 0 {
+		t.Error("Should have drawn the top left module dark, but it's light")
+	}
+	if r, _, _, _ := img.At(1, 1).RGBA(); r == 0 {
+		t.Error("Should have left the quiet zone light, but it's dark")
+	}
+
+	svg := string(code.SVG(4))
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `viewBox="0 0 29 29"`) || !strings.Contains(svg, "M4,4h1v1h-1z") {
+		t.Errorf("Should have drawn the modules as svg paths, got %s", svg)
+	}
+}
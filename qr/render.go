@@ -0,0 +1,69 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// QuietZone is the light border, in modules, scanners need around a code
+const QuietZone = 4
+
+// Image returns the code as an image, scale pixels per module, with the quiet zone
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	side := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			if c.Black(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// PNG returns the code as a PNG image, scale pixels per module
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteSVG writes the code as an SVG image, scale pixels per module. The dark modules are a single path, so it scales
+// without gaps between them.
+func (c *Code) WriteSVG(w io.Writer, scale int) error {
+	if scale < 1 {
+		scale = 1
+	}
+	side := c.Size + 2*QuietZone
+
+	var path bytes.Buffer
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+QuietZone, y+QuietZone)
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#ffffff"/><path d="%s" fill="#000000"/></svg>`,
+		side*scale, side*scale, side, side, path.String())
+	return err
+}
+
+// SVG returns the code as an SVG image, scale pixels per module
+func (c *Code) SVG(scale int) []byte {
+	var buf bytes.Buffer
+	c.WriteSVG(&buf, scale)
+	return buf.Bytes()
+}
@@ -0,0 +1,140 @@
+package coinpayments
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoRate is returned when there's no rate for a coin at the time asked for
+var ErrNoRate = errors.New("no rate for coin at that time")
+
+// RateSnapshot is the rates command at a point in time, as the BTC rate of every coin
+type RateSnapshot struct {
+	Time    time.Time         `json:"time"`
+	RateBTC map[string]string `json:"rate_btc"`
+}
+
+// RateSource values coins in fiat at a point in time
+type RateSource interface {
+	// FiatRate returns what one coin was worth in fiat at the time
+	FiatRate(coin, fiat string, at time.Time) (*big.Rat, error)
+}
+
+// RateHistory is a RateSource built from snapshots of the rates command. The API only has current rates, so record
+// a snapshot regularly, ie: every hour, and keep them with AppendRateSnapshot.
+type RateHistory struct {
+	mu        sync.RWMutex
+	snapshots []RateSnapshot
+
+	// MaxAge is how long after the last snapshot its rates still hold. Defaults to an hour.
+	MaxAge time.Duration
+}
+
+// NewRateHistory returns a RateHistory holding the snapshots
+func NewRateHistory(snapshots ...RateSnapshot) *RateHistory {
+	h := &RateHistory{}
+	for _, s := range snapshots {
+		h.Add(s)
+	}
+	return h
+}
+
+// Add adds a snapshot to the history
+func (h *RateHistory) Add(s RateSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.snapshots = append(h.snapshots, s)
+	if n := len(h.snapshots); n > 1 && s.Time.Before(h.snapshots[n-2].Time) {
+		sort.SliceStable(h.snapshots, func(i, j int) bool { return h.snapshots[i].Time.Before(h.snapshots[j].Time) })
+	}
+}
+
+// Record fetches the current rates, adds them to the history and returns the snapshot
+func (h *RateHistory) Record(client *Client) (*RateSnapshot, error) {
+	rates, err := client.CallRates(&RatesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	s := RateSnapshot{Time: time.Now(), RateBTC: make(map[string]string, len(rates))}
+	for coin, r := range rates {
+		s.RateBTC[strings.ToUpper(coin)] = r.RateBTC
+	}
+	h.Add(s)
+	return &s, nil
+}
+
+// FiatRate implements the RateSource interface, with the latest snapshot taken at or before the time. Times outside
+// the recorded range, before the first snapshot or more than MaxAge after the last, have no rate.
+func (h *RateHistory) FiatRate(coin, fiat string, at time.Time) (*big.Rat, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	i := sort.Search(len(h.snapshots), func(i int) bool { return h.snapshots[i].Time.After(at) })
+	if i == 0 {
+		return nil, ErrNoRate
+	}
+	i--
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = time.Hour
+	}
+	if i == len(h.snapshots)-1 && at.Sub(h.snapshots[i].Time) > maxAge {
+		return nil, ErrNoRate
+	}
+	s := h.snapshots[i]
+
+	coinBTC, ok := new(big.Rat).SetString(s.RateBTC[strings.ToUpper(coin)])
+	if !ok {
+		return nil, ErrNoRate
+	}
+	fiatBTC, ok := new(big.Rat).SetString(s.RateBTC[strings.ToUpper(fiat)])
+	if !ok || fiatBTC.Sign() == 0 {
+		return nil, ErrNoRate
+	}
+	return coinBTC.Quo(coinBTC, fiatBTC), nil
+}
+
+// LoadRateHistory reads a RateHistory from a file of JSON lines, one RateSnapshot per line
+func LoadRateHistory(path string) (*RateHistory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &RateHistory{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var s RateSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			return nil, err
+		}
+		h.Add(s)
+	}
+	return h, scanner.Err()
+}
+
+// AppendRateSnapshot appends the snapshot to a file LoadRateHistory can read
+func AppendRateSnapshot(path string, s *RateSnapshot) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
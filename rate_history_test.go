@@ -0,0 +1,86 @@
+package coinpayments_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestRateHistory(t *testing.T) {
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	history := coinpayments.NewRateHistory(
+		coinpayments.RateSnapshot{Time: day.Add(12 * time.Hour), RateBTC: map[string]string{"BTC": "1", "USD": "0.00002", "LTC": "0.002"}},
+		coinpayments.RateSnapshot{Time: day, RateBTC: map[string]string{"BTC": "1", "USD": "0.000025", "LTC": "0.002"}},
+	)
+
+	tests := []struct {
+		coin string
+		at   time.Time
+		want string
+	}{
+		{"BTC", day, "40000.00"},
+		{"BTC", day.Add(6 * time.Hour), "40000.00"},
+		{"btc", day.Add(12 * time.Hour), "50000.00"},
+		{"LTC", day.Add(13 * time.Hour), "100.00"},
+	}
+	for _, tt := range tests {
+		rate, err := history.FiatRate(tt.coin, "USD", tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rate.FloatString(2); got != tt.want {
+			t.Errorf("Should have had %s for %s at %s, got %s", tt.want, tt.coin, tt.at, got)
+		}
+	}
+
+	if _, err := history.FiatRate("DOGE", "USD", day); err != coinpayments.ErrNoRate {
+		t.Errorf("Should have returned ErrNoRate for an unknown coin, got %v", err)
+	}
+	for _, at := range []time.Time{day.Add(-time.Second), day.Add(13*time.Hour + time.Second)} {
+		if _, err := history.FiatRate("BTC", "USD", at); err != coinpayments.ErrNoRate {
+			t.Errorf("Should have returned ErrNoRate outside the recorded range at %s, got %v", at, err)
+		}
+	}
+	history.MaxAge = 24 * time.Hour
+	if _, err := history.FiatRate("BTC", "USD", day.Add(36*time.Hour)); err != nil {
+		t.Errorf("Should have held the last rates for the MaxAge, but it threw error: %s", err.Error())
+	}
+}
+
+func TestRateHistoryFile(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdRates: {`{"error":"ok","result":{"BTC":{"is_fiat":0,"rate_btc":"1.000000000000000000000000"},"USD":{"is_fiat":1,"rate_btc":"0.00002"}}}`},
+	}}
+	client := fakeClient(t, api)
+
+	dir, err := ioutil.TempDir("", "rates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rates.jsonl")
+
+	snapshot, err := coinpayments.NewRateHistory().Record(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := coinpayments.AppendRateSnapshot(path, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := coinpayments.LoadRateHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate, err := history.FiatRate("BTC", "USD", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.FloatString(2) != "50000.00" {
+		t.Errorf("Should have loaded the recorded BTC rate of 50000.00, got %s", rate.FloatString(2))
+	}
+}
@@ -0,0 +1,241 @@
+package coinpayments
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of reconciliation items
+const (
+	ReconTransaction = "transaction"
+	ReconDeposit     = "deposit"
+	ReconWithdrawal  = "withdrawal"
+	ReconTransfer    = "transfer"
+	ReconConversion  = "conversion"
+	ReconOpening     = "opening"
+)
+
+// ReconItem is a single movement of funds our own records know about. Amount is signed, in satoshis, net of fees:
+// positive for funds coming in, negative for funds going out.
+type ReconItem struct {
+	Kind     string `json:"kind"`
+	ID       string `json:"id"`
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+	// Pending items haven't settled yet, so they aren't counted in the expected balance. They're the first place
+	// to look for the cause of a discrepancy.
+	Pending bool   `json:"pending,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+// ReconRecords collects our own records of what should be in the account. Build it from the IPNs, withdrawals and
+// conversions you keep with the Add methods, or append ReconItems directly for anything else.
+type ReconRecords struct {
+	Items []ReconItem
+}
+
+// AddOpening adds the balance of a currency at the start of the records, ie: the closing balance of last month
+func (r *ReconRecords) AddOpening(currency, balance string) error {
+	amount, err := ParseSatoshis(balance)
+	if err != nil {
+		return err
+	}
+	r.Items = append(r.Items, ReconItem{Kind: ReconOpening, ID: "opening", Currency: strings.ToUpper(currency), Amount: amount})
+	return nil
+}
+
+// AddAPI adds the payment of an API IPN, the received coin less the fee. Payments that aren't complete are added as
+// pending.
+func (r *ReconRecords) AddAPI(ipn *IPNAPIResponse) error {
+	amount, err := netAmount(ipn.Amount2, ipn.Fee)
+	if err != nil {
+		return err
+	}
+	satoshis, _ := ParseSatoshis(amount)
+	r.Items = append(r.Items, ReconItem{Kind: ReconTransaction, ID: ipn.TxnID, Currency: strings.ToUpper(ipn.Currency2), Amount: satoshis, Pending: !ipnComplete(ipn.Status)})
+	return nil
+}
+
+// AddDeposit adds a deposit IPN, less the fee. Deposits that aren't complete are added as pending.
+func (r *ReconRecords) AddDeposit(ipn *IPNDepositResponse) error {
+	amount, err := netAmount(ipn.Amount, ipn.Fee)
+	if err != nil {
+		return err
+	}
+	satoshis, _ := ParseSatoshis(amount)
+	r.Items = append(r.Items, ReconItem{Kind: ReconDeposit, ID: ipn.TxnID, Currency: strings.ToUpper(ipn.Currency), Amount: satoshis, Pending: !ipnComplete(ipn.Status)})
+	return nil
+}
+
+// AddWithdrawal adds a withdrawal tracked by the WithdrawalTracker. Cancelled withdrawals are left out, ones that
+// weren't sent yet are added as pending. The network fee is taken out of the amount by the API unless AddTxFee was
+// set, in which case it isn't known here and the item says so.
+func (r *ReconRecords) AddWithdrawal(rec *WithdrawalRecord) error {
+	if rec.Status == WithdrawalStatusCancelled {
+		return nil
+	}
+	amount, err := ParseSatoshis(rec.Request.Amount)
+	if err != nil {
+		return err
+	}
+
+	item := ReconItem{Kind: ReconWithdrawal, ID: rec.ID, Currency: strings.ToUpper(rec.Request.Currency), Amount: -amount, Pending: rec.Status != WithdrawalStatusComplete}
+	if rec.Request.Currency2 != "" {
+		// the amount is worth of currency, paid for in currency2, so what left the currency2 balance isn't known
+		item.Currency, item.Amount, item.Pending = strings.ToUpper(rec.Request.Currency2), 0, true
+		item.Note = fmt.Sprintf("%s %s paid for in %s", rec.Request.Amount, strings.ToUpper(rec.Request.Currency), item.Currency)
+	} else if rec.Request.AddTxFee != 0 {
+		item.Note = "network fee added on top of the amount"
+	}
+	r.Items = append(r.Items, item)
+	return nil
+}
+
+// AddTransfer adds a transfer to another merchant, which settles straight away
+func (r *ReconRecords) AddTransfer(id string, req *WithdrawalRequest) error {
+	amount, err := ParseSatoshis(req.Amount)
+	if err != nil {
+		return err
+	}
+	r.Items = append(r.Items, ReconItem{Kind: ReconTransfer, ID: id, Currency: strings.ToUpper(req.Currency), Amount: -amount})
+	return nil
+}
+
+// AddConversion adds a conversion, as the amount leaving the from currency and received arriving in the to currency.
+// Leave received empty for conversions that haven't completed, their to side is then added as pending.
+func (r *ReconRecords) AddConversion(rec *ConversionRecord, received string) error {
+	amount, err := ParseSatoshis(rec.Amount)
+	if err != nil {
+		return err
+	}
+	r.Items = append(r.Items, ReconItem{Kind: ReconConversion, ID: rec.ID, Currency: strings.ToUpper(rec.From), Amount: -amount})
+
+	to := ReconItem{Kind: ReconConversion, ID: rec.ID, Currency: strings.ToUpper(rec.To), Pending: true, Note: "conversion not completed"}
+	if received != "" {
+		if to.Amount, err = ParseSatoshis(received); err != nil {
+			return err
+		}
+		to.Pending, to.Note = false, ""
+	}
+	r.Items = append(r.Items, to)
+	return nil
+}
+
+// ReconDiscrepancy is a currency whose balance doesn't match our records
+type ReconDiscrepancy struct {
+	Currency   string `json:"currency"`
+	Actual     string `json:"actual"`
+	Expected   string `json:"expected"`
+	Difference string `json:"difference"` // actual minus expected
+	// Explained is set when the candidates add up to exactly the difference
+	Explained bool `json:"explained"`
+	// Candidates are the items that explain the difference if Explained is set, otherwise every pending item of
+	// the currency.
+	Candidates []ReconItem `json:"candidates,omitempty"`
+}
+
+// ReconReport is the result of a reconciliation
+type ReconReport struct {
+	Time          time.Time          `json:"time"`
+	Currencies    int                `json:"currencies"`
+	Discrepancies []ReconDiscrepancy `json:"discrepancies"`
+}
+
+// Balanced returns whether every balance matched our records
+func (r *ReconReport) Balanced() bool {
+	return len(r.Discrepancies) == 0
+}
+
+// Reconcile fetches the balances of the account and compares them against the records
+func (c *Client) Reconcile(records *ReconRecords) (*ReconReport, error) {
+	balances, err := c.CallBalances(&BalancesRequest{All: "1"})
+	if err != nil {
+		return nil, err
+	}
+	report := ReconcileBalances(balances, records)
+	report.Time = time.Now()
+	return report, nil
+}
+
+// ReconcileBalances compares balances, as returned by CallBalances, against the records, and returns the
+// currencies that don't match, sorted by currency.
+func ReconcileBalances(balances map[string]BalancesResult, records *ReconRecords) *ReconReport {
+	actual := map[string]int64{}
+	for coin, b := range balances {
+		amount, err := ParseSatoshis(b.Balancef)
+		if err != nil {
+			amount = int64(b.Balance)
+		}
+		actual[strings.ToUpper(coin)] = amount
+	}
+
+	expected := map[string]int64{}
+	items := map[string][]ReconItem{}
+	for _, item := range records.Items {
+		items[item.Currency] = append(items[item.Currency], item)
+		if !item.Pending {
+			expected[item.Currency] += item.Amount
+		}
+	}
+
+	currencies := map[string]bool{}
+	for coin := range actual {
+		currencies[coin] = true
+	}
+	for coin := range items {
+		currencies[coin] = true
+	}
+
+	report := &ReconReport{Currencies: len(currencies)}
+	for coin := range currencies {
+		diff := actual[coin] - expected[coin]
+		if diff == 0 {
+			continue
+		}
+		d := ReconDiscrepancy{
+			Currency:   coin,
+			Actual:     FormatSatoshis(actual[coin]),
+			Expected:   FormatSatoshis(expected[coin]),
+			Difference: FormatSatoshis(diff),
+		}
+		d.Candidates, d.Explained = explainDiscrepancy(items[coin], diff)
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool { return report.Discrepancies[i].Currency < report.Discrepancies[j].Currency })
+	return report
+}
+
+// explainDiscrepancy looks for the items behind a difference between the actual and expected balance. A pending item
+// that already settled moves the balance by its amount, a settled item that never made it to the account moves it
+// by minus its amount.
+func explainDiscrepancy(items []ReconItem, diff int64) ([]ReconItem, bool) {
+	effect := func(item ReconItem) int64 {
+		if item.Pending {
+			return item.Amount
+		}
+		return -item.Amount
+	}
+
+	// a single item accounting for the whole difference
+	for _, item := range items {
+		if item.Kind != ReconOpening && effect(item) == diff {
+			return []ReconItem{item}, true
+		}
+	}
+
+	// all the pending items together
+	var pending []ReconItem
+	var sum int64
+	for _, item := range items {
+		if item.Pending {
+			pending = append(pending, item)
+			sum += item.Amount
+		}
+	}
+	if len(pending) > 0 && sum == diff {
+		return pending, true
+	}
+	return pending, false
+}
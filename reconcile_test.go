@@ -0,0 +1,84 @@
+package coinpayments_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func reconRecords(t *testing.T) *coinpayments.ReconRecords {
+	records := &coinpayments.ReconRecords{}
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(records.AddOpening("btc", "1"))
+	must(records.AddAPI(&coinpayments.IPNAPIResponse{Status: "100", TxnID: "TX1", Currency2: "BTC", Amount2: "0.5", Fee: "0.0025"}))
+	must(records.AddAPI(&coinpayments.IPNAPIResponse{Status: "1", TxnID: "TX2", Currency2: "BTC", Amount2: "0.2", Fee: "0.001"}))
+	must(records.AddDeposit(&coinpayments.IPNDepositResponse{Status: "100", TxnID: "DP1", Currency: "LTC", Amount: "10", Fee: "0.05"}))
+	must(records.AddWithdrawal(&coinpayments.WithdrawalRecord{ID: "CW1", Status: coinpayments.WithdrawalStatusComplete, Request: coinpayments.WithdrawalRequest{Amount: "0.3", Currency: "BTC"}}))
+	must(records.AddWithdrawal(&coinpayments.WithdrawalRecord{ID: "CW2", Status: coinpayments.WithdrawalStatusCancelled, Request: coinpayments.WithdrawalRequest{Amount: "5", Currency: "BTC"}}))
+	must(records.AddTransfer("CT1", &coinpayments.WithdrawalRequest{Amount: "1", Currency: "LTC"}))
+	must(records.AddConversion(&coinpayments.ConversionRecord{ID: "CV1", From: "LTC", To: "BTC", Amount: "4"}, "0.05"))
+	return records
+}
+
+func TestReconcileBalances(t *testing.T) {
+	// BTC: 1 + 0.4975 - 0.3 + 0.05 = 1.2475, LTC: 9.95 - 1 - 4 = 4.95
+	balances := map[string]coinpayments.BalancesResult{
+		"BTC": {Balance: 124750000, Balancef: "1.24750000"},
+		"LTC": {Balance: 495000000, Balancef: "4.95000000"},
+	}
+	report := coinpayments.ReconcileBalances(balances, reconRecords(t))
+	if !report.Balanced() {
+		t.Fatalf("Should have matched the balances, got %+v", report.Discrepancies)
+	}
+	if report.Currencies != 2 {
+		t.Errorf("Should have checked 2 currencies, got %d", report.Currencies)
+	}
+}
+
+func TestReconcileExplainsDiscrepancies(t *testing.T) {
+	balances := map[string]coinpayments.BalancesResult{
+		// the pending TX2 already settled
+		"BTC": {Balance: 144650000, Balancef: "1.44650000"},
+		// the transfer never left
+		"LTC": {Balance: 595000000, Balancef: "5.95000000"},
+		// nothing explains this one
+		"ETH": {Balance: 100000000, Balancef: "1.00000000"},
+	}
+	report := coinpayments.ReconcileBalances(balances, reconRecords(t))
+	if len(report.Discrepancies) != 3 {
+		t.Fatalf("Should have found 3 discrepancies, got %+v", report.Discrepancies)
+	}
+
+	btc, eth, ltc := report.Discrepancies[0], report.Discrepancies[1], report.Discrepancies[2]
+	if btc.Currency != "BTC" || btc.Difference != "0.19900000" || !btc.Explained || len(btc.Candidates) != 1 || btc.Candidates[0].ID != "TX2" {
+		t.Errorf("Should have explained the BTC discrepancy with TX2, got %+v", btc)
+	}
+	if eth.Currency != "ETH" || eth.Explained || eth.Expected != "0.00000000" || eth.Actual != "1.00000000" {
+		t.Errorf("Should have left the ETH discrepancy unexplained, got %+v", eth)
+	}
+	if ltc.Currency != "LTC" || ltc.Difference != "1.00000000" || !ltc.Explained || ltc.Candidates[0].ID != "CT1" {
+		t.Errorf("Should have explained the LTC discrepancy with CT1, got %+v", ltc)
+	}
+}
+
+func TestClientReconcile(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdBalances: {`{"error":"ok","result":{"BTC":{"balance":124750000,"balancef":"1.24750000"},"LTC":{"balance":495000000,"balancef":"4.95000000"}}}`},
+	}}
+	client := fakeClient(t, api)
+
+	report, err := client.Reconcile(reconRecords(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Balanced() || report.Time.IsZero() {
+		t.Errorf("Should have balanced the report, got %+v", report)
+	}
+	if calls := api.callsFor(coinpayments.CmdBalances); len(calls) != 1 || calls[0].Get("all") != "1" {
+		t.Errorf("Should have fetched the balances of every coin, got %v", calls)
+	}
+}
@@ -0,0 +1,312 @@
+package coinpayments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by the RefundManager
+var (
+	ErrPaymentNotComplete       = errors.New("payment is not complete")
+	ErrRefundTooLarge           = errors.New("refund is more than what's left to refund of the payment")
+	ErrMissingRefundDestination = errors.New("refund needs an address or $PayByName tag")
+	ErrRefundNotSending         = errors.New("refund is not waiting on the outcome of its withdrawal")
+)
+
+// RefundRequest asks for part or all of a completed payment to be sent back to the buyer, to an Address or PBNTag.
+// Leave Amount and FiatAmount empty to refund everything left of the payment. FiatAmount is worth of Fiat, ie:
+// "25.00" USD, converted at the current rate.
+type RefundRequest struct {
+	TxnID      string
+	Address    string
+	DestTag    string // for coins needing a destination tag or memo
+	PBNTag     string
+	Amount     string
+	FiatAmount string
+	Fiat       string
+	Reason     string
+}
+
+// RefundManager refunds completed payments with withdrawals, and keeps every refund linked to its payment in a
+// ResolutionStore. It can share the store with a PaymentResolver, so refunds of overpayments count against what's
+// left to refund.
+type RefundManager struct {
+	mu     sync.Mutex
+	client *Client
+	store  ResolutionStore
+	now    func() time.Time
+
+	// ValidateAddress checks a refund address, with its destination tag or memo, is valid for the coin before
+	// anything is sent. It defaults to coinpayments.ValidateAddress, set it to nil to skip the check.
+	ValidateAddress func(coin, address, destTag string) error
+	// Rates, if set, values fiat refunds. Otherwise the current rates are fetched from the API.
+	Rates RateSource
+}
+
+// NewRefundManager returns a RefundManager for the client, keeping refunds in the store
+func NewRefundManager(client *Client, store ResolutionStore) *RefundManager {
+	return &RefundManager{client: client, store: store, now: time.Now, ValidateAddress: ValidateAddress}
+}
+
+// Refund sends a refund of a completed payment. The amount can't be more than what was received for the payment less
+// what was already refunded of it. If the API refuses the withdrawal, the refund is stored as failed and returned with
+// the error. After other errors, ie: a timeout, the withdrawal may have gone out, so the refund stays sending and keeps
+// counting against the payment until ResolveSending.
+func (m *RefundManager) Refund(req *RefundRequest) (*Refund, error) {
+	if req.Address == "" && req.PBNTag == "" {
+		return nil, ErrMissingRefundDestination
+	}
+
+	info, err := m.client.CallGetTxInfo(&TxInfoRequest{TxID: req.TxnID})
+	if err != nil {
+		return nil, err
+	}
+	statusText, _ := txInfoString(info.Result, "status")
+	if status, err := strconv.Atoi(statusText); err != nil || status < 100 {
+		return nil, ErrPaymentNotComplete
+	}
+	coin, _ := txInfoString(info.Result, "coin")
+	receivedText, _ := txInfoString(info.Result, "receivedf")
+	received, err := ParseSatoshis(receivedText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid receivedf %q", receivedText)
+	}
+
+	if req.Address != "" && m.ValidateAddress != nil {
+		if err := m.ValidateAddress(coin, req.Address, req.DestTag); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refunds, err := m.store.PaymentRefunds(req.TxnID)
+	if err != nil {
+		return nil, err
+	}
+	remaining := received
+	for _, r := range refunds {
+		// failed refunds were refused by the API, sending ones may have gone out and count
+		if r.Status == RefundFailed || r.Status == RefundCancelled {
+			continue
+		}
+		amount, _ := ParseSatoshis(r.Amount)
+		remaining -= amount
+	}
+
+	var amount int64
+	switch {
+	case req.FiatAmount != "":
+		if amount, err = m.fiatToCoin(req.FiatAmount, req.Fiat, coin); err != nil {
+			return nil, err
+		}
+	case req.Amount != "":
+		if amount, err = ParseSatoshis(req.Amount); err != nil {
+			return nil, err
+		}
+	default:
+		amount = remaining
+	}
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if amount > remaining {
+		return nil, ErrRefundTooLarge
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := m.now()
+	refund := &Refund{
+		ID:        hex.EncodeToString(id),
+		TxnID:     req.TxnID,
+		Currency:  strings.ToUpper(coin),
+		Amount:    FormatSatoshis(amount),
+		Address:   req.Address,
+		DestTag:   req.DestTag,
+		PBNTag:    req.PBNTag,
+		Reason:    req.Reason,
+		Status:    RefundSending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.FiatAmount != "" {
+		refund.FiatAmount, refund.Fiat = req.FiatAmount, strings.ToUpper(req.Fiat)
+	}
+	// stored as sending before the withdrawal, so a crash in between can't lose track of a refund that may have been
+	// sent, and PaymentResolver.ProcessRefunds, which only sends queued refunds, leaves it alone
+	if err := m.store.SaveRefund(refund); err != nil {
+		return nil, err
+	}
+
+	res, sendErr := m.client.CallCreateWithdrawal(&WithdrawalRequest{
+		Amount:      refund.Amount,
+		Currency:    refund.Currency,
+		Address:     refund.Address,
+		DestTag:     refund.DestTag,
+		PBNTag:      refund.PBNTag,
+		AutoConfirm: 1,
+		Note:        "refund of payment " + refund.TxnID,
+	})
+	switch {
+	case sendErr == nil:
+		refund.Status, refund.WithdrawalID = RefundSent, res.ID
+	case callRefused(sendErr):
+		refund.Status, refund.Error = RefundFailed, sendErr.Error()
+	default:
+		refund.Error = sendErr.Error()
+	}
+	refund.UpdatedAt = m.now()
+	if err := m.store.SaveRefund(refund); err != nil {
+		return nil, err
+	}
+	return refund, sendErr
+}
+
+// fiatToCoin converts a fiat amount to satoshis of the coin, rounded down so the buyer is never sent more than asked
+func (m *RefundManager) fiatToCoin(fiatAmount, fiat, coin string) (int64, error) {
+	if fiat == "" {
+		return 0, errors.New("fiat refund needs a fiat currency")
+	}
+	value, ok := new(big.Rat).SetString(fiatAmount)
+	if !ok {
+		return 0, ErrInvalidAmount
+	}
+
+	rates, at := m.Rates, m.now()
+	if rates == nil {
+		history := NewRateHistory()
+		snapshot, err := history.Record(m.client)
+		if err != nil {
+			return 0, err
+		}
+		rates, at = history, snapshot.Time
+	}
+	rate, err := rates.FiatRate(coin, fiat, at)
+	if err != nil {
+		return 0, err
+	}
+	if rate.Sign() <= 0 {
+		return 0, ErrNoRate
+	}
+
+	value.Quo(value, rate)
+	value.Mul(value, big.NewRat(100000000, 1))
+	satoshis := new(big.Int).Quo(value.Num(), value.Denom())
+	if !satoshis.IsInt64() {
+		return 0, ErrInvalidAmount
+	}
+	return satoshis.Int64(), nil
+}
+
+// Refunds returns every refund of the payment, oldest first
+func (m *RefundManager) Refunds(txnID string) ([]Refund, error) {
+	return m.store.PaymentRefunds(txnID)
+}
+
+// ResolveSending settles a refund whose withdrawal had an unknown outcome, once you've checked the withdrawal history.
+// Pass the id of its withdrawal if it went out, which tracks it as sent, or "" if it didn't, which fails it so it no
+// longer counts against the payment.
+func (m *RefundManager) ResolveSending(id, withdrawalID string) (*Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refund, err := m.store.Refund(id)
+	if err != nil {
+		return nil, err
+	}
+	if refund.Status != RefundSending {
+		return nil, ErrRefundNotSending
+	}
+	if withdrawalID == "" {
+		refund.Status = RefundFailed
+	} else {
+		refund.Status, refund.WithdrawalID, refund.Error = RefundSent, withdrawalID, ""
+	}
+	refund.UpdatedAt = m.now()
+	if err := m.store.SaveRefund(refund); err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// HandleWithdrawal updates the refund of a withdrawal IPN. IPNs for withdrawals that aren't refunds are ignored, so
+// it can be used from IPNHandler.OnWithdrawal alongside other handlers.
+func (m *RefundManager) HandleWithdrawal(ipn *IPNWithdrawalResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refund, err := m.store.RefundForWithdrawal(ipn.ID)
+	if err == ErrRefundNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if m.update(refund, ipn.Status, ipn.TxnID) {
+		return m.store.SaveRefund(refund)
+	}
+	return nil
+}
+
+// Poll checks the withdrawal of every sent refund with the API, for when IPNs aren't used, and returns the refunds
+// that completed or were cancelled.
+func (m *RefundManager) Poll() ([]Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent, err := m.store.Refunds(RefundSent)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []Refund
+	for i := range sent {
+		refund := &sent[i]
+		info, err := m.client.CallGetWithdrawalInfo(refund.WithdrawalID)
+		if err != nil {
+			return updated, err
+		}
+		if !m.update(refund, strconv.Itoa(info.Status), info.SendTxID) {
+			continue
+		}
+		if err := m.store.SaveRefund(refund); err != nil {
+			return updated, err
+		}
+		updated = append(updated, *refund)
+	}
+	return updated, nil
+}
+
+// update applies a withdrawal status to the refund, and returns whether it changed. IPNs can arrive out of order, so
+// a completed or cancelled refund keeps its status, ie: a late cancelled IPN won't undo a complete one.
+func (m *RefundManager) update(refund *Refund, status, sendTxID string) bool {
+	if (refund.Status == RefundComplete && status != WithdrawalStatusComplete) || refund.Status == RefundCancelled {
+		return false
+	}
+	switch status {
+	case WithdrawalStatusComplete:
+		if refund.Status == RefundComplete && (sendTxID == "" || refund.SendTxID == sendTxID) {
+			return false
+		}
+		refund.Status = RefundComplete
+		if sendTxID != "" {
+			refund.SendTxID = sendTxID
+		}
+	case WithdrawalStatusCancelled:
+		refund.Status = RefundCancelled
+	default:
+		return false
+	}
+	refund.UpdatedAt = m.now()
+	return true
+}
@@ -0,0 +1,303 @@
+package coinpayments_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+	"github.com/jeffwalsh/go-coinpayments/address"
+)
+
+// buyerAddress is a valid BTC address to refund to
+const buyerAddress = "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"
+
+const completedTxInfo = `{"error":"ok","result":{"status":100,"status_text":"Complete","coin":"BTC","amountf":"0.1","receivedf":"0.1"}}`
+
+func TestRefundManagerRefund(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo:        {completedTxInfo},
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1,"amount":"0.04"}}`, `{"error":"ok","result":{"id":"CW2","status":1,"amount":"0.06"}}`},
+	}}
+	store := coinpayments.NewMemoryResolutionStore()
+	manager := coinpayments.NewRefundManager(fakeClient(t, api), store)
+	manager.ValidateAddress = func(coin, address, destTag string) error {
+		if coin != "BTC" || address == "bad" {
+			return errors.New("invalid address")
+		}
+		return nil
+	}
+
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1"}); err != coinpayments.ErrMissingRefundDestination {
+		t.Fatalf("Should have returned ErrMissingRefundDestination, got %v", err)
+	}
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: "bad"}); err == nil {
+		t.Fatal("Should have refused an invalid address, but it didn't")
+	}
+
+	partial, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress, Amount: "0.04", Reason: "damaged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial.Status != coinpayments.RefundSent || partial.WithdrawalID != "CW1" || partial.Amount != "0.04000000" || partial.Currency != "BTC" {
+		t.Fatalf("Should have sent the partial refund, got %+v", partial)
+	}
+
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress, Amount: "0.07"}); err != coinpayments.ErrRefundTooLarge {
+		t.Fatalf("Should have refused a refund over what's left, got %v", err)
+	}
+
+	// the rest of the payment, to a $PayByName tag
+	rest, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", PBNTag: "$buyer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest.Amount != "0.06000000" || rest.WithdrawalID != "CW2" {
+		t.Fatalf("Should have refunded the rest of the payment, got %+v", rest)
+	}
+
+	calls := api.callsFor(coinpayments.CmdCreateWithdrawal)
+	if len(calls) != 2 || calls[0].Get("address") != buyerAddress || calls[0].Get("amount") != "0.04000000" || calls[1].Get("pbntag") != "$buyer" {
+		t.Fatalf("Should have withdrawn both refunds to their destinations, got %v", calls)
+	}
+
+	refunds, err := manager.Refunds("TX1")
+	if err != nil || len(refunds) != 2 {
+		t.Fatalf("Should have linked both refunds to the payment, got %+v, %v", refunds, err)
+	}
+}
+
+func TestRefundManagerPaymentNotComplete(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo: {`{"error":"ok","result":{"status":1,"coin":"BTC","receivedf":"0.05"}}`},
+	}}
+	manager := coinpayments.NewRefundManager(fakeClient(t, api), coinpayments.NewMemoryResolutionStore())
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress}); err != coinpayments.ErrPaymentNotComplete {
+		t.Errorf("Should not have refunded a pending payment, got %v", err)
+	}
+}
+
+func TestRefundManagerFiat(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo:        {completedTxInfo},
+		coinpayments.CmdRates:            {`{"error":"ok","result":{"BTC":{"is_fiat":0,"rate_btc":"1"},"USD":{"is_fiat":1,"rate_btc":"0.00003"}}}`},
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1}}`},
+	}}
+	manager := coinpayments.NewRefundManager(fakeClient(t, api), coinpayments.NewMemoryResolutionStore())
+
+	refund, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress, FiatAmount: "10", Fiat: "usd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10 USD at 33333.33 USD per BTC, rounded down
+	if refund.Amount != "0.00030000" || refund.FiatAmount != "10" || refund.Fiat != "USD" {
+		t.Errorf("Should have converted the fiat amount of the refund, got %+v", refund)
+	}
+}
+
+func TestRefundManagerValidatesDestTag(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo:        {`{"error":"ok","result":{"status":100,"coin":"XRP","receivedf":"20"}}`},
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1}}`},
+	}}
+	manager := coinpayments.NewRefundManager(fakeClient(t, api), coinpayments.NewMemoryResolutionStore())
+
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh"}); err == nil {
+		t.Error("Should have refused an XRP refund without a destination tag, but it didn't")
+	}
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: "X7ZWqnQwuGw9k5vjMDavafRdDPdECujodEGV7rLmPrBnVLb", DestTag: "54321"}); err == nil {
+		t.Error("Should have refused a destination tag conflicting with the X-address, but it didn't")
+	}
+	if len(api.callsFor(coinpayments.CmdCreateWithdrawal)) != 0 {
+		t.Fatal("Should not have sent a withdrawal for the invalid refunds")
+	}
+
+	refund, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", DestTag: "12345"})
+	if err != nil {
+		t.Fatalf("Should have refunded to the address with its tag, but it threw error: %s", err)
+	}
+	if calls := api.callsFor(coinpayments.CmdCreateWithdrawal); refund.Status != coinpayments.RefundSent || len(calls) != 1 || calls[0].Get("dest_tag") != "12345" {
+		t.Errorf("Should have sent the refund with its destination tag, got %+v, %v", refund, calls)
+	}
+}
+
+func TestRefundManagerFailedWithdrawal(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo:        {completedTxInfo},
+		coinpayments.CmdCreateWithdrawal: {`{"error":"Insufficient funds"}`, `{"error":"ok","result":{"id":"CW2","status":1}}`},
+	}}
+	manager := coinpayments.NewRefundManager(fakeClient(t, api), coinpayments.NewMemoryResolutionStore())
+
+	failed, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress})
+	if err == nil || failed == nil || failed.Status != coinpayments.RefundFailed {
+		t.Fatalf("Should have failed the refund, got %+v, %v", failed, err)
+	}
+
+	// a failed refund doesn't count against what's left
+	refund, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress})
+	if err != nil || refund.Amount != "0.10000000" {
+		t.Errorf("Should have refunded the full amount again, got %+v, %v", refund, err)
+	}
+}
+
+func TestRefundManagerInvalidAddress(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo:        {completedTxInfo},
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1}}`},
+	}}
+	client := fakeClient(t, api)
+	client.ValidateAddresses = true
+	manager := coinpayments.NewRefundManager(client, coinpayments.NewMemoryResolutionStore())
+	manager.ValidateAddress = nil
+
+	// the client's address check stops the withdrawal, so nothing went out
+	failed, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: "1BoatSLRHtKNngkdXEeobR76b53LETtpyU"})
+	if err != address.ErrInvalidChecksum || failed == nil || failed.Status != coinpayments.RefundFailed {
+		t.Fatalf("Should have failed the refund to a malformed address, got %+v, %v", failed, err)
+	}
+	if len(api.callsFor(coinpayments.CmdCreateWithdrawal)) != 0 {
+		t.Fatal("Should not have sent a withdrawal to the malformed address")
+	}
+	if refund, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress}); err != nil || refund.Amount != "0.10000000" {
+		t.Errorf("Should not have counted the failed refund against the payment, got %+v, %v", refund, err)
+	}
+}
+
+func TestRefundManagerTracking(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo:         {completedTxInfo},
+		coinpayments.CmdCreateWithdrawal:  {`{"error":"ok","result":{"id":"CW1","status":1}}`, `{"error":"ok","result":{"id":"CW2","status":1}}`},
+		coinpayments.CmdGetWithdrawalInfo: {`{"error":"ok","result":{"status":2,"coin":"BTC","amountf":"0.05","send_txid":"chain2"}}`},
+	}}
+	manager := coinpayments.NewRefundManager(fakeClient(t, api), coinpayments.NewMemoryResolutionStore())
+
+	first, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress, Amount: "0.05"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress, Amount: "0.05"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// IPNs for withdrawals that aren't refunds are ignored
+	if err := manager.HandleWithdrawal(&coinpayments.IPNWithdrawalResponse{ID: "OTHER", Status: coinpayments.WithdrawalStatusComplete}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.HandleWithdrawal(&coinpayments.IPNWithdrawalResponse{ID: "CW1", Status: coinpayments.WithdrawalStatusComplete, TxnID: "chain1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := manager.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || updated[0].WithdrawalID != "CW2" || updated[0].Status != coinpayments.RefundComplete || updated[0].SendTxID != "chain2" {
+		t.Fatalf("Should have completed the polled refund, got %+v", updated)
+	}
+	if calls := api.callsFor(coinpayments.CmdGetWithdrawalInfo); len(calls) != 1 || calls[0].Get("id") != "CW2" {
+		t.Errorf("Should have only polled the sent refund, got %v", calls)
+	}
+
+	refunds, _ := manager.Refunds("TX1")
+	for _, r := range refunds {
+		if r.ID == first.ID && (r.Status != coinpayments.RefundComplete || r.SendTxID != "chain1") {
+			t.Errorf("Should have completed the refund from the IPN, got %+v", r)
+		}
+	}
+}
+
+func TestRefundManagerOutOfOrderIPNs(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdGetTxInfo:        {completedTxInfo},
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CW1","status":1}}`, `{"error":"ok","result":{"id":"CW2","status":1}}`},
+	}}
+	manager := coinpayments.NewRefundManager(fakeClient(t, api), coinpayments.NewMemoryResolutionStore())
+
+	for i := 0; i < 2; i++ {
+		if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress, Amount: "0.05"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ipns := []coinpayments.IPNWithdrawalResponse{
+		{ID: "CW1", Status: coinpayments.WithdrawalStatusComplete, TxnID: "chain1"},
+		{ID: "CW1", Status: coinpayments.WithdrawalStatusPending},
+		{ID: "CW1", Status: coinpayments.WithdrawalStatusCancelled},
+		{ID: "CW2", Status: coinpayments.WithdrawalStatusCancelled},
+		{ID: "CW2", Status: coinpayments.WithdrawalStatusComplete, TxnID: "chain2"},
+	}
+	for i := range ipns {
+		if err := manager.HandleWithdrawal(&ipns[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refunds, _ := manager.Refunds("TX1")
+	for _, r := range refunds {
+		switch r.WithdrawalID {
+		case "CW1":
+			if r.Status != coinpayments.RefundComplete || r.SendTxID != "chain1" {
+				t.Errorf("Should have kept the refund complete, got %+v", r)
+			}
+		case "CW2":
+			if r.Status != coinpayments.RefundCancelled || r.SendTxID != "" {
+				t.Errorf("Should have kept the refund cancelled, got %+v", r)
+			}
+		}
+	}
+}
+
+// cmdTimeoutAPI answers like its fakeAPI, but calls of cmd time out as if the response never arrived
+type cmdTimeoutAPI struct {
+	*fakeAPI
+	cmd string
+}
+
+func (f cmdTimeoutAPI) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if values, _ := url.ParseQuery(string(body)); values.Get("cmd") == f.cmd {
+		return nil, errors.New("i/o timeout")
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return f.fakeAPI.Do(req)
+}
+
+func TestRefundManagerAmbiguousWithdrawal(t *testing.T) {
+	api := cmdTimeoutAPI{&fakeAPI{responses: map[string][]string{coinpayments.CmdGetTxInfo: {completedTxInfo}}}, coinpayments.CmdCreateWithdrawal}
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey"}, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := coinpayments.NewMemoryResolutionStore()
+	manager := coinpayments.NewRefundManager(client, store)
+	resolver := coinpayments.NewPaymentResolver(client, store)
+
+	refund, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress})
+	if err == nil || refund == nil || refund.Status != coinpayments.RefundSending || refund.Error == "" {
+		t.Fatalf("Should have kept the refund that timed out sending, got %+v, %v", refund, err)
+	}
+
+	// it may have gone out, so it still counts, and the resolver doesn't send it again
+	if _, err := manager.Refund(&coinpayments.RefundRequest{TxnID: "TX1", Address: buyerAddress, Amount: "0.01"}); err != coinpayments.ErrRefundTooLarge {
+		t.Fatalf("Should have counted the refund that may have gone out, got %v", err)
+	}
+	if processed, err := resolver.ProcessRefunds(); err != nil || len(processed) != 0 {
+		t.Fatalf("Should not have sent the refund from the resolver, got %+v, %v", processed, err)
+	}
+
+	if _, err := manager.ResolveSending(refund.ID, ""); err != nil {
+		t.Fatalf("Should have resolved the refund, but it threw error: %s", err.Error())
+	}
+	if saved, _ := store.Refund(refund.ID); saved.Status != coinpayments.RefundFailed {
+		t.Fatalf("Should have failed the refund that didn't go out, got %+v", saved)
+	}
+	if _, err := manager.ResolveSending(refund.ID, "CW1"); err != coinpayments.ErrRefundNotSending {
+		t.Fatalf("Should only resolve sending refunds, got %v", err)
+	}
+}
@@ -0,0 +1,459 @@
+package coinpayments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payment classes
+const (
+	PaymentUnderpaid = "underpaid"
+	PaymentExact     = "exact"
+	PaymentOverpaid  = "overpaid"
+)
+
+// Refund statuses
+const (
+	RefundAwaitingAddress = "awaiting_address"
+	RefundQueued          = "queued"
+	RefundSending         = "sending" // saved before the withdrawal is made, and kept if its outcome is unknown
+	RefundSent            = "sent"
+	RefundFailed          = "failed"
+	RefundComplete        = "complete"  // the withdrawal was sent out
+	RefundCancelled       = "cancelled" // the withdrawal was cancelled
+)
+
+// Errors returned by the PaymentResolver
+var (
+	ErrResolutionNotFound = errors.New("payment resolution not found")
+	ErrRefundNotFound     = errors.New("refund not found")
+	ErrRefundNotQueued    = errors.New("refund is not waiting to be sent")
+)
+
+// PaymentTolerance is how far off a payment can be and still count as exact. Amounts are decimals in the coin.
+type PaymentTolerance struct {
+	Under string
+	Over  string
+}
+
+// PaymentResolution is how a payment was classified, and what was done about it
+type PaymentResolution struct {
+	TxnID      string    `json:"txn_id"`
+	Currency   string    `json:"currency"`
+	Expected   string    `json:"expected"`
+	Received   string    `json:"received"`
+	Difference string    `json:"difference"` // received minus expected
+	Class      string    `json:"class"`
+	TopUpTxnID string    `json:"top_up_txn_id,omitempty"` // the transaction created for the shortfall
+	RefundID   string    `json:"refund_id,omitempty"`     // the refund queued for the excess
+	ResolvedAt time.Time `json:"resolved_at"`
+
+	// TopUpPending is set while the top-up is being created, and stays set if the outcome of the call is unknown, so
+	// it's never created twice. TopUpError is why the top-up failed; top-ups the API refused are retried on the next
+	// IPN of the payment.
+	TopUpPending bool   `json:"top_up_pending,omitempty"`
+	TopUpError   string `json:"top_up_error,omitempty"`
+}
+
+// Refund is coin sent back to the buyer of a payment with a withdrawal, ie: the excess of an overpayment
+type Refund struct {
+	ID           string    `json:"id"`
+	TxnID        string    `json:"txn_id"` // the payment being refunded
+	Currency     string    `json:"currency"`
+	Amount       string    `json:"amount"`
+	Address      string    `json:"address,omitempty"`
+	DestTag      string    `json:"dest_tag,omitempty"`
+	PBNTag       string    `json:"pbntag,omitempty"`
+	FiatAmount   string    `json:"fiat_amount,omitempty"` // set when the refund was asked for in fiat
+	Fiat         string    `json:"fiat,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Status       string    `json:"status"`
+	WithdrawalID string    `json:"withdrawal_id,omitempty"`
+	SendTxID     string    `json:"send_txid,omitempty"` // the blockchain txid, once the withdrawal was sent
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ResolutionStore persists resolutions and refunds. Resolution, Refund and RefundForWithdrawal return
+// ErrResolutionNotFound and ErrRefundNotFound when there is nothing stored for the id.
+type ResolutionStore interface {
+	SaveResolution(r *PaymentResolution) error
+	Resolution(txnID string) (*PaymentResolution, error)
+	SaveRefund(r *Refund) error
+	Refund(id string) (*Refund, error)
+	// Refunds returns the refunds with the status, oldest first
+	Refunds(status string) ([]Refund, error)
+	// PaymentRefunds returns every refund of the payment, oldest first
+	PaymentRefunds(txnID string) ([]Refund, error)
+	RefundForWithdrawal(withdrawalID string) (*Refund, error)
+}
+
+// MemoryResolutionStore is a ResolutionStore that keeps everything in memory
+type MemoryResolutionStore struct {
+	mu          sync.RWMutex
+	resolutions map[string]PaymentResolution
+	refunds     map[string]Refund
+}
+
+// NewMemoryResolutionStore returns an empty MemoryResolutionStore
+func NewMemoryResolutionStore() *MemoryResolutionStore {
+	return &MemoryResolutionStore{resolutions: map[string]PaymentResolution{}, refunds: map[string]Refund{}}
+}
+
+// SaveResolution implements the ResolutionStore interface
+func (s *MemoryResolutionStore) SaveResolution(r *PaymentResolution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolutions[r.TxnID] = *r
+	return nil
+}
+
+// Resolution implements the ResolutionStore interface
+func (s *MemoryResolutionStore) Resolution(txnID string) (*PaymentResolution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.resolutions[txnID]
+	if !ok {
+		return nil, ErrResolutionNotFound
+	}
+	return &r, nil
+}
+
+// SaveRefund implements the ResolutionStore interface
+func (s *MemoryResolutionStore) SaveRefund(r *Refund) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refunds[r.ID] = *r
+	return nil
+}
+
+// Refund implements the ResolutionStore interface
+func (s *MemoryResolutionStore) Refund(id string) (*Refund, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.refunds[id]
+	if !ok {
+		return nil, ErrRefundNotFound
+	}
+	return &r, nil
+}
+
+// Refunds implements the ResolutionStore interface
+func (s *MemoryResolutionStore) Refunds(status string) ([]Refund, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var refunds []Refund
+	for _, r := range s.refunds {
+		if r.Status == status {
+			refunds = append(refunds, r)
+		}
+	}
+	sort.Slice(refunds, func(i, j int) bool { return refunds[i].CreatedAt.Before(refunds[j].CreatedAt) })
+	return refunds, nil
+}
+
+// PaymentRefunds implements the ResolutionStore interface
+func (s *MemoryResolutionStore) PaymentRefunds(txnID string) ([]Refund, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var refunds []Refund
+	for _, r := range s.refunds {
+		if r.TxnID == txnID {
+			refunds = append(refunds, r)
+		}
+	}
+	sort.Slice(refunds, func(i, j int) bool { return refunds[i].CreatedAt.Before(refunds[j].CreatedAt) })
+	return refunds, nil
+}
+
+// RefundForWithdrawal implements the ResolutionStore interface
+func (s *MemoryResolutionStore) RefundForWithdrawal(withdrawalID string) (*Refund, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.refunds {
+		if r.WithdrawalID == withdrawalID {
+			return &r, nil
+		}
+	}
+	return nil, ErrRefundNotFound
+}
+
+// PaymentResolver classifies payments as underpaid, exact or overpaid by comparing received_amount against amount2,
+// and acts on the final ones: underpayments can get a top-up transaction for the shortfall, and the excess of
+// overpayments is queued to be refunded to the buyer.
+type PaymentResolver struct {
+	mu     sync.Mutex
+	client *Client
+	store  ResolutionStore
+	now    func() time.Time
+
+	// Tolerances, keyed by coin, are how far off payments can be and still count as exact. Coins missing from it use
+	// DefaultTolerance.
+	Tolerances       map[string]PaymentTolerance
+	DefaultTolerance PaymentTolerance
+
+	// TopUp, if set, creates a transaction for the shortfall of underpaid payments
+	TopUp bool
+	// RefundAddress, if set, returns the address the buyer gave for refunds of the payment. Refunds without one wait
+	// for SetRefundAddress.
+	RefundAddress func(ipn *IPNAPIResponse) (address, destTag string)
+}
+
+// NewPaymentResolver returns a PaymentResolver that treats every payment off by any amount as under or overpaid
+func NewPaymentResolver(client *Client, store ResolutionStore) *PaymentResolver {
+	return &PaymentResolver{client: client, store: store, now: time.Now, Tolerances: map[string]PaymentTolerance{}}
+}
+
+// tolerance returns the tolerance of the coin in satoshis
+func (r *PaymentResolver) tolerance(coin string) (under, over int64) {
+	t := r.DefaultTolerance
+	for k, v := range r.Tolerances {
+		if strings.EqualFold(k, coin) {
+			t = v
+		}
+	}
+	under, _ = ParseSatoshis(t.Under)
+	over, _ = ParseSatoshis(t.Over)
+	return under, over
+}
+
+// Classify returns the class of the payment of the IPN, and received minus expected in satoshis
+func (r *PaymentResolver) Classify(ipn *IPNAPIResponse) (string, int64, error) {
+	expected, err := ParseSatoshis(ipn.Amount2)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid amount2 %q", ipn.Amount2)
+	}
+	received, err := ParseSatoshis(ipn.ReceivedAmount)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid received_amount %q", ipn.ReceivedAmount)
+	}
+
+	diff := received - expected
+	under, over := r.tolerance(ipn.Currency2)
+	switch {
+	case diff < 0 && -diff > under:
+		return PaymentUnderpaid, diff, nil
+	case diff > 0 && diff > over:
+		return PaymentOverpaid, diff, nil
+	}
+	return PaymentExact, diff, nil
+}
+
+// Resolve classifies the payment of the IPN and acts on it once it's final, ie: complete, or timed out with
+// something received. It returns nil for payments that aren't final yet. Each payment is resolved once, later IPNs
+// for it return the stored resolution. The resolution is saved before anything is acted on, so a crash halfway
+// never leads to a second top-up or refund.
+func (r *PaymentResolver) Resolve(ipn *IPNAPIResponse) (*PaymentResolution, error) {
+	status, err := strconv.Atoi(ipn.Status)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status %q", ipn.Status)
+	}
+	received, _ := ParseSatoshis(ipn.ReceivedAmount)
+	if status < 100 && !(status < 0 && received > 0) {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, err := r.store.Resolution(ipn.TxnID); err == nil {
+		// finish what a crash or a refused top-up left undone
+		if existing.RefundID != "" {
+			if err := r.queueRefund(existing, ipn); err != nil {
+				return nil, err
+			}
+		}
+		if existing.TopUpTxnID == "" && existing.TopUpError != "" && !existing.TopUpPending && r.TopUp {
+			if err := r.topUp(existing, ipn); err != nil {
+				return existing, err
+			}
+		}
+		return existing, nil
+	} else if err != ErrResolutionNotFound {
+		return nil, err
+	}
+
+	class, diff, err := r.Classify(ipn)
+	if err != nil {
+		return nil, err
+	}
+	res := &PaymentResolution{
+		TxnID:      ipn.TxnID,
+		Currency:   strings.ToUpper(ipn.Currency2),
+		Expected:   ipn.Amount2,
+		Received:   ipn.ReceivedAmount,
+		Difference: FormatSatoshis(diff),
+		Class:      class,
+		ResolvedAt: r.now(),
+	}
+
+	switch {
+	case class == PaymentUnderpaid && r.TopUp:
+		if err := r.topUp(res, ipn); err != nil {
+			return res, err
+		}
+	case class == PaymentOverpaid:
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		res.RefundID = hex.EncodeToString(id)
+		if err := r.store.SaveResolution(res); err != nil {
+			return nil, err
+		}
+		if err := r.queueRefund(res, ipn); err != nil {
+			return nil, err
+		}
+	default:
+		if err := r.store.SaveResolution(res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// HandleAPI resolves the payment of an API IPN. Use it from IPNHandler.OnAPI.
+func (r *PaymentResolver) HandleAPI(ipn *IPNAPIResponse) error {
+	_, err := r.Resolve(ipn)
+	return err
+}
+
+// topUp creates a transaction for the shortfall of the payment. The resolution is saved as pending first, and only
+// cleared once the API answered, so an unknown outcome is never retried.
+func (r *PaymentResolver) topUp(res *PaymentResolution, ipn *IPNAPIResponse) error {
+	res.TopUpPending, res.TopUpError = true, ""
+	if err := r.store.SaveResolution(res); err != nil {
+		return err
+	}
+
+	shortfall, err := ParseSatoshis(strings.TrimPrefix(res.Difference, "-"))
+	if err != nil {
+		return err
+	}
+	topUp, err := r.client.CallCreateTransaction(&TransactionRequest{
+		Amount:     FormatSatoshis(shortfall),
+		Currency1:  ipn.Currency2,
+		Currency2:  ipn.Currency2,
+		BuyerEmail: ipn.Email,
+		BuyerName:  ipn.BuyerName,
+		ItemName:   strings.TrimSpace("Balance due for " + ipn.ItemName),
+		ItemNumber: ipn.ItemNumber,
+		Invoice:    ipn.Invoice,
+		Custom:     ipn.Custom,
+	})
+	switch {
+	case err == nil:
+		res.TopUpPending, res.TopUpTxnID = false, topUp.TxnID
+	case callRefused(err):
+		res.TopUpPending, res.TopUpError = false, err.Error()
+	default:
+		res.TopUpError = err.Error()
+	}
+	if saveErr := r.store.SaveResolution(res); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// queueRefund stores the refund of the excess of the payment, unless it's stored already
+func (r *PaymentResolver) queueRefund(res *PaymentResolution, ipn *IPNAPIResponse) error {
+	if _, err := r.store.Refund(res.RefundID); err != ErrRefundNotFound {
+		return err
+	}
+	excess, err := ParseSatoshis(res.Difference)
+	if err != nil {
+		return err
+	}
+
+	now := r.now()
+	refund := &Refund{
+		ID:        res.RefundID,
+		TxnID:     ipn.TxnID,
+		Currency:  res.Currency,
+		Amount:    FormatSatoshis(excess),
+		Status:    RefundAwaitingAddress,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if r.RefundAddress != nil {
+		refund.Address, refund.DestTag = r.RefundAddress(ipn)
+	}
+	if refund.Address != "" {
+		refund.Status = RefundQueued
+	}
+	return r.store.SaveRefund(refund)
+}
+
+// SetRefundAddress sets the address a refund is sent to, once the buyer gave one, and queues it
+func (r *PaymentResolver) SetRefundAddress(id, address, destTag string) (*Refund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	refund, err := r.store.Refund(id)
+	if err != nil {
+		return nil, err
+	}
+	if refund.Status != RefundAwaitingAddress && refund.Status != RefundQueued {
+		return nil, ErrRefundNotQueued
+	}
+	refund.Address, refund.DestTag, refund.Status = address, destTag, RefundQueued
+	refund.UpdatedAt = r.now()
+	if err := r.store.SaveRefund(refund); err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// ProcessRefunds sends every queued refund with a withdrawal. Each refund is saved as sending before its withdrawal
+// is made, so a refund can't be sent twice. Refunds the API refused are marked as failed with the error rather than
+// retried; after other errors the withdrawal may have gone out, so the refund stays sending with the error, see
+// RefundManager.ResolveSending.
+func (r *PaymentResolver) ProcessRefunds() ([]Refund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queued, err := r.store.Refunds(RefundQueued)
+	if err != nil {
+		return nil, err
+	}
+
+	var processed []Refund
+	for i := range queued {
+		refund := &queued[i]
+		refund.Status, refund.UpdatedAt = RefundSending, r.now()
+		if err := r.store.SaveRefund(refund); err != nil {
+			return processed, err
+		}
+
+		res, err := r.client.CallCreateWithdrawal(&WithdrawalRequest{
+			Amount:      refund.Amount,
+			Currency:    refund.Currency,
+			Address:     refund.Address,
+			DestTag:     refund.DestTag,
+			AutoConfirm: 1,
+			Note:        "refund of overpayment " + refund.TxnID,
+		})
+		switch {
+		case err == nil:
+			refund.Status, refund.WithdrawalID = RefundSent, res.ID
+		case callRefused(err):
+			refund.Status, refund.Error = RefundFailed, err.Error()
+		default:
+			refund.Error = err.Error()
+		}
+		refund.UpdatedAt = r.now()
+		if err := r.store.SaveRefund(refund); err != nil {
+			return processed, err
+		}
+		processed = append(processed, *refund)
+	}
+	return processed, nil
+}
@@ -0,0 +1,222 @@
+package coinpayments_test
+
+import (
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func TestPaymentResolverClassify(t *testing.T) {
+	resolver := coinpayments.NewPaymentResolver(offlineClient(t), coinpayments.NewMemoryResolutionStore())
+	resolver.Tolerances["BTC"] = coinpayments.PaymentTolerance{Under: "0.00001", Over: "0.0001"}
+
+	tests := []struct {
+		currency, received string
+		class              string
+		diff               int64
+	}{
+		{"BTC", "0.1", coinpayments.PaymentExact, 0},
+		{"BTC", "0.09999", coinpayments.PaymentExact, -1000},
+		{"BTC", "0.09998", coinpayments.PaymentUnderpaid, -2000},
+		{"btc", "0.1001", coinpayments.PaymentExact, 10000},
+		{"BTC", "0.2", coinpayments.PaymentOverpaid, 10000000},
+		{"LTC", "0.09999999", coinpayments.PaymentUnderpaid, -1},
+	}
+	for _, tt := range tests {
+		class, diff, err := resolver.Classify(&coinpayments.IPNAPIResponse{Currency2: tt.currency, Amount2: "0.1", ReceivedAmount: tt.received})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if class != tt.class || diff != tt.diff {
+			t.Errorf("Should have classified %s %s as %s by %d, got %s by %d", tt.received, tt.currency, tt.class, tt.diff, class, diff)
+		}
+	}
+}
+
+func TestPaymentResolverTopUp(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateTransaction: {`{"error":"ok","result":{"amount":"0.00002","txn_id":"TOPUP1"}}`},
+	}}
+	store := coinpayments.NewMemoryResolutionStore()
+	resolver := coinpayments.NewPaymentResolver(fakeClient(t, api), store)
+	resolver.TopUp = true
+
+	ipn := &coinpayments.IPNAPIResponse{Status: "0", TxnID: "TX1", Currency1: "USD", Currency2: "BTC", Amount2: "0.1", ReceivedAmount: "0.09998", Email: "buyer@example.com", Invoice: "INV-1"}
+	if res, err := resolver.Resolve(ipn); err != nil || res != nil {
+		t.Fatalf("Should not have resolved a pending payment, got %+v, %v", res, err)
+	}
+
+	ipn.Status = "-1"
+	res, err := resolver.Resolve(ipn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Class != coinpayments.PaymentUnderpaid || res.Difference != "-0.00002000" || res.TopUpTxnID != "TOPUP1" {
+		t.Fatalf("Should have topped up the underpaid payment, got %+v", res)
+	}
+
+	calls := api.callsFor(coinpayments.CmdCreateTransaction)
+	if len(calls) != 1 || calls[0].Get("amount") != "0.00002000" || calls[0].Get("currency1") != "BTC" || calls[0].Get("buyer_email") != "buyer@example.com" || calls[0].Get("invoice") != "INV-1" {
+		t.Fatalf("Should have created the top up for the buyer, got %v", calls)
+	}
+
+	// a repeated IPN gets the same resolution without another top up
+	if again, err := resolver.Resolve(ipn); err != nil || again.TopUpTxnID != "TOPUP1" {
+		t.Errorf("Should have returned the same resolution for a repeated IPN, got %+v, %v", again, err)
+	}
+	if calls := api.callsFor(coinpayments.CmdCreateTransaction); len(calls) != 1 {
+		t.Errorf("Should have created a single top up, got %d", len(calls))
+	}
+}
+
+func TestPaymentResolverRefunds(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CWREFUND","status":1,"amount":"0.05"}}`},
+	}}
+	store := coinpayments.NewMemoryResolutionStore()
+	resolver := coinpayments.NewPaymentResolver(fakeClient(t, api), store)
+
+	res, err := resolver.Resolve(&coinpayments.IPNAPIResponse{Status: "100", TxnID: "TX1", Currency2: "BTC", Amount2: "0.1", ReceivedAmount: "0.15"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Class != coinpayments.PaymentOverpaid || res.RefundID == "" {
+		t.Fatalf("Should have queued a refund of the overpayment, got %+v", res)
+	}
+
+	refund, err := store.Refund(res.RefundID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != coinpayments.RefundAwaitingAddress || refund.Amount != "0.05000000" {
+		t.Fatalf("Should have held the refund for an address, got %+v", refund)
+	}
+
+	if processed, err := resolver.ProcessRefunds(); err != nil || len(processed) != 0 {
+		t.Fatalf("Should not have sent anything without an address, got %+v, %v", processed, err)
+	}
+
+	if _, err := resolver.SetRefundAddress(res.RefundID, "1BuyerAddress", ""); err != nil {
+		t.Fatal(err)
+	}
+	processed, err := resolver.ProcessRefunds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processed) != 1 || processed[0].Status != coinpayments.RefundSent || processed[0].WithdrawalID != "CWREFUND" {
+		t.Fatalf("Should have sent the refund, got %+v", processed)
+	}
+
+	calls := api.callsFor(coinpayments.CmdCreateWithdrawal)
+	if len(calls) != 1 || calls[0].Get("address") != "1BuyerAddress" || calls[0].Get("amount") != "0.05000000" || calls[0].Get("currency") != "BTC" {
+		t.Fatalf("Should have withdrawn the overpayment to the refund address, got %v", calls)
+	}
+
+	if _, err := resolver.SetRefundAddress(res.RefundID, "1Other", ""); err != coinpayments.ErrRefundNotQueued {
+		t.Errorf("Should not have requeued a sent refund, got %v", err)
+	}
+}
+
+func TestPaymentResolverRefundAddress(t *testing.T) {
+	store := coinpayments.NewMemoryResolutionStore()
+	resolver := coinpayments.NewPaymentResolver(offlineClient(t), store)
+	resolver.RefundAddress = func(ipn *coinpayments.IPNAPIResponse) (string, string) { return "r" + ipn.Custom, "42" }
+
+	res, err := resolver.Resolve(&coinpayments.IPNAPIResponse{Status: "100", TxnID: "TX1", Currency2: "XRP", Amount2: "10", ReceivedAmount: "12", Custom: "Buyer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund, err := store.Refund(res.RefundID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != coinpayments.RefundQueued || refund.Address != "rBuyer" || refund.DestTag != "42" {
+		t.Errorf("Should have queued the refund to the resolved address, got %+v", refund)
+	}
+}
+
+func TestPaymentResolverTopUpRetries(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateTransaction: {`{"error":"Rate limited"}`, `{"error":"ok","result":{"amount":"0.00002","txn_id":"TOPUP2"}}`},
+	}}
+	store := coinpayments.NewMemoryResolutionStore()
+	resolver := coinpayments.NewPaymentResolver(fakeClient(t, api), store)
+	resolver.TopUp = true
+
+	ipn := &coinpayments.IPNAPIResponse{Status: "-1", TxnID: "TX1", Currency2: "BTC", Amount2: "0.1", ReceivedAmount: "0.09998"}
+	if _, err := resolver.Resolve(ipn); err == nil {
+		t.Fatal("Should have returned the refused top up, but it didn't")
+	}
+	if saved, err := store.Resolution("TX1"); err != nil || saved.TopUpPending || saved.TopUpError != "Rate limited" {
+		t.Fatalf("Should have saved the refused top up, got %+v, %v", saved, err)
+	}
+
+	res, err := resolver.Resolve(ipn)
+	if err != nil {
+		t.Fatalf("Should have retried the refused top up, but it threw error: %s", err.Error())
+	}
+	if res.TopUpTxnID != "TOPUP2" || res.TopUpError != "" {
+		t.Fatalf("Should have created the top up on the retry, got %+v", res)
+	}
+}
+
+func TestPaymentResolverTopUpTimeout(t *testing.T) {
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey"}, timeoutAPI{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := coinpayments.NewMemoryResolutionStore()
+	resolver := coinpayments.NewPaymentResolver(client, store)
+	resolver.TopUp = true
+
+	ipn := &coinpayments.IPNAPIResponse{Status: "-1", TxnID: "TX1", Currency2: "BTC", Amount2: "0.1", ReceivedAmount: "0.09998"}
+	if _, err := resolver.Resolve(ipn); err == nil {
+		t.Fatal("Should have returned the timeout, but it didn't")
+	}
+
+	// the top up may have been created, so the retried IPN leaves it alone
+	res, err := resolver.Resolve(ipn)
+	if err != nil || !res.TopUpPending || res.TopUpError == "" {
+		t.Fatalf("Should have kept the top up pending, got %+v, %v", res, err)
+	}
+}
+
+func TestPaymentResolverRefundOutcomes(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdCreateWithdrawal: {`{"error":"Insufficient funds"}`},
+	}}
+	store := coinpayments.NewMemoryResolutionStore()
+	resolver := coinpayments.NewPaymentResolver(fakeClient(t, api), store)
+	resolver.RefundAddress = func(ipn *coinpayments.IPNAPIResponse) (string, string) { return "1Buyer", "" }
+
+	refused, err := resolver.Resolve(&coinpayments.IPNAPIResponse{Status: "100", TxnID: "TX1", Currency2: "BTC", Amount2: "0.1", ReceivedAmount: "0.15"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed, err := resolver.ProcessRefunds(); err != nil || len(processed) != 1 || processed[0].Status != coinpayments.RefundFailed {
+		t.Fatalf("Should have failed the refund the API refused, got %+v, %v", processed, err)
+	}
+
+	client, err := coinpayments.NewClient(&coinpayments.Config{PublicKey: "publickey", PrivateKey: "privatekey"}, timeoutAPI{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver = coinpayments.NewPaymentResolver(client, store)
+	resolver.RefundAddress = func(ipn *coinpayments.IPNAPIResponse) (string, string) { return "1Buyer", "" }
+	timedOut, err := resolver.Resolve(&coinpayments.IPNAPIResponse{Status: "100", TxnID: "TX2", Currency2: "BTC", Amount2: "0.1", ReceivedAmount: "0.15"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed, err := resolver.ProcessRefunds(); err != nil || len(processed) != 1 || processed[0].Status != coinpayments.RefundSending || processed[0].Error == "" {
+		t.Fatalf("Should have kept the refund that timed out sending, got %+v, %v", processed, err)
+	}
+	if processed, err := resolver.ProcessRefunds(); err != nil || len(processed) != 0 {
+		t.Fatalf("Should not have sent the refund again, got %+v, %v", processed, err)
+	}
+
+	for _, id := range []string{refused.RefundID, timedOut.RefundID} {
+		if _, err := store.Refund(id); err != nil {
+			t.Errorf("Should have stored refund %s, but it threw error: %s", id, err.Error())
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package coinpayments
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StatusPage serves a status page for the transactions of a PaymentTracker, in place of the status_url of
+// CoinPayments, so buyers never leave your checkout. GET <txn_id> is the page and GET <txn_id>/events is a
+// Server-Sent Events stream of its status, which the page follows live.
+// It only knows the transactions tracked in this process: pages of transactions pruned from the tracker, or tracked
+// before a restart, are not found.
+// IE: http.Handle("/pay/", http.StripPrefix("/pay/", &coinpayments.StatusPage{Tracker: tracker, Title: "Corner Cafe"}))
+type StatusPage struct {
+	Tracker *PaymentTracker
+
+	// branding of the default template
+	Title   string // shop name
+	LogoURL string
+	Color   string // accent color, ie: #0a7f5a
+	// ReturnURL, if set, is linked to once the payment completes, ie: back to the order. {txn_id} is replaced with
+	// the id of the transaction.
+	ReturnURL string
+
+	// Template, if set, replaces the default page. It's executed with a *StatusPageData. Start from
+	// NewStatusPageTemplate to only redefine some of its blocks: "head", "header", "payment" or "footer".
+	Template *template.Template
+
+	// Heartbeat is how often idle event streams get a comment, so proxies don't close them. Defaults to 15 seconds.
+	Heartbeat time.Duration
+}
+
+// StatusPageData is what the status page template is executed with
+type StatusPageData struct {
+	Title     string
+	LogoURL   string
+	Color     string
+	ReturnURL string
+	EventsURL string       // of the event stream, relative to the page
+	URI       template.URL // the payment URI the QR code holds, for wallet links
+	QRCode    template.HTML
+	Status    PaymentStatus
+	Event     StatusEvent // the status as the event stream sends it, for the page's script
+}
+
+// StatusEvent is the data of the status events of the stream. Remaining is in seconds, so the countdown doesn't
+// depend on the buyer's clock.
+type StatusEvent struct {
+	PaymentStatus
+	Shortfall string `json:"shortfall,omitempty"`
+	Remaining int64  `json:"remaining"`
+	Complete  bool   `json:"complete"`
+	Cancelled bool   `json:"cancelled"`
+}
+
+// ServeHTTP implements the http.Handler interface
+func (p *StatusPage) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Trim(req.URL.Path, "/")
+	txnID, events := path, false
+	if strings.HasSuffix(path, "/events") {
+		txnID, events = strings.TrimSuffix(path, "/events"), true
+	}
+	if txnID == "" || strings.Contains(txnID, "/") {
+		http.NotFound(w, req)
+		return
+	}
+	status, err := p.Tracker.Status(txnID)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	if events {
+		p.serveEvents(w, req, txnID)
+		return
+	}
+	p.servePage(w, req, status)
+}
+
+// servePage renders the page of the status
+func (p *StatusPage) servePage(w http.ResponseWriter, req *http.Request, status PaymentStatus) {
+	data := &StatusPageData{
+		Title:     p.Title,
+		LogoURL:   p.LogoURL,
+		Color:     p.Color,
+		EventsURL: status.TxnID + "/events",
+		Status:    status,
+		Event:     p.event(status),
+	}
+	if strings.HasSuffix(req.URL.Path, "/") {
+		data.EventsURL = "events"
+	}
+	if data.Color == "" {
+		data.Color = "#1f6feb"
+	}
+	if p.ReturnURL != "" {
+		data.ReturnURL = strings.Replace(p.ReturnURL, "{txn_id}", status.TxnID, -1)
+	}
+	uri := &PaymentURI{Coin: status.Coin, Address: status.Address, Amount: status.Amount, DestTag: status.DestTag, Label: p.Title}
+	if encoded, err := uri.Encode(); err == nil {
+		data.URI = template.URL(encoded)
+	} else {
+		data.URI = template.URL(status.Address)
+	}
+	if code, err := uri.QRCode(); err == nil {
+		// our own markup, nothing of the buyer's in it
+		data.QRCode = template.HTML(code.SVG(6))
+	}
+
+	tmpl := p.Template
+	if tmpl == nil {
+		tmpl = defaultStatusPageTemplate
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// serveEvents streams the status of the transaction until it's final or the buyer goes away
+func (p *StatusPage) serveEvents(w http.ResponseWriter, req *http.Request, txnID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	updates, unsubscribe, err := p.Tracker.Subscribe(txnID)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	heartbeat := p.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case status := <-updates:
+			data, err := json.Marshal(p.event(status))
+			if err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("event: status\ndata: " + string(data) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+			if status.Final() {
+				// the page closes its EventSource on a final status, rather than reconnecting
+				return
+			}
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// event returns the event of the status
+func (p *StatusPage) event(status PaymentStatus) StatusEvent {
+	e := StatusEvent{
+		PaymentStatus: status,
+		Remaining:     int64(status.Remaining(p.Tracker.now()) / time.Second),
+		Complete:      status.Complete(),
+		Cancelled:     status.Cancelled(),
+	}
+	if shortfall := status.Shortfall(); shortfall > 0 {
+		e.Shortfall = FormatSatoshis(shortfall)
+	}
+	return e
+}
+
+// NewStatusPageTemplate returns a new copy of the default status page template, to redefine some of its blocks.
+// IE: template.Must(coinpayments.NewStatusPageTemplate().Parse(`{{define "footer"}}Questions? Ask at the till.{{end}}`))
+func NewStatusPageTemplate() *template.Template {
+	return template.Must(template.New("status").Parse(statusPageTemplate))
+}
+
+var defaultStatusPageTemplate = NewStatusPageTemplate()
+
+// statusPageTemplate is the default status page. The ids of its elements are what the script updates.
+const statusPageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Title}}{{.Title}} - {{end}}Payment {{.Status.TxnID}}</title>
+{{block "head" .}}<style>
+body { font-family: system-ui, sans-serif; background: #f4f5f7; color: #222; margin: 0; }
+main { max-width: 26rem; margin: 2rem auto; background: #fff; border-radius: 8px; padding: 1.5rem; box-shadow: 0 1px 4px rgba(0,0,0,.1); }
+header { display: flex; align-items: center; gap: .75rem; border-bottom: 3px solid {{.Color}}; padding-bottom: .75rem; }
+header img { max-height: 2.5rem; }
+h1 { font-size: 1.2rem; margin: 0; }
+.qr { text-align: center; margin: 1rem 0; }
+.qr svg { max-width: 100%; height: auto; }
+dl { display: grid; grid-template-columns: auto 1fr; gap: .4rem 1rem; }
+dt { color: #666; }
+dd { margin: 0; word-break: break-all; }
+#status { font-weight: bold; color: {{.Color}}; }
+.done #qr, .done #countdown-row { display: none; }
+#return { display: none; margin-top: 1rem; text-align: center; }
+.complete #return { display: block; }
+a.button { background: {{.Color}}; color: #fff; padding: .6rem 1.2rem; border-radius: 4px; text-decoration: none; }
+footer { color: #888; font-size: .8rem; margin-top: 1rem; text-align: center; }
+</style>{{end}}
+</head>
+<body>
+<main id="page" class="{{if .Event.Complete}}done complete{{else if .Event.Cancelled}}done cancelled{{end}}">
+{{block "header" .}}<header>{{if .LogoURL}}<img src="{{.LogoURL}}" alt="">{{end}}<h1>{{if .Title}}{{.Title}}{{else}}Payment{{end}}</h1></header>{{end}}
+{{block "payment" .}}<div class="qr" id="qr"><a href="{{.URI}}">{{.QRCode}}</a></div>
+<dl>
+<dt>Status</dt><dd id="status">{{.Status.StatusText}}</dd>
+<dt>Amount due</dt><dd><span id="amount">{{.Status.Amount}}</span> {{.Status.Coin}}</dd>
+<dt>Received</dt><dd><span id="received">{{.Status.Received}}</span> {{.Status.Coin}}<span id="shortfall">{{if .Event.Shortfall}}, {{.Event.Shortfall}} short{{end}}</span></dd>
+<dt>Confirmations</dt><dd><span id="confirms">{{.Status.Confirms}}</span> of {{.Status.ConfirmsNeeded}}</dd>
+<dt>Address</dt><dd>{{.Status.Address}}</dd>
+{{if .Status.DestTag}}<dt>Tag / memo</dt><dd>{{.Status.DestTag}}</dd>{{end}}
+<dt id="countdown-row">Time left</dt><dd id="countdown"></dd>
+</dl>
+{{if .ReturnURL}}<div id="return"><a class="button" href="{{.ReturnURL}}">Continue</a></div>{{end}}{{end}}
+{{block "footer" .}}<footer>Transaction {{.Status.TxnID}}</footer>{{end}}
+</main>
+<script>
+(function() {
+	var state = {{.Event}};
+	var deadline = Date.now() + state.remaining * 1000;
+	function text(id, value) { var el = document.getElementById(id); if (el) el.textContent = value; }
+	function countdown() {
+		var left = Math.max(0, Math.round((deadline - Date.now()) / 1000));
+		if (!state.expires || state.expires.indexOf("0001-") === 0 || state.complete || state.cancelled) { text("countdown", ""); return; }
+		var m = Math.floor(left / 60), s = left % 60;
+		text("countdown", left > 0 ? m + ":" + (s < 10 ? "0" : "") + s : "expired");
+	}
+	function render() {
+		text("status", state.status_text);
+		text("received", state.received);
+		text("confirms", state.confirms);
+		text("shortfall", state.shortfall ? ", " + state.shortfall + " short" : "");
+		var page = document.getElementById("page");
+		page.className = state.complete ? "done complete" : state.cancelled ? "done cancelled" : "";
+		countdown();
+	}
+	render();
+	setInterval(countdown, 1000);
+	if (window.EventSource && !state.complete && !state.cancelled) {
+		var events = new EventSource({{.EventsURL}});
+		events.addEventListener("status", function(e) {
+			state = JSON.parse(e.data);
+			deadline = Date.now() + state.remaining * 1000;
+			render();
+			if (state.complete || state.cancelled) events.close();
+		});
+	}
+})();
+</script>
+</body>
+</html>
+`
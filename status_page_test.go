@@ -0,0 +1,134 @@
+package coinpayments_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+func statusPageTracker(t *testing.T) *coinpayments.PaymentTracker {
+	tracker := coinpayments.NewPaymentTracker(offlineClient(t))
+	tracker.Track("BTC", &coinpayments.TransactionResult{TxnID: "TX1", Amount: "0.01000000", Address: "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", ConfirmsNeeded: "3", Timeout: 900})
+	return tracker
+}
+
+func TestStatusPage(t *testing.T) {
+	tracker := statusPageTracker(t)
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "0", StatusText: "Waiting for confirmations", ReceivedAmount: "0.004", ReceivedConfirms: "1"})
+	page := &coinpayments.StatusPage{Tracker: tracker, Title: "Corner <Cafe>", Color: "#0a7f5a", ReturnURL: "https://shop.example.com/orders?txn={txn_id}"}
+
+	w := httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("GET", "/TX1", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("Should have served the page as html, got %d %v", w.Code, w.Header())
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"Corner &lt;Cafe&gt;",
+		`<span id="amount">0.01000000</span> BTC`,
+		`<span id="received">0.004</span> BTC`,
+		"0.00600000 short",
+		`<span id="confirms">1</span> of 3`,
+		`href="bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.01&amp;label=Corner%20%3CCafe%3E"`,
+		"<svg",
+		"border-bottom: 3px solid #0a7f5a",
+		`href="https://shop.example.com/orders?txn=TX1"`,
+		`new EventSource("TX1/events")`,
+		`"remaining":899`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Should have had %q on the page", expected)
+		}
+	}
+
+	for _, path := range []string{"/", "/OTHER", "/OTHER/events", "/TX1/other/events"} {
+		w := httptest.NewRecorder()
+		page.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Should have returned 404 for %s, got %d", path, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("POST", "/TX1", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Should have returned 405 for a POST, got %d", w.Code)
+	}
+}
+
+func TestStatusPageTemplate(t *testing.T) {
+	tmpl := template.Must(coinpayments.NewStatusPageTemplate().Parse(`{{define "footer"}}<footer>Ask at the till about {{.Status.TxnID}}</footer>{{end}}`))
+	page := &coinpayments.StatusPage{Tracker: statusPageTracker(t), Template: tmpl}
+
+	w := httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("GET", "/TX1", nil))
+	if body := w.Body.String(); !strings.Contains(body, "Ask at the till about TX1") || !strings.Contains(body, `id="received"`) {
+		t.Errorf("Should have replaced the footer and kept the rest:\n%s", body)
+	}
+
+	// the default template is left alone
+	w = httptest.NewRecorder()
+	(&coinpayments.StatusPage{Tracker: page.Tracker}).ServeHTTP(w, httptest.NewRequest("GET", "/TX1", nil))
+	if body := w.Body.String(); strings.Contains(body, "Ask at the till") || !strings.Contains(body, "Transaction TX1") {
+		t.Error("Should have kept the default footer, but it didn't")
+	}
+}
+
+func TestStatusPageEvents(t *testing.T) {
+	tracker := statusPageTracker(t)
+	server := httptest.NewServer(http.StripPrefix("/pay/", &coinpayments.StatusPage{Tracker: tracker, Heartbeat: 10 * time.Millisecond}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/pay/TX1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Should have served an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	next := func() coinpayments.StatusEvent {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				var event coinpayments.StatusEvent
+				if err := json.Unmarshal([]byte(line[len("data: "):]), &event); err != nil {
+					t.Fatal(err)
+				}
+				return event
+			}
+		}
+	}
+
+	if event := next(); event.TxnID != "TX1" || event.Received != "0" || event.Remaining < 890 {
+		t.Errorf("Should have sent the current status first, got %+v", event)
+	}
+
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "0", StatusText: "Waiting for confirmations", ReceivedAmount: "0.01", ReceivedConfirms: "1"})
+	if event := next(); event.Received != "0.01" || event.Confirms != 1 || event.Shortfall != "" || event.Complete {
+		t.Errorf("Should have sent the confirming payment, got %+v", event)
+	}
+
+	tracker.HandleAPI(&coinpayments.IPNAPIResponse{TxnID: "TX1", Status: "100", StatusText: "Complete", ReceivedAmount: "0.01", ReceivedConfirms: "3"})
+	if event := next(); !event.Complete || event.Confirms != 3 {
+		t.Errorf("Should have completed the payment, got %+v", event)
+	}
+
+	// the stream ends with the final status
+	for {
+		if _, err := reader.ReadString('\n'); err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package coinpayments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoForwardingAddress is returned when a coin is swept but has no forwarding address configured
+var ErrNoForwardingAddress = errors.New("no forwarding address configured for coin")
+
+// ForwardingAddress returns the cold wallet address the coin is swept to. ForwardingAddresses takes precedence over
+// BTCForwardingAddress and ETHForwardingAddress.
+func (c *Client) ForwardingAddress(coin string) string {
+	coin = strings.ToUpper(coin)
+	for k, address := range c.ForwardingAddresses {
+		if strings.ToUpper(k) == coin && address != "" {
+			return address
+		}
+	}
+	switch coin {
+	case "BTC":
+		return c.BTCForwardingAddress
+	case "ETH":
+		return c.ETHForwardingAddress
+	}
+	return ""
+}
+
+// SweepRule configures the sweeping of a single coin. Amounts are decimals in the coin, ie: "0.5".
+type SweepRule struct {
+	// Threshold is the balance above which the coin is swept
+	Threshold string
+	// Keep is how much is left in the hot wallet after a sweep. Defaults to Threshold.
+	Keep string
+	// MinWithdrawal is the smallest amount worth sweeping, smaller sweeps are skipped until the balance grows
+	MinWithdrawal string
+	// Fee is held back from the sweep when AddTxFee is set, since the network fee is then paid on top of the amount
+	Fee      string
+	AddTxFee bool
+	// DestTag is sent along with the withdrawal, for coins like XRP whose cold wallet needs one
+	DestTag string
+}
+
+// SweepAction is what the sweeper did, or would have done on a dry run, for a single coin
+type SweepAction struct {
+	Time         time.Time `json:"time"`
+	Coin         string    `json:"coin"`
+	Balance      string    `json:"balance"`
+	Amount       string    `json:"amount,omitempty"`
+	Address      string    `json:"address,omitempty"`
+	DestTag      string    `json:"dest_tag,omitempty"`
+	DryRun       bool      `json:"dry_run,omitempty"`
+	Skipped      string    `json:"skipped,omitempty"` // why nothing was swept
+	WithdrawalID string    `json:"withdrawal_id,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Sweeper watches the balances of the account and withdraws whatever goes over the threshold of a coin to its
+// forwarding address. Withdrawals go through Call, so the Policy and AuditLog of the client apply to them.
+type Sweeper struct {
+	mu     sync.Mutex
+	client *Client
+	now    func() time.Time
+
+	// Rules, keyed by coin, are the coins to sweep. Coins missing from it are never swept.
+	Rules map[string]SweepRule
+	// DryRun works out the sweeps without making any withdrawals
+	DryRun bool
+	// Audit, if set, gets every SweepAction written to it as a line of JSON
+	Audit io.Writer
+	// OnError, if set, is called by Run with the errors of failed passes
+	OnError func(err error)
+}
+
+// NewSweeper returns a Sweeper for the client with no rules
+func NewSweeper(client *Client) *Sweeper {
+	return &Sweeper{client: client, now: time.Now, Rules: map[string]SweepRule{}}
+}
+
+// Sweep makes a single pass over the balances and returns an action for every coin with a rule. Failed withdrawals
+// are reported on their action, the error is only for the balances call or writing the audit output.
+func (s *Sweeper) Sweep() ([]SweepAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balances, err := s.client.CallBalances(&BalancesRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	coins := make([]string, 0, len(s.Rules))
+	for coin := range s.Rules {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+
+	var actions []SweepAction
+	for _, coin := range coins {
+		action := s.sweep(strings.ToUpper(coin), s.Rules[coin], balances[strings.ToUpper(coin)])
+		if s.Audit != nil {
+			line, _ := json.Marshal(action)
+			if _, err := s.Audit.Write(append(line, '\n')); err != nil {
+				return actions, err
+			}
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// sweep works out, and unless it's a dry run sends, the withdrawal for a single coin
+func (s *Sweeper) sweep(coin string, rule SweepRule, balance BalancesResult) SweepAction {
+	action := SweepAction{Time: s.now(), Coin: coin, Balance: balance.Balancef, DryRun: s.DryRun}
+	if action.Balance == "" {
+		action.Balance = "0"
+	}
+
+	amount, err := sweepAmount(action.Balance, rule)
+	if err != nil {
+		action.Error = err.Error()
+		return action
+	}
+	if amount <= 0 {
+		action.Skipped = "balance is not over the threshold"
+		return action
+	}
+	if rule.MinWithdrawal != "" {
+		min, err := ParseSatoshis(rule.MinWithdrawal)
+		if err != nil {
+			action.Error = fmt.Sprintf("invalid minimum withdrawal %q", rule.MinWithdrawal)
+			return action
+		}
+		if amount < min {
+			action.Skipped = fmt.Sprintf("%s is under the minimum withdrawal of %s", FormatSatoshis(amount), rule.MinWithdrawal)
+			return action
+		}
+	}
+
+	action.Amount = FormatSatoshis(amount)
+	action.Address = s.client.ForwardingAddress(coin)
+	action.DestTag = rule.DestTag
+	if action.Address == "" {
+		action.Error = ErrNoForwardingAddress.Error()
+		return action
+	}
+	if s.DryRun {
+		return action
+	}
+
+	req := &WithdrawalRequest{Amount: action.Amount, Currency: coin, Address: action.Address, DestTag: rule.DestTag, AutoConfirm: 1, Note: "hot wallet sweep"}
+	if rule.AddTxFee {
+		req.AddTxFee = 1
+	}
+	res, err := s.client.CallCreateWithdrawal(req)
+	if err != nil {
+		action.Error = err.Error()
+		return action
+	}
+	action.WithdrawalID = res.ID
+	return action
+}
+
+// sweepAmount returns how much of the balance goes over what the rule keeps, in satoshis
+func sweepAmount(balance string, rule SweepRule) (int64, error) {
+	b, err := ParseSatoshis(balance)
+	if err != nil {
+		return 0, fmt.Errorf("invalid balance %q", balance)
+	}
+	threshold, err := ParseSatoshis(rule.Threshold)
+	if err != nil {
+		return 0, fmt.Errorf("invalid threshold %q", rule.Threshold)
+	}
+	if b <= threshold {
+		return 0, nil
+	}
+
+	keep := threshold
+	if rule.Keep != "" {
+		if keep, err = ParseSatoshis(rule.Keep); err != nil {
+			return 0, fmt.Errorf("invalid keep %q", rule.Keep)
+		}
+	}
+	amount := b - keep
+	if rule.AddTxFee && rule.Fee != "" {
+		fee, err := ParseSatoshis(rule.Fee)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fee %q", rule.Fee)
+		}
+		amount -= fee
+	}
+	return amount, nil
+}
+
+// Run sweeps every interval until stop is closed
+func (s *Sweeper) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Sweep(); err != nil && s.OnError != nil {
+			s.OnError(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
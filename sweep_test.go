@@ -0,0 +1,129 @@
+package coinpayments_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jeffwalsh/go-coinpayments"
+)
+
+const sweepBalances = `{"error":"ok","result":{
+	"BTC":{"balance":150000000,"balancef":"1.50000000"},
+	"ETH":{"balance":300000000,"balancef":"3.00000000"},
+	"LTC":{"balance":1000000000,"balancef":"10.00000000"},
+	"XRP":{"balance":10000000000,"balancef":"100.00000000"}}}`
+
+func sweepClient(t *testing.T, api *fakeAPI) *coinpayments.Client {
+	client := fakeClient(t, api)
+	client.BTCForwardingAddress = "1ColdBitcoin"
+	client.ETHForwardingAddress = "0xColdEther"
+	client.ForwardingAddresses = map[string]string{"ltc": "LColdLitecoin"}
+	return client
+}
+
+func TestForwardingAddress(t *testing.T) {
+	client := offlineClient(t)
+	client.BTCForwardingAddress = "1ColdBitcoin"
+	client.ForwardingAddresses = map[string]string{"BTC": "bc1qcold", "ltc": "LColdLitecoin"}
+
+	tests := map[string]string{"btc": "bc1qcold", "LTC": "LColdLitecoin", "ETH": "", "DOGE": ""}
+	for coin, want := range tests {
+		if got := client.ForwardingAddress(coin); got != want {
+			t.Errorf("Should have had %q for %s, got %q", want, coin, got)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{
+		coinpayments.CmdBalances:         {sweepBalances},
+		coinpayments.CmdCreateWithdrawal: {`{"error":"ok","result":{"id":"CWBTC","status":1,"amount":"1.0"}}`, `{"error":"ok","result":{"id":"CWLTC","status":1,"amount":"8.0"}}`},
+	}}
+	client := sweepClient(t, api)
+
+	var audit bytes.Buffer
+	sweeper := coinpayments.NewSweeper(client)
+	sweeper.Audit = &audit
+	sweeper.Rules["BTC"] = coinpayments.SweepRule{Threshold: "1", Keep: "0.5"}
+	sweeper.Rules["ETH"] = coinpayments.SweepRule{Threshold: "5"}
+	sweeper.Rules["LTC"] = coinpayments.SweepRule{Threshold: "2", AddTxFee: true, Fee: "0.001"}
+	sweeper.Rules["XRP"] = coinpayments.SweepRule{Threshold: "20", DestTag: "1234"}
+	sweeper.Rules["DOGE"] = coinpayments.SweepRule{Threshold: "0"}
+
+	actions, err := sweeper.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byCoin := map[string]coinpayments.SweepAction{}
+	for _, a := range actions {
+		byCoin[a.Coin] = a
+	}
+	if a := byCoin["BTC"]; a.Amount != "1.00000000" || a.Address != "1ColdBitcoin" || a.WithdrawalID != "CWBTC" {
+		t.Errorf("Should have swept the BTC over its threshold, got %+v", a)
+	}
+	if a := byCoin["ETH"]; a.Skipped == "" || a.Amount != "" {
+		t.Errorf("Should have skipped ETH under its threshold, got %+v", a)
+	}
+	if a := byCoin["LTC"]; a.Amount != "7.99900000" || a.Address != "LColdLitecoin" || a.WithdrawalID != "CWLTC" {
+		t.Errorf("Should have swept the LTC over its threshold, got %+v", a)
+	}
+	if a := byCoin["XRP"]; a.Error != coinpayments.ErrNoForwardingAddress.Error() {
+		t.Errorf("Should have failed XRP without a forwarding address, got %+v", a)
+	}
+	if a := byCoin["DOGE"]; a.Skipped == "" || a.Balance != "0" {
+		t.Errorf("Should have skipped DOGE without a balance, got %+v", a)
+	}
+
+	calls := api.callsFor(coinpayments.CmdCreateWithdrawal)
+	if len(calls) != 2 {
+		t.Fatalf("Should have made 2 withdrawals, got %d", len(calls))
+	}
+	if calls[0].Get("address") != "1ColdBitcoin" || calls[0].Get("amount") != "1.00000000" || calls[0].Get("currency") != "BTC" {
+		t.Errorf("Should have withdrawn the BTC to its forwarding address, got %v", calls[0])
+	}
+	if calls[1].Get("add_tx_fee") != "1" {
+		t.Errorf("Should have added the tx fee to the LTC withdrawal, got %v", calls[1])
+	}
+
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != len(actions) {
+		t.Fatalf("Should have written an audit line per action, got %d", len(lines))
+	}
+	var first coinpayments.SweepAction
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Coin != actions[0].Coin {
+		t.Errorf("Should have written the actions to the audit output, got %+v", first)
+	}
+}
+
+func TestSweepMinimumAndDryRun(t *testing.T) {
+	api := &fakeAPI{responses: map[string][]string{coinpayments.CmdBalances: {sweepBalances}}}
+	client := sweepClient(t, api)
+
+	sweeper := coinpayments.NewSweeper(client)
+	sweeper.DryRun = true
+	sweeper.Rules["BTC"] = coinpayments.SweepRule{Threshold: "1.4", MinWithdrawal: "0.2"}
+	sweeper.Rules["ETH"] = coinpayments.SweepRule{Threshold: "1"}
+
+	actions, err := sweeper.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("Should have returned 2 actions, got %d", len(actions))
+	}
+	if a := actions[0]; a.Coin != "BTC" || a.Skipped == "" {
+		t.Errorf("Should have skipped the BTC sweep under the minimum withdrawal, got %+v", a)
+	}
+	if a := actions[1]; a.Coin != "ETH" || !a.DryRun || a.Amount != "2.00000000" || a.Address != "0xColdEther" {
+		t.Errorf("Should have planned the ETH sweep on a dry run, got %+v", a)
+	}
+	if calls := api.callsFor(coinpayments.CmdCreateWithdrawal); len(calls) != 0 {
+		t.Errorf("Should not have made withdrawals on a dry run, got %d", len(calls))
+	}
+}
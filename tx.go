@@ -34,6 +34,7 @@ type TransactionResult struct {
 	StatusURL      string `json:"status_url"`
 	CheckoutURL    string `json:"checkout_url"`
 	QRCodeURL      string `json:"qrcode_url"`
+	DestTag        string `json:"dest_tag,omitempty"` // for coins needing a destination tag or memo
 }
 
 // TransactionResponse is the response we expect from the API server.
@@ -94,6 +95,7 @@ func (c *Client) CallCreateTransaction(req *TransactionRequest) (*TransactionRes
 type CallbackAddressRequest struct {
 	Currency string `json:"currency"`
 	IPNURL   string `json:"ipn_url"`
+	Label    string `json:"label,omitempty"` // optional label shown for the address in the CoinPayments dashboard
 }
 
 // CallbackAddressResult is a result from the API for a transaction command
@@ -116,6 +118,9 @@ func (c *Client) CallGetCallbackAddress(req *CallbackAddressRequest) (*CallbackA
 	data := url.Values{}
 	data.Add("currency", req.Currency)
 	data.Add("ipn_url", req.IPNURL)
+	if req.Label != "" {
+		data.Add("label", req.Label)
+	}
 
 	// make the actual call and unmarshal the response into our TransactionResponse struct
 	var response CallbackAddressResponse
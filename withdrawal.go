@@ -2,15 +2,25 @@ package coinpayments
 
 import (
 	"net/url"
+	"strconv"
 )
 
-// WithdrawalRequest is what we sent to the API
+// WithdrawalRequest is what we sent to the API. Transfers go to a MerchantID or PBNTag, while withdrawals go to an
+// Address or PBNTag.
 type WithdrawalRequest struct {
 	Amount      string `json:"amount"`
 	Currency    string `json:"currency"`
 	MerchantID  string `json:"merchant_id"`
 	PBNTag      string `json:"pbntag"`
 	AutoConfirm int    `json:"auto_confirm"`
+
+	// withdrawals only
+	Address   string `json:"address,omitempty"`
+	DestTag   string `json:"dest_tag,omitempty"`  // for coins needing a destination tag or memo
+	Currency2 string `json:"currency2,omitempty"` // optional currency to use to withdraw 'amount' worth of 'currency'
+	AddTxFee  int    `json:"add_tx_fee,omitempty"`
+	IPNURL    string `json:"ipn_url,omitempty"`
+	Note      string `json:"note,omitempty"`
 }
 
 // WithdrawalResult is a result from the API for a Withdrawal command
@@ -28,6 +38,9 @@ type WithdrawalResponse struct {
 
 // CallCreateTransfer calls the create_Withdrawal command on the API
 func (c *Client) CallCreateTransfer(req *WithdrawalRequest) (*WithdrawalResult, error) {
+	if err := c.checkDestination(req); err != nil {
+		return nil, err
+	}
 
 	// add in data specific to this Withdrawal, then forward the request to the call method
 	data := url.Values{}
@@ -45,3 +58,81 @@ func (c *Client) CallCreateTransfer(req *WithdrawalRequest) (*WithdrawalResult,
 	// return the entire result to be used
 	return response.Result, nil
 }
+
+// CallCreateWithdrawal calls the create_withdrawal command on the API. The ID of the result is the id sent back
+// with the withdrawal IPN.
+func (c *Client) CallCreateWithdrawal(req *WithdrawalRequest) (*WithdrawalResult, error) {
+	if err := c.checkDestination(req); err != nil {
+		return nil, err
+	}
+
+	// add in data specific to this Withdrawal, then forward the request to the call method
+	data := url.Values{}
+	data.Add("amount", req.Amount)
+	data.Add("currency", req.Currency)
+	data.Add("auto_confirm", strconv.Itoa(req.AutoConfirm))
+
+	if req.Address != "" {
+		data.Add("address", req.Address)
+	}
+	if req.PBNTag != "" {
+		data.Add("pbntag", req.PBNTag)
+	}
+	if req.DestTag != "" {
+		data.Add("dest_tag", req.DestTag)
+	}
+	if req.Currency2 != "" {
+		data.Add("currency2", req.Currency2)
+	}
+	if req.AddTxFee != 0 {
+		data.Add("add_tx_fee", strconv.Itoa(req.AddTxFee))
+	}
+	if req.IPNURL != "" {
+		data.Add("ipn_url", req.IPNURL)
+	} else if c.IPNURL != "" {
+		data.Add("ipn_url", c.IPNURL)
+	}
+	if req.Note != "" {
+		data.Add("note", req.Note)
+	}
+
+	// make the actual call and unmarshal the response into our WithdrawalResponse struct
+	var response WithdrawalResponse
+	if err := c.Call(CmdCreateWithdrawal, data, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Result, nil
+}
+
+// WithdrawalInfoResult is the result of the get_withdrawal_info command. Status uses the same values as the withdrawal
+// IPN, ie: 2 once it's been sent.
+type WithdrawalInfoResult struct {
+	TimeCreated int64  `json:"time_created"`
+	Status      int    `json:"status"`
+	StatusText  string `json:"status_text"`
+	Coin        string `json:"coin"`
+	Amount      int64  `json:"amount"` // in satoshis
+	Amountf     string `json:"amountf"`
+	SendAddress string `json:"send_address"`
+	SendTxID    string `json:"send_txid"` // only present once the withdrawal has been sent
+}
+
+// WithdrawalInfoResponse is the response we expect from the API server for the get_withdrawal_info command
+type WithdrawalInfoResponse struct {
+	ErrorResponse
+	Result *WithdrawalInfoResult `json:"result"`
+}
+
+// CallGetWithdrawalInfo calls the get_withdrawal_info command on the API, with the id returned by CallCreateWithdrawal
+func (c *Client) CallGetWithdrawalInfo(id string) (*WithdrawalInfoResult, error) {
+	data := url.Values{}
+	data.Add("id", id)
+
+	var response WithdrawalInfoResponse
+	if err := c.Call(CmdGetWithdrawalInfo, data, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Result, nil
+}